@@ -2,6 +2,9 @@ package addons
 
 import (
 	"testing"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/goldcoin"
 )
 
 func TestRegisterModule(t *testing.T) {
@@ -245,3 +248,108 @@ func TestLendingModuleCreateLoan(t *testing.T) {
 		t.Errorf("Expected loan status 'active', got '%s'", loan.Status)
 	}
 }
+
+func TestStakingModuleAutoCompound(t *testing.T) {
+	registry := NewModuleRegistry()
+	module := NewStakingModule()
+
+	registry.Register(module, "Bituncoin Team")
+	registry.Enable("Advanced Staking", map[string]interface{}{
+		"compound_interval": 3600.0,
+		"treasury_balance":  100.0,
+	})
+
+	result, err := registry.Execute("Advanced Staking", "stake", map[string]interface{}{
+		"pool_id": "gld-locked",
+		"address": "address1",
+		"amount":  1000.0,
+	})
+	if err != nil {
+		t.Fatalf("Failed to stake: %v", err)
+	}
+
+	stake, ok := result.(*goldcoin.Stake)
+	if !ok {
+		t.Fatal("Expected result to be *goldcoin.Stake")
+	}
+
+	if !stake.AutoCompound || stake.CompoundInterval != 3600 {
+		t.Errorf("Expected auto-compound every 3600s for gld-locked, got %v every %d", stake.AutoCompound, stake.CompoundInterval)
+	}
+
+	if _, err := registry.Execute("Advanced Staking", "fund_rewards", map[string]interface{}{
+		"pool_id": "gld-locked",
+		"amount":  100.0,
+	}); err != nil {
+		t.Fatalf("Failed to fund rewards: %v", err)
+	}
+
+	// Let rewards accrue and the compounding interval pass
+	time.Sleep(10 * time.Millisecond)
+	stake.LastCompoundTime -= 3600 * int64(time.Second)
+
+	result, _ = registry.Execute("Advanced Staking", "compound", nil)
+	if result.(int) != 1 {
+		t.Errorf("Expected 1 position compounded, got %v", result)
+	}
+
+	result, err = registry.Execute("Advanced Staking", "compound_history", map[string]interface{}{
+		"pool_id":     "gld-locked",
+		"position_id": stake.ID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get compound history: %v", err)
+	}
+
+	if len(result.([]goldcoin.CompoundEvent)) != 1 {
+		t.Error("Expected one compounding event")
+	}
+}
+
+func TestStakingModuleCompoundsInBackground(t *testing.T) {
+	registry := NewModuleRegistry()
+	registry.Register(NewStakingModule(), "Bituncoin Team")
+	registry.Enable("Advanced Staking", map[string]interface{}{
+		"compound_interval":       1.0,
+		"compound_check_interval": 0.05,
+		"treasury_balance":        100.0,
+	})
+
+	result, err := registry.Execute("Advanced Staking", "stake", map[string]interface{}{
+		"pool_id": "gld-locked",
+		"address": "address1",
+		"amount":  1000.0,
+	})
+	if err != nil {
+		t.Fatalf("Failed to stake: %v", err)
+	}
+	positionID := result.(*goldcoin.Stake).ID
+
+	registry.Execute("Advanced Staking", "fund_rewards", map[string]interface{}{
+		"pool_id": "gld-locked",
+		"amount":  100.0,
+	})
+
+	history := func() int {
+		result, _ := registry.Execute("Advanced Staking", "compound_history", map[string]interface{}{
+			"pool_id":     "gld-locked",
+			"position_id": positionID,
+		})
+		return len(result.([]goldcoin.CompoundEvent))
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for history() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if history() == 0 {
+		t.Fatal("Expected the running module to compound without an explicit action")
+	}
+
+	if err := registry.Disable("Advanced Staking"); err != nil {
+		t.Fatalf("Failed to disable module: %v", err)
+	}
+	if err := registry.Enable("Advanced Staking", nil); err != nil {
+		t.Errorf("Expected the module to restart after being stopped, got %v", err)
+	}
+}
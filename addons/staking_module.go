@@ -3,16 +3,25 @@ package addons
 import (
 	"errors"
 	"sync"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/goldcoin"
 )
 
+// defaultCompoundCheck is how often running pools look for positions due to compound
+const defaultCompoundCheck = time.Minute
+
 // StakingModule implements advanced staking features as an add-on
 type StakingModule struct {
-	name        string
-	version     string
-	status      ModuleStatus
-	config      map[string]interface{}
-	stakePools  map[string]*AdvancedStakePool
-	mutex       sync.RWMutex
+	name             string
+	version          string
+	status           ModuleStatus
+	config           map[string]interface{}
+	stakePools       map[string]*AdvancedStakePool
+	treasury         *goldcoin.Treasury // funds the pools' reward reserves
+	compoundInterval int64         // seconds between compounding of AutoCompound positions
+	compoundCheck    time.Duration // how often auto-compounding pools are processed
+	mutex            sync.RWMutex
 }
 
 // AdvancedStakePool represents an advanced staking pool
@@ -27,6 +36,16 @@ type AdvancedStakePool struct {
 	MaxCapacity     float64
 	AutoCompound    bool
 	PoolRewards     float64
+	positions       *goldcoin.StakingPool
+}
+
+// newPositionPool creates the staking pool holding an advanced pool's positions
+func newPositionPool(pool *AdvancedStakePool) *goldcoin.StakingPool {
+	sp := goldcoin.NewStakingPool()
+	sp.AnnualReward = pool.APY
+	sp.MinStake = pool.MinStake
+	sp.LockPeriod = pool.LockPeriod
+	return sp
 }
 
 // NewStakingModule creates a new staking add-on module
@@ -66,6 +85,19 @@ func (sm *StakingModule) Initialize(config map[string]interface{}) error {
 	
 	sm.config = config
 	
+	sm.compoundInterval = goldcoin.DefaultCompoundInterval
+	if interval, ok := config["compound_interval"].(float64); ok && interval > 0 {
+		sm.compoundInterval = int64(interval)
+	}
+	
+	treasuryBalance, _ := config["treasury_balance"].(float64)
+	sm.treasury = goldcoin.NewTreasury(treasuryBalance)
+	
+	sm.compoundCheck = defaultCompoundCheck
+	if check, ok := config["compound_check_interval"].(float64); ok && check > 0 {
+		sm.compoundCheck = time.Duration(check * float64(time.Second))
+	}
+	
 	// Create default staking pools
 	sm.stakePools["gld-flexible"] = &AdvancedStakePool{
 		ID:           "gld-flexible",
@@ -89,23 +121,53 @@ func (sm *StakingModule) Initialize(config map[string]interface{}) error {
 		AutoCompound: true,
 	}
 	
+	for _, pool := range sm.stakePools {
+		pool.positions = newPositionPool(pool)
+	}
+	
 	return nil
 }
 
-// Start starts the module
+// Start starts the module and background compounding of auto-compounding pools
 func (sm *StakingModule) Start() error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
+	if sm.status == StatusEnabled {
+		return nil
+	}
+	
+	started := make([]*AdvancedStakePool, 0, len(sm.stakePools))
+	for _, pool := range sm.stakePools {
+		if !pool.AutoCompound {
+			continue
+		}
+		if err := pool.positions.StartAutoCompounding(sm.compoundCheck); err != nil {
+			for _, p := range started {
+				p.positions.StopAutoCompounding() //nolint:errcheck // started above
+			}
+			return err
+		}
+		started = append(started, pool)
+	}
+	
 	sm.status = StatusEnabled
 	return nil
 }
 
-// Stop stops the module
+// Stop stops the module and its background compounding
 func (sm *StakingModule) Stop() error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	
+	if sm.status == StatusEnabled {
+		for _, pool := range sm.stakePools {
+			if pool.AutoCompound {
+				pool.positions.StopAutoCompounding() //nolint:errcheck // started by Start
+			}
+		}
+	}
+	
 	sm.status = StatusDisabled
 	return nil
 }
@@ -137,6 +199,21 @@ func (sm *StakingModule) Execute(action string, params map[string]interface{}) (
 	case "create_pool":
 		return sm.createPool(params)
 		
+	case "stake":
+		return sm.stake(params)
+		
+	case "compound":
+		return sm.compound()
+		
+	case "compound_history":
+		return sm.compoundHistory(params)
+		
+	case "fund_rewards":
+		return sm.fundRewards(params)
+		
+	case "treasury_balance":
+		return sm.treasury.GetBalance(), nil
+		
 	default:
 		return nil, errors.New("unknown action")
 	}
@@ -145,6 +222,7 @@ func (sm *StakingModule) Execute(action string, params map[string]interface{}) (
 func (sm *StakingModule) listPools() []AdvancedStakePool {
 	pools := make([]AdvancedStakePool, 0, len(sm.stakePools))
 	for _, pool := range sm.stakePools {
+		pool.TotalStaked = pool.positions.GetTotalStaked()
 		pools = append(pools, *pool)
 	}
 	return pools
@@ -155,6 +233,7 @@ func (sm *StakingModule) getPool(poolID string) (*AdvancedStakePool, error) {
 	if !exists {
 		return nil, errors.New("pool not found")
 	}
+	pool.TotalStaked = pool.positions.GetTotalStaked()
 	return pool, nil
 }
 
@@ -169,6 +248,10 @@ func (sm *StakingModule) createPool(params map[string]interface{}) (*AdvancedSta
 		return nil, errors.New("name required")
 	}
 	
+	if _, exists := sm.stakePools[id]; exists {
+		return nil, errors.New("pool already exists")
+	}
+	
 	pool := &AdvancedStakePool{
 		ID:          id,
 		Name:        name,
@@ -178,6 +261,120 @@ func (sm *StakingModule) createPool(params map[string]interface{}) (*AdvancedSta
 		LockPeriod:  30 * 24 * 60 * 60,
 	}
 	
+	if autoCompound, ok := params["auto_compound"].(bool); ok {
+		pool.AutoCompound = autoCompound
+	}
+	pool.positions = newPositionPool(pool)
+	
+	if pool.AutoCompound && sm.status == StatusEnabled {
+		if err := pool.positions.StartAutoCompounding(sm.compoundCheck); err != nil {
+			return nil, err
+		}
+	}
+	
 	sm.stakePools[id] = pool
 	return pool, nil
 }
+
+func (sm *StakingModule) stake(params map[string]interface{}) (*goldcoin.Stake, error) {
+	poolID, ok := params["pool_id"].(string)
+	if !ok {
+		return nil, errors.New("pool_id required")
+	}
+
+	address, ok := params["address"].(string)
+	if !ok {
+		return nil, errors.New("address required")
+	}
+
+	amount, ok := params["amount"].(float64)
+	if !ok {
+		return nil, errors.New("amount required")
+	}
+
+	pool, err := sm.getPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxCapacity > 0 && pool.TotalStaked+amount > pool.MaxCapacity {
+		return nil, errors.New("pool capacity exceeded")
+	}
+
+	stake, err := pool.positions.CreateStake(address, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.AutoCompound {
+		if err := pool.positions.SetAutoCompound(stake.ID, true, sm.compoundInterval); err != nil {
+			return nil, err
+		}
+	}
+
+	pool.TotalStaked = pool.positions.GetTotalStaked()
+	return stake, nil
+}
+
+// compound restakes due rewards in every auto-compounding pool and returns
+// the number of positions compounded
+func (sm *StakingModule) compound() (int, error) {
+	compounded := 0
+	for _, pool := range sm.stakePools {
+		if !pool.AutoCompound {
+			continue
+		}
+
+		n, err := pool.positions.ProcessAutoCompound()
+		if err != nil {
+			return compounded, err
+		}
+		compounded += n
+		pool.TotalStaked = pool.positions.GetTotalStaked()
+	}
+	return compounded, nil
+}
+
+func (sm *StakingModule) compoundHistory(params map[string]interface{}) ([]goldcoin.CompoundEvent, error) {
+	poolID, ok := params["pool_id"].(string)
+	if !ok {
+		return nil, errors.New("pool_id required")
+	}
+
+	positionID, ok := params["position_id"].(string)
+	if !ok {
+		return nil, errors.New("position_id required")
+	}
+
+	pool, err := sm.getPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.positions.GetCompoundHistory(positionID)
+}
+
+// fundRewards moves treasury funds into a pool's reward reserve and returns
+// the pool's runway in days
+func (sm *StakingModule) fundRewards(params map[string]interface{}) (float64, error) {
+	poolID, ok := params["pool_id"].(string)
+	if !ok {
+		return 0, errors.New("pool_id required")
+	}
+
+	amount, ok := params["amount"].(float64)
+	if !ok {
+		return 0, errors.New("amount required")
+	}
+
+	pool, err := sm.getPool(poolID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := pool.positions.FundRewards(sm.treasury, amount); err != nil {
+		return 0, err
+	}
+
+	return pool.positions.GetRewardRunway(), nil
+}
@@ -2,14 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Bituncoin/Bituncoin/addons"
 	"github.com/Bituncoin/Bituncoin/auth"
+	"github.com/Bituncoin/Bituncoin/consensus"
+	"github.com/Bituncoin/Bituncoin/core"
+	"github.com/Bituncoin/Bituncoin/goldcoin"
 	"github.com/Bituncoin/Bituncoin/network"
 	"github.com/Bituncoin/Bituncoin/payments"
+	"github.com/Bituncoin/Bituncoin/storage"
 )
 
 // Node represents a blockchain node API server
@@ -23,6 +31,45 @@ type Node struct {
 	accounts   *auth.AccountManager
 	addons     *addons.ModuleRegistry
 	p2pNetwork *network.Network
+	chain      *core.Blockchain
+	sync       *network.SyncManager
+	discovery  *network.Discovery
+	gossip     *network.Gossip
+	goldcoin   *goldcoin.GoldCoin
+	timeLocks  *goldcoin.TimeLockPool
+	db         storage.KV
+	staking    *goldcoin.StakingPool
+	pos        *consensus.ProofOfStake
+	stop       chan struct{}
+}
+
+// timeLockInterval is how often held time-locked transactions are checked
+const timeLockInterval = 10 * time.Second
+
+// NodeConfig configures a node
+type NodeConfig struct {
+	Host string
+	Port int
+
+	// DataDir holds the node key, known peers, bans and persistent state.
+	// Empty keeps state in memory and gives the node a new ID on every start.
+	DataDir string
+
+	// Seeds are "host:port" or "nodeID@host:port" addresses of nodes to
+	// bootstrap from when no known peer can be reached
+	Seeds []string
+
+	// Fees selects the Gold-Coin fee model
+	Fees goldcoin.FeeConfig
+}
+
+// DefaultNodeConfig returns the configuration of a node on host and port
+func DefaultNodeConfig(host string, port int) NodeConfig {
+	return NodeConfig{
+		Host: host,
+		Port: port,
+		Fees: goldcoin.FeeConfig{Model: goldcoin.FeeModelPercentage, Rate: 0.001},
+	}
 }
 
 // NodeInfo represents node information
@@ -34,24 +81,159 @@ type NodeInfo struct {
 	BlockHeight int    `json:"blockHeight"`
 }
 
-// NewNode creates a new API node
+// NewNode creates a new API node with the default configuration
 func NewNode(host string, port int) *Node {
-	p2pAddr := fmt.Sprintf("%s:%d", host, port+1)
-	net, err := network.NewNetwork(p2pAddr)
+	node, err := NewNodeWithConfig(DefaultNodeConfig(host, port))
+	if err != nil {
+		panic(fmt.Sprintf("BTNG: failed to initialize node: %v", err))
+	}
+	return node
+}
+
+// NewNodeWithConfig creates a new API node
+func NewNodeWithConfig(cfg NodeConfig) (*Node, error) {
+	p2pAddr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port+1)
+	net, err := openNetwork(p2pAddr, cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize P2P network on %s: %w", p2pAddr, err)
+	}
+
+	discovery, err := openDiscovery(net, cfg.DataDir, cfg.Seeds)
+	if err != nil {
+		return nil, err
+	}
+
+	feeModel, err := goldcoin.NewFeeModel(cfg.Fees)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := goldcoin.NewGoldCoin()
+	gc.SetFeeModel(feeModel)
+
+	db, err := openStore(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := core.NewBlockchain()
+	pos, staking, err := openConsensusState(db, chain)
 	if err != nil {
-		panic(fmt.Sprintf("BTNG: failed to initialize P2P network on %s: %v", p2pAddr, err))
+		db.Close()
+		return nil, err
 	}
 
-	return &Node{
-		Port:       port,
-		Host:       host,
+	gc.SetBlockHeight(uint64(chain.GetLatestBlock().Index))
+
+	node := &Node{
+		Port:       cfg.Port,
+		Host:       cfg.Host,
 		IsRunning:  false,
 		endpoints:  make(map[string]http.HandlerFunc),
 		payments:   payments.NewBtnPay(),
 		accounts:   auth.NewAccountManager(),
 		addons:     addons.NewModuleRegistry(),
 		p2pNetwork: net,
+		chain:      chain,
+		sync:       network.NewSyncManager(net, chain),
+		discovery:  discovery,
+		gossip:     network.NewGossip(net),
+		goldcoin:   gc,
+		timeLocks:  goldcoin.NewTimeLockPool(),
+		db:         db,
+		staking:    staking,
+		pos:        pos,
+	}
+	node.gossip.SetTransactionHandler(node.acceptTransaction)
+	node.sync.SetBlockHandler(node.blockAdded)
+
+	return node, nil
+}
+
+// openNetwork creates the P2P network. A node with a data directory keeps
+// its key and bans there, so its node ID and bans survive restarts.
+func openNetwork(p2pAddr, dataDir string) (*network.Network, error) {
+	if dataDir == "" {
+		return network.NewNetwork(p2pAddr)
+	}
+
+	key, err := network.LoadOrCreateNodeKey(filepath.Join(dataDir, "node.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	net, err := network.NewNetworkWithKey(p2pAddr, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := net.PersistBans(filepath.Join(dataDir, "bans.json")); err != nil {
+		return nil, err
+	}
+	return net, nil
+}
+
+// openDiscovery creates peer discovery bootstrapped from seeds. A node with
+// a data directory remembers the peers it learns there.
+func openDiscovery(net *network.Network, dataDir string, seeds []string) (*network.Discovery, error) {
+	path := ""
+	if dataDir != "" {
+		path = filepath.Join(dataDir, "peers.json")
+	}
+
+	book, err := network.NewAddressBook(path)
+	if err != nil {
+		return nil, err
+	}
+
+	discovery := network.NewDiscovery(net, book, nil)
+	if err := discovery.SetSeeds(seeds); err != nil {
+		return nil, err
+	}
+	return discovery, nil
+}
+
+// openStore opens the node's store in dataDir, or in memory if dataDir is empty
+func openStore(dataDir string) (storage.KV, error) {
+	if dataDir == "" {
+		return storage.NewMemDB(), nil
+	}
+
+	db, err := storage.NewLevelDB(filepath.Join(dataDir, "chaindata"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open node storage in %s: %w", dataDir, err)
 	}
+	return db, nil
+}
+
+// openConsensusState restores the PoS and staking state saved in db and
+// checks both against the tip of chain, failing if either has diverged
+func openConsensusState(db storage.KV, chain *core.Blockchain) (*consensus.ProofOfStake, *goldcoin.StakingPool, error) {
+	pos, err := consensus.NewProofOfStakeWithStore(db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to restore PoS state: %w", err)
+	}
+
+	latest := chain.GetLatestBlock()
+	if err := pos.CheckConsistency(latest.Index, latest.Hash); err != nil {
+		return nil, nil, err
+	}
+
+	staking, err := goldcoin.NewStakingPoolWithStore(db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to restore staking state: %w", err)
+	}
+
+	if err := staking.CheckConsistency(uint64(latest.Index), latest.Hash); err != nil {
+		return nil, nil, err
+	}
+
+	// Record the tip the staking state was verified against
+	if err := staking.SetChainTip(uint64(latest.Index), latest.Hash); err != nil {
+		return nil, nil, err
+	}
+
+	return pos, staking, nil
 }
 
 // Start starts the API node server
@@ -63,6 +245,12 @@ func (n *Node) Start() error {
 		return fmt.Errorf("node already running")
 	}
 
+	if err := n.p2pNetwork.Start(); err != nil {
+		return err
+	}
+	n.sync.Start()      //nolint:errcheck
+	n.discovery.Start() //nolint:errcheck
+
 	// Register default endpoints
 	n.registerEndpoints()
 
@@ -77,6 +265,9 @@ func (n *Node) Start() error {
 		http.ListenAndServe(addr, mux)
 	}()
 
+	n.stop = make(chan struct{})
+	go n.releaseTimeLocks(n.stop)
+
 	n.IsRunning = true
 	return nil
 }
@@ -90,10 +281,34 @@ func (n *Node) Stop() error {
 		return fmt.Errorf("node not running")
 	}
 
+	close(n.stop)
+	n.stopNetwork()
+
 	n.IsRunning = false
 	return nil
 }
 
+// Close stops the node if it is running and releases its storage
+func (n *Node) Close() error {
+	n.mutex.Lock()
+	if n.IsRunning {
+		close(n.stop)
+		n.stopNetwork()
+		n.IsRunning = false
+	}
+	n.mutex.Unlock()
+
+	return n.db.Close()
+}
+
+// stopNetwork stops syncing and discovery, saving known peers, and
+// disconnects from the P2P network; callers must hold the lock
+func (n *Node) stopNetwork() {
+	n.discovery.Stop() //nolint:errcheck
+	n.sync.Stop()
+	n.p2pNetwork.Stop() //nolint:errcheck
+}
+
 // registerEndpoints registers API endpoints
 func (n *Node) registerEndpoints() {
 	n.endpoints["/api/info"] = n.handleInfo
@@ -102,6 +317,9 @@ func (n *Node) registerEndpoints() {
 	n.endpoints["/api/goldcoin/send"] = n.handleSend
 	n.endpoints["/api/goldcoin/stake"] = n.handleStake
 	n.endpoints["/api/goldcoin/validators"] = n.handleValidators
+	n.endpoints["/api/goldcoin/fees/estimate"] = n.handleEstimateFee
+	n.endpoints["/api/goldcoin/timelock"] = n.handleTimeLock
+	n.endpoints["/api/goldcoin/timelock/pending"] = n.handleTimeLockPending
 
 	// BTN-PAY endpoints
 	n.endpoints["/api/btnpay/invoice"] = n.payments.CreateInvoiceHandler
@@ -131,6 +349,9 @@ func (n *Node) registerEndpoints() {
 	n.endpoints["/api/network/peers"] = n.handleNetworkPeers
 	n.endpoints["/api/network/connect"] = n.handleNetworkConnect
 	n.endpoints["/api/network/disconnect"] = n.handleNetworkDisconnect
+	n.endpoints["/api/network/bans"] = n.handleNetworkBans
+	n.endpoints["/api/network/ban"] = n.handleNetworkBan
+	n.endpoints["/api/network/unban"] = n.handleNetworkUnban
 }
 
 // handleInfo returns node information
@@ -140,7 +361,7 @@ func (n *Node) handleInfo(w http.ResponseWriter, r *http.Request) {
 		Network:     "bituncoin-mainnet",
 		NodeType:    "full-node",
 		IsRunning:   n.IsRunning,
-		BlockHeight: 0,
+		BlockHeight: n.chain.GetLatestBlock().Index,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -201,15 +422,33 @@ func (n *Node) handleStake(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var stakeData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&stakeData); err != nil {
+	var req struct {
+		Address string  `json:"address"`
+		Amount  float64 `json:"amount"`
+		Tier    string  `json:"tier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	var stake *goldcoin.Stake
+	var err error
+	if req.Tier != "" {
+		stake, err = n.staking.CreateTieredStake(req.Address, req.Amount, req.Tier)
+	} else {
+		stake, err = n.staking.CreateStake(req.Address, req.Amount)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	response := map[string]interface{}{
-		"status": "success",
-		"staked": stakeData["amount"],
+		"status":     "success",
+		"positionId": stake.ID,
+		"staked":     stake.Amount,
+		"unlockTime": stake.UnlockTime,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -218,25 +457,167 @@ func (n *Node) handleStake(w http.ResponseWriter, r *http.Request) {
 
 // handleValidators returns validator information
 func (n *Node) handleValidators(w http.ResponseWriter, r *http.Request) {
+	validators := make([]map[string]interface{}, 0)
+	for _, v := range n.pos.GetAllValidators() {
+		validators = append(validators, map[string]interface{}{
+			"address": v.Address,
+			"stake":   v.StakedAmount,
+			"active":  v.IsActive,
+		})
+	}
+
+	response := map[string]interface{}{
+		"validators": validators,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleEstimateFee returns the fee a transfer would pay under the active fee model
+func (n *Node) handleEstimateFee(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "A positive amount is required", http.StatusBadRequest)
+		return
+	}
+
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "From and to addresses are required", http.StatusBadRequest)
+		return
+	}
+
+	model := n.goldcoin.GetFeeModel()
 	response := map[string]interface{}{
-		"validators": []map[string]interface{}{
-			{
-				"address": "GLDvalidator1...",
-				"stake":   10000.0,
-				"active":  true,
-			},
-			{
-				"address": "GLDvalidator2...",
-				"stake":   20000.0,
-				"active":  true,
-			},
-		},
+		"fee":      n.goldcoin.EstimateFee(from, to, amount),
+		"feeModel": model.Name(),
+	}
+	if baseFee, ok := model.(*goldcoin.BaseFeeModel); ok {
+		response["baseFee"] = baseFee.BaseFee()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleTimeLock creates a time-locked transaction and holds it until eligible
+func (n *Node) handleTimeLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		From       string  `json:"from"`
+		To         string  `json:"to"`
+		Amount     float64 `json:"amount"`
+		LockHeight uint64  `json:"lockHeight"`
+		LockTime   int64   `json:"lockTime"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := n.goldcoin.CreateTimeLockedTransaction(req.From, req.To, req.Amount, req.LockHeight, req.LockTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.timeLocks.Add(n.goldcoin, tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
+
+// handleTimeLockPending returns the held time-locked transactions
+func (n *Node) handleTimeLockPending(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.timeLocks.Pending())
+}
+
+// releaseTimeLocks applies held time-locked transactions as they become
+// eligible until stop is closed
+func (n *Node) releaseTimeLocks(stop chan struct{}) {
+	ticker := time.NewTicker(timeLockInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.applyEligibleTimeLocks(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// blockAdded moves Gold-Coin to the new chain height: the block's fees are
+// burned and the fee model adjusted, held transactions that expired are
+// dropped and those that became eligible are released
+func (n *Node) blockAdded(block *core.Block) {
+	n.goldcoin.ProcessBlockFees(blockTransactions(block))
+
+	height := uint64(block.Index)
+	n.goldcoin.SetBlockHeight(height)
+	n.timeLocks.PruneExpired(height)
+	n.applyEligibleTimeLocks(time.Now())
+}
+
+// blockTransactions decodes the Gold-Coin transactions in a block, skipping
+// entries that are not transactions
+func blockTransactions(block *core.Block) []*goldcoin.Transaction {
+	txs := make([]*goldcoin.Transaction, 0, len(block.Transactions))
+	for _, data := range block.Transactions {
+		var tx goldcoin.Transaction
+		if err := json.Unmarshal([]byte(data), &tx); err == nil && tx.ID != "" {
+			txs = append(txs, &tx)
+		}
+	}
+	return txs
+}
+
+// acceptTransaction applies a transaction relayed by a peer, holding a
+// time-locked one until it is eligible. A transaction ahead of the sender's
+// nonce is left for when the earlier ones arrive.
+func (n *Node) acceptTransaction(peer *network.Peer, id string, data json.RawMessage) error {
+	var tx goldcoin.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return err
+	}
+
+	if tx.IsTimeLocked() && !tx.IsEligible(n.goldcoin.GetBlockHeight(), time.Now().Unix()) {
+		return n.timeLocks.Add(n.goldcoin, &tx)
+	}
+
+	err := n.goldcoin.ApplyTransaction(&tx)
+	if errors.Is(err, goldcoin.ErrNonceGap) {
+		return network.ErrTransactionNotReady
+	}
+	return err
+}
+
+// applyEligibleTimeLocks applies the held transactions eligible at now and
+// returns how many were applied
+func (n *Node) applyEligibleTimeLocks(now time.Time) int {
+	applied := 0
+	for _, tx := range n.timeLocks.ReleaseEligible(n.goldcoin.GetBlockHeight(), now.Unix()) {
+		// A transaction that no longer validates is dropped
+		if err := n.goldcoin.ApplyTransaction(tx); err == nil {
+			applied++
+		}
+	}
+	return applied
+}
+
 // GetNodeInfo returns current node information
 func (n *Node) GetNodeInfo() NodeInfo {
 	n.mutex.RLock()
@@ -247,7 +628,7 @@ func (n *Node) GetNodeInfo() NodeInfo {
 		Network:     "bituncoin-mainnet",
 		NodeType:    "full-node",
 		IsRunning:   n.IsRunning,
-		BlockHeight: 0,
+		BlockHeight: n.chain.GetLatestBlock().Index,
 	}
 }
 
@@ -616,3 +997,77 @@ func (n *Node) handleNetworkDisconnect(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
+
+// handleNetworkBans returns the active peer bans
+func (n *Node) handleNetworkBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.p2pNetwork.GetBans())
+}
+
+// handleNetworkBan bans a node ID or IP address
+func (n *Node) handleNetworkBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Target          string `json:"target"`
+		DurationSeconds int64  `json:"durationSeconds"`
+		Reason          string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Target == "" {
+		http.Error(w, "Target is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.DurationSeconds < 0 {
+		http.Error(w, "Duration must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if req.Reason == "" {
+		req.Reason = "banned by operator"
+	}
+
+	n.p2pNetwork.Ban(req.Target, time.Duration(req.DurationSeconds)*time.Second, req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleNetworkUnban lifts the ban on a node ID or IP address
+func (n *Node) handleNetworkUnban(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Target string `json:"target"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Target == "" {
+		http.Error(w, "Target is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := n.p2pNetwork.Unban(req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
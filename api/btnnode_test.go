@@ -0,0 +1,484 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/consensus"
+	"github.com/Bituncoin/Bituncoin/core"
+	"github.com/Bituncoin/Bituncoin/goldcoin"
+	"github.com/Bituncoin/Bituncoin/network"
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+func TestEstimateFeeUsesConfiguredModel(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.Fees = goldcoin.FeeConfig{Model: goldcoin.FeeModelPerByte, FeePerByte: 0.001}
+
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	node.handleEstimateFee(rec, httptest.NewRequest(http.MethodGet, "/api/goldcoin/fees/estimate?from=a&to=b&amount=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var response map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&response) //nolint:errcheck
+
+	if response["feeModel"] != goldcoin.FeeModelPerByte {
+		t.Errorf("Expected the per_byte model, got %v", response["feeModel"])
+	}
+
+	if fee := response["fee"].(float64); fee != node.goldcoin.EstimateFee("a", "b", 10) || fee <= 0 {
+		t.Errorf("Expected the model's estimate, got %v", fee)
+	}
+
+	rec = httptest.NewRecorder()
+	node.handleEstimateFee(rec, httptest.NewRequest(http.MethodGet, "/api/goldcoin/fees/estimate?from=a&to=b", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without an amount, got %d", rec.Code)
+	}
+}
+
+func TestInvalidFeeConfigRejected(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.Fees = goldcoin.FeeConfig{Model: "auction"}
+
+	if _, err := NewNodeWithConfig(cfg); err == nil {
+		t.Error("Expected an unknown fee model to be rejected")
+	}
+}
+
+func TestTimeLockedTransactionsAreReleased(t *testing.T) {
+	node := NewNode("127.0.0.1", 0)
+
+	body := `{"from":"a","to":"b","amount":10,"lockHeight":5}`
+	rec := httptest.NewRecorder()
+	node.handleTimeLock(rec, httptest.NewRequest(http.MethodPost, "/api/goldcoin/timelock", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if applied := node.applyEligibleTimeLocks(time.Now()); applied != 0 {
+		t.Errorf("Expected nothing applied before the lock height, got %d", applied)
+	}
+
+	node.goldcoin.SetBlockHeight(5)
+	if applied := node.applyEligibleTimeLocks(time.Now()); applied != 1 {
+		t.Errorf("Expected the transaction applied at the lock height, got %d", applied)
+	}
+
+	if node.timeLocks.Size() != 0 {
+		t.Error("Expected the pool to be empty after release")
+	}
+}
+
+func TestStakingStateSurvivesRestart(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.DataDir = t.TempDir()
+
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+
+	body := `{"address":"address1","amount":500}`
+	rec := httptest.NewRecorder()
+	node.handleStake(rec, httptest.NewRequest(http.MethodPost, "/api/goldcoin/stake", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	node.Close()
+
+	restarted, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to restart node: %v", err)
+	}
+	defer restarted.Close()
+
+	if staked := restarted.staking.GetAddressStaked("address1"); staked != 500.0 {
+		t.Errorf("Expected 500.0 staked after restart, got %f", staked)
+	}
+}
+
+func TestNodeRejectsConsensusStateAheadOfChain(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.DataDir = t.TempDir()
+
+	// PoS state saved by a node whose chain reached block 1
+	db, err := storage.NewLevelDB(filepath.Join(cfg.DataDir, "chaindata"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	pos, _ := consensus.NewProofOfStakeWithStore(db)
+	pos.RegisterValidator("validator1", 2000.0)
+	if _, err := pos.CreateBlock([]string{"tx1"}, core.NewBlockchain().GetLatestBlock().Hash); err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+	db.Close()
+
+	if node, err := NewNodeWithConfig(cfg); err == nil {
+		node.Close()
+		t.Error("Expected a node to refuse PoS state ahead of its chain")
+	}
+}
+
+func TestNodeRejectsStakingStateFromAnotherChain(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.DataDir = t.TempDir()
+
+	db, err := storage.NewLevelDB(filepath.Join(cfg.DataDir, "chaindata"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	staking, _ := goldcoin.NewStakingPoolWithStore(db)
+	staking.SetChainTip(0, "other-genesis")
+	db.Close()
+
+	if node, err := NewNodeWithConfig(cfg); err == nil {
+		node.Close()
+		t.Error("Expected a node to refuse staking state from another chain")
+	}
+}
+
+func TestNodeIDSurvivesRestart(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.DataDir = t.TempDir()
+
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	id := node.p2pNetwork.GetNodeID()
+	node.Close()
+
+	restarted, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to restart node: %v", err)
+	}
+	defer restarted.Close()
+
+	if restarted.p2pNetwork.GetNodeID() != id {
+		t.Errorf("Expected node ID %s after restart, got %s", id, restarted.p2pNetwork.GetNodeID())
+	}
+}
+
+func TestBansSurviveRestart(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.DataDir = t.TempDir()
+
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	node.p2pNetwork.Ban("10.0.0.9", time.Hour, "test")
+	node.Close()
+
+	restarted, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to restart node: %v", err)
+	}
+	defer restarted.Close()
+
+	if !restarted.p2pNetwork.IsBanned("10.0.0.9") {
+		t.Error("Expected the ban to survive a restart")
+	}
+}
+
+// freePort returns a TCP port on the loopback address that is not in use
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// freeNodeConfig returns a config whose P2P port is free
+func freeNodeConfig(t *testing.T) NodeConfig {
+	return DefaultNodeConfig("127.0.0.1", freePort(t)-1)
+}
+
+// addTestBlocks appends count blocks to chain
+func addTestBlocks(t *testing.T, chain *core.Blockchain, count int) {
+	for i := 0; i < count; i++ {
+		latest := chain.GetLatestBlock()
+		block := &core.Block{Index: latest.Index + 1, PrevHash: latest.Hash, Transactions: []string{fmt.Sprintf("tx-%d", latest.Index+1)}}
+		block.Hash = block.CalculateHash()
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to build chain: %v", err)
+		}
+	}
+}
+
+// startSyncSource starts a network serving chain and returns its address
+func startSyncSource(t *testing.T, chain *core.Blockchain) (*network.Network, string) {
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	source, _ := network.NewNetwork(addr)
+	network.NewSyncManager(source, chain).Start() //nolint:errcheck
+	if err := source.Start(); err != nil {
+		t.Fatalf("Failed to start source: %v", err)
+	}
+	return source, addr
+}
+
+func TestNodeServesItsChain(t *testing.T) {
+	cfg := freeNodeConfig(t)
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	defer node.Close()
+
+	addTestBlocks(t, node.chain, 5)
+
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+
+	client, _ := network.NewNetwork("127.0.0.1:0")
+	local := core.NewBlockchain()
+	clientSync := network.NewSyncManager(client, local)
+	client.Start()
+	defer client.Stop()
+
+	if _, err := client.Connect(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port+1)); err != nil {
+		t.Fatalf("Failed to connect to node: %v", err)
+	}
+	clientSync.Start()
+	defer clientSync.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for local.GetBlockCount() != 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the node to serve 6 blocks, got %d", local.GetBlockCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if info := node.GetNodeInfo(); info.BlockHeight != 5 {
+		t.Errorf("Expected block height 5, got %d", info.BlockHeight)
+	}
+}
+
+func TestNodeBootstrapsFromSeeds(t *testing.T) {
+	seedAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	seed, _ := network.NewNetwork(seedAddr)
+	if err := seed.Start(); err != nil {
+		t.Fatalf("Failed to start seed: %v", err)
+	}
+	defer seed.Stop()
+
+	cfg := freeNodeConfig(t)
+	cfg.DataDir = t.TempDir()
+	cfg.Seeds = []string{seed.GetNodeID() + "@" + seedAddr}
+
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(seed.GetConnectedPeers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the node to connect to its seed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	node.Close()
+
+	// The seed is remembered, so a restart does not depend on the seed list
+	book, err := network.NewAddressBook(filepath.Join(cfg.DataDir, "peers.json"))
+	if err != nil {
+		t.Fatalf("Failed to open address book: %v", err)
+	}
+	if _, known := book.Get(seed.GetNodeID()); !known {
+		t.Error("Expected the seed to be saved in the address book")
+	}
+}
+
+func TestInvalidSeedRejected(t *testing.T) {
+	cfg := DefaultNodeConfig("127.0.0.1", 0)
+	cfg.Seeds = []string{"not-an-address"}
+
+	if _, err := NewNodeWithConfig(cfg); err == nil {
+		t.Error("Expected an invalid seed to be rejected")
+	}
+}
+
+func TestNodeAppliesGossipedTransactions(t *testing.T) {
+	cfg := freeNodeConfig(t)
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	defer node.Close()
+
+	client, _ := network.NewNetwork("127.0.0.1:0")
+	gossip := network.NewGossip(client)
+	client.Start()
+	defer client.Stop()
+
+	if _, err := client.Connect(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port+1)); err != nil {
+		t.Fatalf("Failed to connect to node: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.GetConnectedPeers()) == 0 || len(node.p2pNetwork.GetConnectedPeers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client and node to connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	wallet := goldcoin.NewGoldCoin()
+	first, _ := wallet.CreateTransaction("alice", "bob", 10)
+	second, _ := wallet.CreateTransaction("alice", "bob", 20)
+
+	// Out of order: the second is left for later rather than scored
+	if _, err := gossip.AnnounceTransaction(second); err != nil {
+		t.Fatalf("Failed to announce: %v", err)
+	}
+	if _, err := gossip.AnnounceTransaction(first); err != nil {
+		t.Fatalf("Failed to announce: %v", err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for node.goldcoin.GetNonce("alice") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the node to apply the transaction, nonce is %d", node.goldcoin.GetNonce("alice"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	forged := *first
+	forged.Amount = 1000
+	gossip.AnnounceTransaction(&forged) //nolint:errcheck
+
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		peers := node.p2pNetwork.GetPeers()
+		if len(peers) == 1 && peers[0].Score == network.ScoreInvalidTransaction {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the peer to be scored for an invalid transaction, got %+v", peers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNodeFollowsChainHeight(t *testing.T) {
+	cfg := freeNodeConfig(t)
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	defer node.Close()
+
+	node.goldcoin.TxExpiryBlocks = 2
+	expiring, _ := node.goldcoin.CreateTransaction("alice", "bob", 10)
+
+	locked, _ := node.goldcoin.CreateTimeLockedTransaction("alice", "bob", 10, 3, 0)
+	if err := node.timeLocks.Add(node.goldcoin, locked); err != nil {
+		t.Fatalf("Failed to hold time-locked transaction: %v", err)
+	}
+
+	chain := core.NewBlockchain()
+	addTestBlocks(t, chain, 5)
+	source, addr := startSyncSource(t, chain)
+	defer source.Stop()
+
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	if _, err := node.p2pNetwork.Connect(addr); err != nil {
+		t.Fatalf("Failed to connect to source: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.goldcoin.GetBlockHeight() != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected Gold-Coin height 5, got %d", node.goldcoin.GetBlockHeight())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if node.timeLocks.Size() != 0 {
+		t.Error("Expected the time-locked transaction to be released at its lock height")
+	}
+
+	if err := node.goldcoin.ValidateTransaction(expiring); err != goldcoin.ErrTxExpired {
+		t.Errorf("Expected ErrTxExpired past the expiry height, got %v", err)
+	}
+}
+
+func TestNodeProcessesBlockFees(t *testing.T) {
+	cfg := freeNodeConfig(t)
+	cfg.Fees = goldcoin.FeeConfig{Model: goldcoin.FeeModelBaseFee, InitialBaseFee: 0.001, TargetBlockSize: 100}
+	node, err := NewNodeWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	defer node.Close()
+
+	// A full block well above the target size
+	wallet := goldcoin.NewGoldCoin()
+	wallet.SetFeeModel(node.goldcoin.GetFeeModel())
+	chain := core.NewBlockchain()
+	block := &core.Block{Index: 1, PrevHash: chain.GetLatestBlock().Hash}
+	for i := 0; i < 5; i++ {
+		tx, _ := wallet.CreateTransaction("alice", "bob", 10)
+		data, _ := json.Marshal(tx)
+		block.Transactions = append(block.Transactions, string(data))
+	}
+	block.Hash = block.CalculateHash()
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatalf("Failed to build chain: %v", err)
+	}
+
+	source, addr := startSyncSource(t, chain)
+	defer source.Stop()
+
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	if _, err := node.p2pNetwork.Connect(addr); err != nil {
+		t.Fatalf("Failed to connect to source: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.goldcoin.GetBlockHeight() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the node to process the block")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if supply := node.goldcoin.CirculatingSupply(); supply >= 0 {
+		t.Errorf("Expected the block's base fees to be burned, got circulating supply %f", supply)
+	}
+
+	if baseFee := node.goldcoin.GetFeeModel().(*goldcoin.BaseFeeModel).BaseFee(); baseFee <= 0.001 {
+		t.Errorf("Expected the base fee to rise after a full block, got %f", baseFee)
+	}
+}
@@ -0,0 +1,121 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+// posStateKey is the storage key of the persisted PoS state
+const posStateKey = "consensus_pos_state"
+
+// PoSSchema versions the persisted PoS state. Register a migration here
+// whenever posSnapshot changes in a way old data cannot decode into.
+var PoSSchema = newPoSSchema()
+
+// newPoSSchema creates the PoS schema with its migrations
+func newPoSSchema() *storage.Schema {
+	schema := storage.NewSchema("consensus_pos")
+	schema.Register(&storage.Migration{Version: 1, Description: "validator set and chain tip snapshot"})
+	return schema
+}
+
+// posSnapshot is the persisted form of ProofOfStake
+type posSnapshot struct {
+	Validators        map[string]*Validator `json:"validators"`
+	MinStake          float64               `json:"minStake"`
+	BlockTime         int64                 `json:"blockTime"`
+	RewardPerBlock    float64               `json:"rewardPerBlock"`
+	CurrentBlockIndex int                   `json:"currentBlockIndex"`
+	LastBlockHash     string                `json:"lastBlockHash"`
+}
+
+// NewProofOfStakeWithStore creates a PoS instance that is saved to db on
+// every mutation, restoring any previously saved state. Saved state from an
+// older schema version is migrated first.
+func NewProofOfStakeWithStore(db storage.KV) (*ProofOfStake, error) {
+	if db == nil {
+		return nil, errors.New("storage is nil")
+	}
+
+	if _, err := PoSSchema.Migrate(db); err != nil {
+		return nil, err
+	}
+
+	pos := NewProofOfStake()
+	pos.store = db
+
+	if !db.Has(posStateKey) {
+		return pos, pos.persist()
+	}
+
+	snapshot := &posSnapshot{}
+	if err := db.GetJSON(posStateKey, snapshot); err != nil {
+		return nil, err
+	}
+
+	if snapshot.Validators != nil {
+		pos.Validators = snapshot.Validators
+	}
+	pos.MinStake = snapshot.MinStake
+	pos.BlockTime = snapshot.BlockTime
+	pos.RewardPerBlock = snapshot.RewardPerBlock
+	pos.currentBlockIndex = snapshot.CurrentBlockIndex
+	pos.lastBlockHash = snapshot.LastBlockHash
+
+	for address, validator := range pos.Validators {
+		if validator.Address != address {
+			return nil, fmt.Errorf("corrupt PoS state: validator %s stored under %s", validator.Address, address)
+		}
+	}
+
+	return pos, nil
+}
+
+// CheckConsistency verifies the PoS state matches the chain tip it is
+// restored against. An empty latestHash skips the hash comparison.
+func (pos *ProofOfStake) CheckConsistency(latestIndex int, latestHash string) error {
+	pos.mutex.RLock()
+	defer pos.mutex.RUnlock()
+
+	if pos.currentBlockIndex != latestIndex {
+		return fmt.Errorf("PoS state at block %d does not match chain tip %d", pos.currentBlockIndex, latestIndex)
+	}
+
+	if latestHash != "" && pos.lastBlockHash != "" && pos.lastBlockHash != latestHash {
+		return fmt.Errorf("PoS state block hash %s does not match chain tip %s", pos.lastBlockHash, latestHash)
+	}
+
+	for address, validator := range pos.Validators {
+		if validator.StakedAmount < 0 {
+			return fmt.Errorf("validator %s has negative stake", address)
+		}
+	}
+
+	return nil
+}
+
+// GetChainTip returns the index and hash of the last block created
+func (pos *ProofOfStake) GetChainTip() (int, string) {
+	pos.mutex.RLock()
+	defer pos.mutex.RUnlock()
+
+	return pos.currentBlockIndex, pos.lastBlockHash
+}
+
+// persist saves the PoS state if it has a store; callers must hold the lock
+func (pos *ProofOfStake) persist() error {
+	if pos.store == nil {
+		return nil
+	}
+
+	return pos.store.PutJSON(posStateKey, &posSnapshot{
+		Validators:        pos.Validators,
+		MinStake:          pos.MinStake,
+		BlockTime:         pos.BlockTime,
+		RewardPerBlock:    pos.RewardPerBlock,
+		CurrentBlockIndex: pos.currentBlockIndex,
+		LastBlockHash:     pos.lastBlockHash,
+	})
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+func TestProofOfStakeSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	db, _ := storage.NewLevelDB(dir)
+	pos, err := NewProofOfStakeWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create persistent PoS: %v", err)
+	}
+
+	pos.RegisterValidator("validator1", 2000.0)
+	block, err := pos.CreateBlock([]string{"tx1"}, "0")
+	if err != nil {
+		t.Fatalf("Failed to create block: %v", err)
+	}
+
+	// Simulate a node restart
+	db, _ = storage.NewLevelDB(dir)
+	restored, err := NewProofOfStakeWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to restore PoS: %v", err)
+	}
+
+	validator, err := restored.GetValidatorInfo("validator1")
+	if err != nil {
+		t.Fatalf("Expected validator after restart: %v", err)
+	}
+
+	if validator.StakedAmount != 2000.0+pos.RewardPerBlock {
+		t.Errorf("Expected rewarded stake %f, got %f", 2000.0+pos.RewardPerBlock, validator.StakedAmount)
+	}
+
+	if err := restored.CheckConsistency(block.Index, block.Hash); err != nil {
+		t.Errorf("Expected state consistent with chain tip: %v", err)
+	}
+
+	if err := restored.CheckConsistency(block.Index, "other"); err == nil {
+		t.Error("Expected error for mismatched chain tip hash")
+	}
+
+	if err := restored.CheckConsistency(block.Index+1, ""); err == nil {
+		t.Error("Expected error when PoS state is behind the chain")
+	}
+}
+
+func TestCreateBlockRollsBackFailedPersist(t *testing.T) {
+	db, _ := storage.NewLevelDB(t.TempDir())
+	pos, err := NewProofOfStakeWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create persistent PoS: %v", err)
+	}
+
+	pos.RegisterValidator("validator1", 2000.0)
+	block, _ := pos.CreateBlock([]string{"tx1"}, "0")
+	db.Close()
+
+	if _, err := pos.CreateBlock([]string{"tx2"}, block.Hash); err == nil {
+		t.Fatal("Expected block creation to fail when storage fails")
+	}
+
+	if index, hash := pos.GetChainTip(); index != block.Index || hash != block.Hash {
+		t.Errorf("Expected the chain tip to stay at block %d, got %d", block.Index, index)
+	}
+
+	validator, _ := pos.GetValidatorInfo("validator1")
+	if validator.StakedAmount != 2000.0+pos.RewardPerBlock {
+		t.Errorf("Expected only the saved block's reward, got stake %f", validator.StakedAmount)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/Bituncoin/Bituncoin/storage"
 )
 
 // Validator represents a PoS validator
@@ -38,6 +40,8 @@ type ProofOfStake struct {
 	RewardPerBlock   float64
 	mutex            sync.RWMutex
 	currentBlockIndex int
+	lastBlockHash     string
+	store             storage.KV
 }
 
 // NewProofOfStake creates a new PoS consensus instance
@@ -77,6 +81,11 @@ func (pos *ProofOfStake) RegisterValidator(address string, stakedAmount float64)
 	}
 
 	pos.Validators[address] = validator
+	if err := pos.persist(); err != nil {
+		delete(pos.Validators, address)
+		return err
+	}
+
 	return nil
 }
 
@@ -85,6 +94,11 @@ func (pos *ProofOfStake) SelectValidator() (*Validator, error) {
 	pos.mutex.RLock()
 	defer pos.mutex.RUnlock()
 
+	return pos.selectValidator()
+}
+
+// selectValidator selects a validator by stake weight; callers must hold the lock
+func (pos *ProofOfStake) selectValidator() (*Validator, error) {
 	if len(pos.Validators) == 0 {
 		return nil, errors.New("no validators available")
 	}
@@ -120,20 +134,20 @@ func (pos *ProofOfStake) SelectValidator() (*Validator, error) {
 	return activeValidators[0], nil
 }
 
-// CreateBlock creates a new block with the selected validator
+// CreateBlock creates a new block with the selected validator. Selection,
+// the reward and the new chain tip are applied and saved under one lock, and
+// undone if saving fails.
 func (pos *ProofOfStake) CreateBlock(transactions []string, prevHash string) (*Block, error) {
-	validator, err := pos.SelectValidator()
+	pos.mutex.Lock()
+	defer pos.mutex.Unlock()
+
+	validator, err := pos.selectValidator()
 	if err != nil {
 		return nil, err
 	}
 
-	pos.mutex.Lock()
-	pos.currentBlockIndex++
-	blockIndex := pos.currentBlockIndex
-	pos.mutex.Unlock()
-
 	block := &Block{
-		Index:        blockIndex,
+		Index:        pos.currentBlockIndex + 1,
 		Timestamp:    time.Now().Unix(),
 		Transactions: transactions,
 		PrevHash:     prevHash,
@@ -143,8 +157,19 @@ func (pos *ProofOfStake) CreateBlock(transactions []string, prevHash string) (*B
 
 	block.Hash = block.calculateHash()
 
+	lastBlockHash := pos.lastBlockHash
+	pos.currentBlockIndex = block.Index
+	pos.lastBlockHash = block.Hash
+
 	// Reward the validator
-	pos.rewardValidator(validator.Address)
+	pos.rewardValidator(validator, pos.RewardPerBlock)
+
+	if err := pos.persist(); err != nil {
+		validator.StakedAmount -= pos.RewardPerBlock
+		pos.currentBlockIndex--
+		pos.lastBlockHash = lastBlockHash
+		return nil, err
+	}
 
 	return block, nil
 }
@@ -157,14 +182,9 @@ func (b *Block) calculateHash() string {
 	return hex.EncodeToString(hash[:])
 }
 
-// rewardValidator rewards a validator for creating a block
-func (pos *ProofOfStake) rewardValidator(address string) {
-	pos.mutex.Lock()
-	defer pos.mutex.Unlock()
-
-	if validator, exists := pos.Validators[address]; exists {
-		validator.StakedAmount += pos.RewardPerBlock
-	}
+// rewardValidator credits a validator for creating a block; callers must hold the write lock
+func (pos *ProofOfStake) rewardValidator(validator *Validator, amount float64) {
+	validator.StakedAmount += amount
 }
 
 // GetValidatorInfo returns information about a validator
@@ -193,6 +213,11 @@ func (pos *ProofOfStake) UnstakeValidator(address string) (float64, error) {
 	stakedAmount := validator.StakedAmount
 	delete(pos.Validators, address)
 	
+	if err := pos.persist(); err != nil {
+		pos.Validators[address] = validator
+		return 0, err
+	}
+
 	return stakedAmount, nil
 }
 
@@ -66,6 +66,10 @@ func (bc *Blockchain) AddBlock(block *Block) error {
 		return errors.New("invalid previous hash")
 	}
 
+	if block.Hash != block.CalculateHash() {
+		return ErrInvalidBlockHash
+	}
+
 	bc.Blocks = append(bc.Blocks, block)
 	return nil
 }
@@ -118,6 +122,10 @@ func (bc *Blockchain) ValidateChain() error {
 		if currentBlock.Index != prevBlock.Index+1 {
 			return errors.New("invalid chain: index mismatch")
 		}
+
+		if currentBlock.Hash != currentBlock.CalculateHash() {
+			return ErrInvalidBlockHash
+		}
 	}
 
 	return nil
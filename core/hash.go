@@ -0,0 +1,45 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrInvalidBlockHash is returned for a block whose hash does not match its contents
+var ErrInvalidBlockHash = errors.New("invalid block hash")
+
+// TxRoot commits to a list of transactions
+func TxRoot(transactions []string) string {
+	h := sha256.New()
+	for _, tx := range transactions {
+		writeField(h, []byte(tx))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HeaderHash returns the canonical hash of a block header. It commits to
+// every header field, and through txRoot to the transactions, so a header
+// can be checked without its body.
+func HeaderHash(index int, timestamp int64, prevHash string, nonce int, validator, txRoot string) string {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(index)))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(timestamp)))
+	writeField(h, []byte(prevHash))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(nonce)))
+	writeField(h, []byte(validator))
+	writeField(h, []byte(txRoot))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CalculateHash returns the canonical hash of the block
+func (b *Block) CalculateHash() string {
+	return HeaderHash(b.Index, b.Timestamp, b.PrevHash, b.Nonce, b.Validator, TxRoot(b.Transactions))
+}
+
+// writeField writes a length-prefixed field so adjacent fields cannot run together
+func writeField(h interface{ Write([]byte) (int, error) }, data []byte) {
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	h.Write(data)
+}
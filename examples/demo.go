@@ -93,19 +93,19 @@ func main() {
 
 	// 8. Create a stake
 	fmt.Println("8. Creating a stake...")
-	err = stakingPool.CreateStake(addr1.Address, 1000.0)
+	stake, err := stakingPool.CreateStake(addr1.Address, 1000.0)
 	if err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("   Staked 1000 GLD from %s\n", addr1.Address[:20]+"...")
 	
-	stakeInfo, _ := stakingPool.GetStakeInfo(addr1.Address)
+	stakeInfo, _ := stakingPool.GetStakeInfo(stake.ID)
 	fmt.Printf("   Active: %v\n", stakeInfo.IsActive)
 	fmt.Printf("   Amount: %.2f GLD\n\n", stakeInfo.Amount)
 
 	// 9. Calculate rewards
 	fmt.Println("9. Calculating staking rewards...")
-	rewards, err := stakingPool.CalculateRewards(addr1.Address)
+	rewards, err := stakingPool.CalculateRewards(stake.ID)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,192 @@
+package goldcoin
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// DefaultCompoundInterval is how often auto-compounding positions restake rewards
+const DefaultCompoundInterval = 24 * 60 * 60 // 1 day in seconds
+
+// MaxCompoundHistory is how many compounding events a position keeps; older
+// events are dropped
+const MaxCompoundHistory = 100
+
+// CompoundEvent records rewards restaked into a position
+type CompoundEvent struct {
+	Timestamp    int64 // in nanoseconds
+	Amount       float64
+	NewPrincipal float64
+}
+
+// SetAutoCompound opts a position in or out of auto-compounding. An interval
+// of 0 uses DefaultCompoundInterval.
+func (sp *StakingPool) SetAutoCompound(positionID string, enabled bool, interval int64) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if interval < 0 {
+		return errors.New("invalid compound interval")
+	}
+
+	if interval == 0 {
+		interval = DefaultCompoundInterval
+	}
+
+	stake, err := sp.activeStake(positionID)
+	if err != nil {
+		return err
+	}
+
+	stake.AutoCompound = enabled
+	stake.CompoundInterval = interval
+	if enabled && stake.LastCompoundTime == 0 {
+		stake.LastCompoundTime = time.Now().UnixNano()
+	}
+
+	return sp.persist()
+}
+
+// CompoundRewards restakes a position's claimable rewards, up to the reward
+// reserve, into its principal without extending the lock. It returns the
+// amount compounded.
+func (sp *StakingPool) CompoundRewards(positionID string) (float64, error) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	stake, err := sp.activeStake(positionID)
+	if err != nil {
+		return 0, err
+	}
+
+	amount := sp.compound(stake, time.Now().UnixNano())
+	if amount <= 0 {
+		return 0, errors.New("no rewards to compound")
+	}
+
+	if err := sp.persist(); err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// ProcessAutoCompound compounds every opted-in position whose interval has
+// elapsed. It returns the number of positions compounded.
+func (sp *StakingPool) ProcessAutoCompound() (int, error) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	compounded := 0
+	for _, stake := range sp.Stakes {
+		if !stake.IsActive || !stake.AutoCompound {
+			continue
+		}
+
+		if now-stake.LastCompoundTime < stake.CompoundInterval*int64(time.Second) {
+			continue
+		}
+
+		if sp.compound(stake, now) > 0 {
+			compounded++
+		}
+	}
+
+	if compounded == 0 {
+		return 0, nil
+	}
+
+	return compounded, sp.persist()
+}
+
+// compound moves claimable rewards into principal; callers must hold the write lock
+func (sp *StakingPool) compound(stake *Stake, now int64) float64 {
+	sp.settle(stake, now)
+
+	// Compounded rewards are paid from the reserve like claims
+	amount := math.Min(stake.AccruedRewards-stake.RewardsClaimed, sp.RewardReserve)
+	stake.LastCompoundTime = now
+	if amount <= 0 {
+		return 0
+	}
+
+	sp.payReward(stake, amount)
+	stake.Amount += amount
+	sp.TotalStaked += amount
+
+	stake.CompoundHistory = append(stake.CompoundHistory, CompoundEvent{
+		Timestamp:    now,
+		Amount:       amount,
+		NewPrincipal: stake.Amount,
+	})
+	if excess := len(stake.CompoundHistory) - MaxCompoundHistory; excess > 0 {
+		stake.CompoundHistory = append([]CompoundEvent(nil), stake.CompoundHistory[excess:]...)
+	}
+
+	return amount
+}
+
+// GetCompoundHistory returns the most recent compounding events of a position,
+// oldest first
+func (sp *StakingPool) GetCompoundHistory(positionID string) ([]CompoundEvent, error) {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	stake, exists := sp.Stakes[positionID]
+	if !exists {
+		return nil, errors.New("stake not found")
+	}
+
+	history := make([]CompoundEvent, len(stake.CompoundHistory))
+	copy(history, stake.CompoundHistory)
+	return history, nil
+}
+
+// StartAutoCompounding runs ProcessAutoCompound every checkEvery until stopped
+func (sp *StakingPool) StartAutoCompounding(checkEvery time.Duration) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if checkEvery <= 0 {
+		return errors.New("invalid check interval")
+	}
+
+	if sp.compoundStop != nil {
+		return errors.New("auto-compounding already running")
+	}
+
+	stop := make(chan struct{})
+	sp.compoundStop = stop
+
+	go func() {
+		ticker := time.NewTicker(checkEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sp.ProcessAutoCompound() //nolint:errcheck // retried on the next tick
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopAutoCompounding stops the background compounding loop
+func (sp *StakingPool) StopAutoCompounding() error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.compoundStop == nil {
+		return errors.New("auto-compounding not running")
+	}
+
+	close(sp.compoundStop)
+	sp.compoundStop = nil
+	return nil
+}
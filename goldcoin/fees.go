@@ -0,0 +1,276 @@
+package goldcoin
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// Fee model names accepted in FeeConfig.Model
+const (
+	FeeModelPercentage = "percentage"
+	FeeModelPerByte    = "per_byte"
+	FeeModelBaseFee    = "base_fee"
+)
+
+// Estimated sizes in bytes of the fixed parts of a signed transaction
+const (
+	txBaseSize      = 48 // amount, fee, timestamp, nonce, expiry, version
+	txSignatureSize = 64
+	txPublicKeySize = 32
+)
+
+// ErrFeeTooLow is returned when a transaction does not pay the required fee
+var ErrFeeTooLow = errors.New("transaction fee too low")
+
+// FeeModel decides how much a transaction must pay
+type FeeModel interface {
+	// Name returns the config name of the model
+	Name() string
+	// CalculateFee returns the fee offered by a new transaction
+	CalculateFee(amount float64, size int) float64
+	// MinimumFee returns the lowest fee a transaction may pay to be valid
+	MinimumFee(amount float64, size int) float64
+	// BurnedFee returns the part of a paid fee that is destroyed
+	BurnedFee(fee float64, size int) float64
+	// OnBlock updates the model after a block of blockSize bytes
+	OnBlock(blockSize int)
+}
+
+// FeeConfig selects and parameterizes a fee model
+type FeeConfig struct {
+	Model string `json:"model"`
+
+	// percentage
+	Rate   float64 `json:"rate"`
+	MinFee float64 `json:"min_fee"`
+	MaxFee float64 `json:"max_fee"`
+
+	// per_byte
+	FeePerByte float64 `json:"fee_per_byte"`
+
+	// base_fee
+	InitialBaseFee  float64 `json:"initial_base_fee"`
+	MinBaseFee      float64 `json:"min_base_fee"`
+	PriorityFee     float64 `json:"priority_fee"`
+	TargetBlockSize int     `json:"target_block_size"`
+}
+
+// NewFeeModel creates the fee model described by a config
+func NewFeeModel(cfg FeeConfig) (FeeModel, error) {
+	switch cfg.Model {
+	case FeeModelPercentage, "":
+		if cfg.Rate < 0 || cfg.Rate >= 1 {
+			return nil, errors.New("invalid fee rate: must be in [0, 1)")
+		}
+		if cfg.MaxFee > 0 && cfg.MaxFee < cfg.MinFee {
+			return nil, errors.New("invalid fee bounds: max fee below min fee")
+		}
+		return &PercentageFeeModel{Rate: cfg.Rate, MinFee: cfg.MinFee, MaxFee: cfg.MaxFee}, nil
+
+	case FeeModelPerByte:
+		if cfg.FeePerByte <= 0 {
+			return nil, errors.New("invalid fee per byte: must be greater than 0")
+		}
+		return &PerByteFeeModel{FeePerByte: cfg.FeePerByte, MinFee: cfg.MinFee}, nil
+
+	case FeeModelBaseFee:
+		if cfg.InitialBaseFee <= 0 || cfg.TargetBlockSize <= 0 {
+			return nil, errors.New("base fee model requires initial base fee and target block size")
+		}
+		return NewBaseFeeModel(cfg.InitialBaseFee, cfg.MinBaseFee, cfg.PriorityFee, cfg.TargetBlockSize), nil
+
+	default:
+		return nil, errors.New("unknown fee model: " + cfg.Model)
+	}
+}
+
+// PercentageFeeModel charges a fraction of the amount, optionally bounded
+type PercentageFeeModel struct {
+	Rate   float64
+	MinFee float64
+	MaxFee float64 // 0 = uncapped
+}
+
+// Name returns the config name of the model
+func (m *PercentageFeeModel) Name() string {
+	return FeeModelPercentage
+}
+
+// CalculateFee returns amount*Rate clamped to [MinFee, MaxFee]
+func (m *PercentageFeeModel) CalculateFee(amount float64, size int) float64 {
+	fee := amount * m.Rate
+	if fee < m.MinFee {
+		fee = m.MinFee
+	}
+	if m.MaxFee > 0 && fee > m.MaxFee {
+		fee = m.MaxFee
+	}
+	return fee
+}
+
+// MinimumFee equals CalculateFee for the percentage model
+func (m *PercentageFeeModel) MinimumFee(amount float64, size int) float64 {
+	return m.CalculateFee(amount, size)
+}
+
+// BurnedFee returns 0; percentage fees go to validators
+func (m *PercentageFeeModel) BurnedFee(fee float64, size int) float64 {
+	return 0
+}
+
+// OnBlock does nothing; the percentage model is static
+func (m *PercentageFeeModel) OnBlock(blockSize int) {}
+
+// PerByteFeeModel charges a flat price per byte of transaction
+type PerByteFeeModel struct {
+	FeePerByte float64
+	MinFee     float64
+}
+
+// Name returns the config name of the model
+func (m *PerByteFeeModel) Name() string {
+	return FeeModelPerByte
+}
+
+// CalculateFee returns size*FeePerByte, at least MinFee
+func (m *PerByteFeeModel) CalculateFee(amount float64, size int) float64 {
+	return math.Max(float64(size)*m.FeePerByte, m.MinFee)
+}
+
+// MinimumFee equals CalculateFee for the per-byte model
+func (m *PerByteFeeModel) MinimumFee(amount float64, size int) float64 {
+	return m.CalculateFee(amount, size)
+}
+
+// BurnedFee returns 0; per-byte fees go to validators
+func (m *PerByteFeeModel) BurnedFee(fee float64, size int) float64 {
+	return 0
+}
+
+// OnBlock does nothing; the per-byte model is static
+func (m *PerByteFeeModel) OnBlock(blockSize int) {}
+
+// BaseFeeModel is an EIP-1559 style model: a per-byte base fee that is burned
+// and moves towards a target block size, plus a priority fee for validators
+type BaseFeeModel struct {
+	baseFee         float64
+	minBaseFee      float64
+	priorityFee     float64
+	targetBlockSize int
+	mutex           sync.RWMutex
+}
+
+// baseFeeChangeDenominator bounds the base fee change to 12.5% per block
+const baseFeeChangeDenominator = 8
+
+// NewBaseFeeModel creates a base fee model
+func NewBaseFeeModel(initialBaseFee, minBaseFee, priorityFee float64, targetBlockSize int) *BaseFeeModel {
+	return &BaseFeeModel{
+		baseFee:         math.Max(initialBaseFee, minBaseFee),
+		minBaseFee:      minBaseFee,
+		priorityFee:     priorityFee,
+		targetBlockSize: targetBlockSize,
+	}
+}
+
+// Name returns the config name of the model
+func (m *BaseFeeModel) Name() string {
+	return FeeModelBaseFee
+}
+
+// BaseFee returns the current per-byte base fee
+func (m *BaseFeeModel) BaseFee() float64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.baseFee
+}
+
+// CalculateFee returns (base fee + priority fee) * size
+func (m *BaseFeeModel) CalculateFee(amount float64, size int) float64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return (m.baseFee + m.priorityFee) * float64(size)
+}
+
+// MinimumFee returns base fee * size; the priority fee is optional
+func (m *BaseFeeModel) MinimumFee(amount float64, size int) float64 {
+	return m.BaseFee() * float64(size)
+}
+
+// BurnedFee returns the base fee part of a paid fee
+func (m *BaseFeeModel) BurnedFee(fee float64, size int) float64 {
+	return math.Min(fee, m.BaseFee()*float64(size))
+}
+
+// OnBlock raises the base fee when blocks are above target and lowers it when below
+func (m *BaseFeeModel) OnBlock(blockSize int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delta := float64(blockSize-m.targetBlockSize) / float64(m.targetBlockSize)
+	if delta > 1 {
+		delta = 1
+	}
+	m.baseFee += m.baseFee * delta / baseFeeChangeDenominator
+	if m.baseFee < m.minBaseFee {
+		m.baseFee = m.minBaseFee
+	}
+}
+
+// Size estimates the encoded size of a transaction in bytes once signed
+func (tx *Transaction) Size() int {
+	size := txBaseSize + len(tx.ChainID) + len(tx.From) + len(tx.To)
+	if tx.Multisig == nil {
+		return size + txSignatureSize
+	}
+	return size + len(tx.Multisig.PublicKeys)*txPublicKeySize + tx.Multisig.Threshold*txSignatureSize
+}
+
+// SetFeeModel replaces the fee model used for new and validated transactions
+func (gc *GoldCoin) SetFeeModel(model FeeModel) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	gc.feeModel = model
+}
+
+// GetFeeModel returns the active fee model, a percentage model at TxFee by default
+func (gc *GoldCoin) GetFeeModel() FeeModel {
+	gc.mutex.RLock()
+	defer gc.mutex.RUnlock()
+
+	if gc.feeModel == nil {
+		return &PercentageFeeModel{Rate: gc.TxFee}
+	}
+	return gc.feeModel
+}
+
+// EstimateFee returns the fee a new single-signature transfer of amount would pay
+func (gc *GoldCoin) EstimateFee(from, to string, amount float64) float64 {
+	tx := &Transaction{From: from, To: to, ChainID: gc.ChainID}
+	return gc.GetFeeModel().CalculateFee(amount, tx.Size())
+}
+
+// ProcessBlockFees burns the burnable part of each fee and updates the fee
+// model for the next block. It returns the amount burned.
+func (gc *GoldCoin) ProcessBlockFees(txs []*Transaction) float64 {
+	model := gc.GetFeeModel()
+
+	burned := 0.0
+	blockSize := 0
+	for _, tx := range txs {
+		size := tx.Size()
+		burned += model.BurnedFee(tx.Fee, size)
+		blockSize += size
+	}
+	model.OnBlock(blockSize)
+
+	gc.mutex.Lock()
+	gc.TotalBurned += burned
+	gc.mutex.Unlock()
+
+	return burned
+}
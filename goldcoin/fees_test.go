@@ -0,0 +1,111 @@
+package goldcoin
+
+import (
+	"testing"
+)
+
+func TestNewFeeModelFromConfig(t *testing.T) {
+	models := []FeeConfig{
+		{Model: FeeModelPercentage, Rate: 0.001},
+		{Model: FeeModelPerByte, FeePerByte: 0.0001},
+		{Model: FeeModelBaseFee, InitialBaseFee: 0.0001, TargetBlockSize: 1000},
+	}
+
+	for _, cfg := range models {
+		model, err := NewFeeModel(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create %s fee model: %v", cfg.Model, err)
+		}
+
+		if model.Name() != cfg.Model {
+			t.Errorf("Expected model %s, got %s", cfg.Model, model.Name())
+		}
+	}
+
+	if _, err := NewFeeModel(FeeConfig{Model: "auction"}); err == nil {
+		t.Error("Expected error for unknown fee model")
+	}
+}
+
+func TestPercentageFeeCap(t *testing.T) {
+	gc := NewGoldCoin()
+	gc.SetFeeModel(&PercentageFeeModel{Rate: 0.001, MaxFee: 5})
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 1000000.0)
+	if tx.Fee != 5 {
+		t.Errorf("Expected capped fee 5, got %f", tx.Fee)
+	}
+
+	if err := gc.ValidateTransaction(tx); err != nil {
+		t.Errorf("Transaction validation failed: %v", err)
+	}
+}
+
+func TestPerByteFeeIgnoresAmount(t *testing.T) {
+	gc := NewGoldCoin()
+	gc.SetFeeModel(&PerByteFeeModel{FeePerByte: 0.001})
+
+	small := gc.EstimateFee("from_addr", "to_addr", 1.0)
+	large := gc.EstimateFee("from_addr", "to_addr", 1000000.0)
+
+	if small != large || small <= 0 {
+		t.Errorf("Expected equal non-zero per-byte fees, got %f and %f", small, large)
+	}
+}
+
+func TestBaseFeeAdjustsAndBurns(t *testing.T) {
+	gc := NewGoldCoin()
+	model := NewBaseFeeModel(0.001, 0.0001, 0.0005, 200)
+	gc.SetFeeModel(model)
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	expected := (0.001 + 0.0005) * float64(tx.Size())
+	if tx.Fee != expected {
+		t.Errorf("Expected fee %f, got %f", expected, tx.Fee)
+	}
+
+	// A block twice the target raises the base fee by 12.5%
+	txs := []*Transaction{tx, tx}
+	burned := gc.ProcessBlockFees(txs)
+	if burned != 0.001*float64(tx.Size())*2 {
+		t.Errorf("Expected base fee to be burned, got %f", burned)
+	}
+
+	if gc.TotalBurned != burned {
+		t.Errorf("Expected total burned %f, got %f", burned, gc.TotalBurned)
+	}
+
+	gc.Mint(1000) //nolint:errcheck
+	if supply := gc.CirculatingSupply(); supply != 1000-burned {
+		t.Errorf("Expected burned fees to leave circulation, got supply %f", supply)
+	}
+
+	blockSize := tx.Size() * 2
+	want := 0.001 + 0.001*float64(blockSize-200)/200/8
+	if model.BaseFee() != want {
+		t.Errorf("Expected base fee %f, got %f", want, model.BaseFee())
+	}
+
+	// An empty block lowers the base fee but never below the minimum
+	for i := 0; i < 100; i++ {
+		gc.ProcessBlockFees(nil)
+	}
+	if model.BaseFee() != 0.0001 {
+		t.Errorf("Expected base fee floor 0.0001, got %f", model.BaseFee())
+	}
+}
+
+func TestFeeBelowBaseFeeRejected(t *testing.T) {
+	gc := NewGoldCoin()
+	model := NewBaseFeeModel(0.001, 0.0001, 0, 100)
+	gc.SetFeeModel(model)
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+
+	// Congestion raises the base fee above what the transaction offered
+	model.OnBlock(1000)
+
+	if err := gc.ValidateTransaction(tx); err != ErrFeeTooLow {
+		t.Errorf("Expected ErrFeeTooLow, got %v", err)
+	}
+}
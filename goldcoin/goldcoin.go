@@ -5,7 +5,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/Bituncoin/Bituncoin/identity"
 )
 
 // GoldCoin represents the Gold-Coin cryptocurrency
@@ -13,13 +17,56 @@ type GoldCoin struct {
 	Name          string
 	Symbol        string
 	MaxSupply     uint64
-	CircSupply    uint64
+	CircSupply    uint64 // total minted; see CirculatingSupply for the amount in circulation
 	Decimals      uint8
 	StakingReward float64
-	TxFee         float64
+	TxFee         float64 // rate of the default percentage fee model
 	Version       string
+
+	// ChainID is committed to every transaction so it cannot be replayed on another network
+	ChainID string
+	// BlockHeight is the current chain height used for transaction expiry
+	BlockHeight uint64
+	// TxExpiryBlocks is how many blocks a new transaction stays valid (0 = never expires)
+	TxExpiryBlocks uint64
+	// TotalBurned is the sum of fees destroyed by the fee model
+	TotalBurned float64
+
+	feeModel      FeeModel
+	nonces        map[string]uint64 // next nonce to be applied per account
+	pendingNonces map[string]uint64 // next nonce to hand out per account
+
+	// Time-locked transactions draw nonces from a separate space and may be
+	// applied in any order; usedLockNonces maps each applied one to the
+	// expiry height of its transaction
+	lockNonces     map[string]uint64
+	usedLockNonces map[string]map[uint64]uint64
+
+	// multisigAddresses are the known M-of-N addresses; transactions from
+	// them must carry the descriptor and enough signatures
+	multisigAddresses map[string]bool
+	mutex         sync.RWMutex
 }
 
+// DefaultChainID identifies the Gold-Coin main network
+const DefaultChainID = "gld-mainnet"
+
+var (
+	// ErrNonceTooLow is returned for transactions whose nonce was already used
+	ErrNonceTooLow = errors.New("nonce too low: stale or duplicate transaction")
+	// ErrNonceGap is returned when applying a transaction ahead of the account nonce
+	ErrNonceGap = errors.New("nonce too high: earlier transactions are missing")
+	// ErrWrongChain is returned for transactions signed for another chain
+	ErrWrongChain = errors.New("transaction chain ID does not match")
+	// ErrTxExpired is returned for transactions past their expiry height
+	ErrTxExpired = errors.New("transaction expired")
+	// ErrTxLocked is returned for transactions whose lock height or time has not been reached
+	ErrTxLocked = errors.New("transaction is time-locked")
+	// ErrMultisigRequired is returned for transactions from a multisig address
+	// that do not carry its descriptor
+	ErrMultisigRequired = errors.New("transaction from a multisig address requires its descriptor and signatures")
+)
+
 // Transaction represents a Gold-Coin transaction
 type Transaction struct {
 	ID        string
@@ -29,6 +76,17 @@ type Transaction struct {
 	Fee       float64
 	Timestamp int64
 	Signature string
+
+	Nonce        uint64
+	ChainID      string
+	ExpiryHeight uint64 // last block height the transaction may be included at (0 = never)
+	LockHeight   uint64 // first block height the transaction may be included at (0 = none)
+	LockTime     int64  // earliest unix time the transaction may be included (0 = none)
+
+	// Multisig is set when From is an M-of-N address; Signatures then maps
+	// each signer's public key to its signature over the transaction ID
+	Multisig   *identity.MultisigAddress
+	Signatures map[string]string
 }
 
 // NewGoldCoin creates a new instance of Gold-Coin with defined tokenomics
@@ -42,39 +100,112 @@ func NewGoldCoin() *GoldCoin {
 		StakingReward: 5.0,  // 5% annual staking reward
 		TxFee:         0.001, // 0.1% transaction fee
 		Version:       "1.0.0",
+
+		ChainID:        DefaultChainID,
+		TxExpiryBlocks: 256,
+		nonces:         make(map[string]uint64),
+		pendingNonces:  make(map[string]uint64),
+		lockNonces:     make(map[string]uint64),
+		usedLockNonces: make(map[string]map[uint64]uint64),
+
+		multisigAddresses: make(map[string]bool),
 	}
 }
 
-// CreateTransaction creates a new transaction
+// CreateTransaction creates a new transaction with the sender's next nonce.
+// A transaction that will not be applied must be given back with
+// ReleaseNonce, or the sender's later transactions wait on the gap.
 func (gc *GoldCoin) CreateTransaction(from, to string, amount float64) (*Transaction, error) {
+	if err := checkTransfer(from, to, amount); err != nil {
+		return nil, err
+	}
+
+	gc.mutex.Lock()
+	nonce := gc.pendingNonces[from]
+	if confirmed := gc.nonces[from]; nonce < confirmed {
+		nonce = confirmed
+	}
+	gc.pendingNonces[from] = nonce + 1
+	gc.mutex.Unlock()
+
+	return gc.newTransaction(from, to, amount, nonce), nil
+}
+
+// ReleaseNonce gives back the nonce of a created transaction that will not
+// be applied, such as an abandoned or rejected one. The next transaction
+// reuses it; any created after tx must be created again.
+func (gc *GoldCoin) ReleaseNonce(tx *Transaction) {
+	if tx == nil || tx.IsTimeLocked() {
+		// Time-lock nonces may be applied in any order, so they leave no gap
+		return
+	}
+
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	if tx.Nonce < gc.pendingNonces[tx.From] && tx.Nonce >= gc.nonces[tx.From] {
+		gc.pendingNonces[tx.From] = tx.Nonce
+	}
+}
+
+// checkTransfer validates the amount and addresses of a new transaction
+func checkTransfer(from, to string, amount float64) error {
 	if amount <= 0 {
-		return nil, errors.New("invalid amount: must be greater than 0")
+		return errors.New("invalid amount: must be greater than 0")
 	}
 
 	if from == "" || to == "" {
-		return nil, errors.New("invalid addresses: from and to cannot be empty")
+		return errors.New("invalid addresses: from and to cannot be empty")
 	}
 
-	fee := amount * gc.TxFee
+	return nil
+}
+
+// newTransaction builds a transaction with the given nonce, its fee and ID
+func (gc *GoldCoin) newTransaction(from, to string, amount float64, nonce uint64) *Transaction {
+	gc.mutex.RLock()
+	expiry := uint64(0)
+	if gc.TxExpiryBlocks > 0 {
+		expiry = gc.BlockHeight + gc.TxExpiryBlocks
+	}
+	gc.mutex.RUnlock()
 
 	tx := &Transaction{
-		From:      from,
-		To:        to,
-		Amount:    amount,
-		Fee:       fee,
-		Timestamp: time.Now().Unix(),
+		From:         from,
+		To:           to,
+		Amount:       amount,
+		Timestamp:    time.Now().Unix(),
+		Nonce:        nonce,
+		ChainID:      gc.ChainID,
+		ExpiryHeight: expiry,
 	}
+	tx.Fee = gc.GetFeeModel().CalculateFee(amount, tx.Size())
 
 	// Generate transaction ID
 	tx.ID = tx.generateID()
 
-	return tx, nil
+	return tx
+}
+
+// SigningPayload returns the data committed to by the transaction ID and its
+// signatures. Amounts are written at full precision so transactions that
+// differ only in the last decimals never share a payload. Only multisig
+// transactions are signed and verified so far; the Signature of a
+// single-signature transaction is not checked.
+func (tx *Transaction) SigningPayload() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d|%d|%d|%d|%d",
+		tx.ChainID, tx.From, tx.To, formatAmount(tx.Amount), formatAmount(tx.Fee), tx.Timestamp,
+		tx.Nonce, tx.ExpiryHeight, tx.LockHeight, tx.LockTime)
+}
+
+// formatAmount formats an amount with the fewest digits that represent it exactly
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
 }
 
 // generateID generates a unique transaction ID using SHA-256
 func (tx *Transaction) generateID() string {
-	data := fmt.Sprintf("%s%s%f%d", tx.From, tx.To, tx.Amount, tx.Timestamp)
-	hash := sha256.Sum256([]byte(data))
+	hash := sha256.Sum256([]byte(tx.SigningPayload()))
 	return hex.EncodeToString(hash[:])
 }
 
@@ -98,11 +229,167 @@ func (gc *GoldCoin) ValidateTransaction(tx *Transaction) error {
 		return errors.New("invalid transaction ID")
 	}
 
+	if tx.ChainID != gc.ChainID {
+		return ErrWrongChain
+	}
+
+	gc.mutex.RLock()
+	height := gc.BlockHeight
+	next := gc.nonces[tx.From]
+	_, lockNonceUsed := gc.usedLockNonces[tx.From][tx.Nonce]
+	multisig := gc.multisigAddresses[tx.From]
+	gc.mutex.RUnlock()
+
+	if multisig && tx.Multisig == nil {
+		return ErrMultisigRequired
+	}
+
+	if tx.ExpiryHeight != 0 && height > tx.ExpiryHeight {
+		return ErrTxExpired
+	}
+
+	if tx.IsTimeLocked() {
+		if lockNonceUsed {
+			return ErrNonceTooLow
+		}
+	} else if tx.Nonce < next {
+		return ErrNonceTooLow
+	}
+
+	if tx.Fee < gc.GetFeeModel().MinimumFee(tx.Amount, tx.Size()) {
+		return ErrFeeTooLow
+	}
+
+	if tx.Multisig != nil {
+		if err := tx.Multisig.Validate(); err != nil {
+			return err
+		}
+
+		if tx.Multisig.Address != tx.From {
+			return errors.New("multisig address does not match sender")
+		}
+
+		if err := tx.Multisig.VerifySignatures(tx.ID, tx.Signatures); err != nil {
+			return err
+		}
+	}
+
+	// Checked last so a time-locked transaction that fails only here is otherwise valid
+	if !tx.IsEligible(height, time.Now().Unix()) {
+		return ErrTxLocked
+	}
+
+	return nil
+}
+
+// ApplyTransaction validates a transaction and consumes its nonce. Nonces
+// must be applied in order, so a replayed transaction is rejected as stale.
+// Time-locked transactions have their own nonces, applied in any order, so
+// a held transaction never blocks the sender's other transactions.
+func (gc *GoldCoin) ApplyTransaction(tx *Transaction) error {
+	if err := gc.ValidateTransaction(tx); err != nil {
+		return err
+	}
+
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	// A spend reveals a multisig address, so it can never be spent without
+	// its signatures again
+	if tx.Multisig != nil {
+		gc.multisigAddresses[tx.From] = true
+	}
+
+	if tx.IsTimeLocked() {
+		return gc.useLockNonce(tx)
+	}
+
+	next := gc.nonces[tx.From]
+	if tx.Nonce < next {
+		return ErrNonceTooLow
+	}
+	if tx.Nonce > next {
+		return ErrNonceGap
+	}
+
+	gc.nonces[tx.From] = next + 1
+	return nil
+}
+
+// useLockNonce consumes the nonce of a time-locked transaction and forgets
+// nonces whose transactions can no longer be included; callers must hold the lock
+func (gc *GoldCoin) useLockNonce(tx *Transaction) error {
+	used := gc.usedLockNonces[tx.From]
+	if used == nil {
+		used = make(map[uint64]uint64)
+		gc.usedLockNonces[tx.From] = used
+	}
+
+	if _, exists := used[tx.Nonce]; exists {
+		return ErrNonceTooLow
+	}
+
+	for nonce, expiry := range used {
+		if expiry != 0 && gc.BlockHeight > expiry {
+			delete(used, nonce)
+		}
+	}
+
+	used[tx.Nonce] = tx.ExpiryHeight
+	return nil
+}
+
+// RegisterMultisigAddress records an M-of-N address, so transactions from it
+// are rejected unless they carry its descriptor and enough signatures
+func (gc *GoldCoin) RegisterMultisigAddress(ms *identity.MultisigAddress) error {
+	if err := ms.Validate(); err != nil {
+		return err
+	}
+
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	gc.multisigAddresses[ms.Address] = true
 	return nil
 }
 
+// IsMultisigAddress reports whether an address is a known multisig address
+func (gc *GoldCoin) IsMultisigAddress(address string) bool {
+	gc.mutex.RLock()
+	defer gc.mutex.RUnlock()
+
+	return gc.multisigAddresses[address]
+}
+
+// GetNonce returns the next nonce an account's transaction must use to be applied
+func (gc *GoldCoin) GetNonce(address string) uint64 {
+	gc.mutex.RLock()
+	defer gc.mutex.RUnlock()
+
+	return gc.nonces[address]
+}
+
+// GetBlockHeight returns the chain height used to expire transactions
+func (gc *GoldCoin) GetBlockHeight() uint64 {
+	gc.mutex.RLock()
+	defer gc.mutex.RUnlock()
+
+	return gc.BlockHeight
+}
+
+// SetBlockHeight updates the chain height used to expire transactions
+func (gc *GoldCoin) SetBlockHeight(height uint64) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	gc.BlockHeight = height
+}
+
 // Mint creates new coins (only up to max supply)
 func (gc *GoldCoin) Mint(amount uint64) error {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
 	if gc.CircSupply+amount > gc.MaxSupply {
 		return errors.New("cannot mint: would exceed max supply")
 	}
@@ -110,16 +397,36 @@ func (gc *GoldCoin) Mint(amount uint64) error {
 	return nil
 }
 
+// unmint reverses a Mint whose coins were never handed out
+func (gc *GoldCoin) unmint(amount uint64) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	gc.CircSupply -= amount
+}
+
+// CirculatingSupply returns the minted supply less the fees burned
+func (gc *GoldCoin) CirculatingSupply() float64 {
+	gc.mutex.RLock()
+	defer gc.mutex.RUnlock()
+
+	return float64(gc.CircSupply) - gc.TotalBurned
+}
+
 // GetTokenomics returns the current tokenomics information
 func (gc *GoldCoin) GetTokenomics() map[string]interface{} {
 	return map[string]interface{}{
 		"name":           gc.Name,
 		"symbol":         gc.Symbol,
 		"maxSupply":      gc.MaxSupply,
-		"circSupply":     gc.CircSupply,
+		"circSupply":     gc.CirculatingSupply(),
+		"mintedSupply":   gc.CircSupply,
 		"decimals":       gc.Decimals,
 		"stakingReward":  gc.StakingReward,
 		"transactionFee": gc.TxFee,
 		"version":        gc.Version,
+		"chainId":        gc.ChainID,
+		"feeModel":       gc.GetFeeModel().Name(),
+		"totalBurned":    gc.TotalBurned,
 	}
 }
@@ -124,3 +124,108 @@ func TestGetTokenomics(t *testing.T) {
 		t.Errorf("Expected symbol GLD in tokenomics, got %v", tokenomics["symbol"])
 	}
 }
+
+func TestSequentialNonces(t *testing.T) {
+	gc := NewGoldCoin()
+
+	tx1, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	tx2, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+
+	if tx1.Nonce != 0 || tx2.Nonce != 1 {
+		t.Errorf("Expected nonces 0 and 1, got %d and %d", tx1.Nonce, tx2.Nonce)
+	}
+
+	if tx1.ID == tx2.ID {
+		t.Error("Expected identical transfers in the same second to have distinct IDs")
+	}
+
+	if err := gc.ApplyTransaction(tx2); err != ErrNonceGap {
+		t.Errorf("Expected ErrNonceGap applying nonce 1 first, got %v", err)
+	}
+
+	if err := gc.ApplyTransaction(tx1); err != nil {
+		t.Fatalf("Failed to apply transaction: %v", err)
+	}
+
+	if gc.GetNonce("from_addr") != 1 {
+		t.Errorf("Expected account nonce 1, got %d", gc.GetNonce("from_addr"))
+	}
+}
+
+func TestReleasedNonceIsReused(t *testing.T) {
+	gc := NewGoldCoin()
+
+	abandoned, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	gc.ReleaseNonce(abandoned)
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 50.0)
+	if tx.Nonce != abandoned.Nonce {
+		t.Errorf("Expected the released nonce %d to be reused, got %d", abandoned.Nonce, tx.Nonce)
+	}
+
+	if err := gc.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Expected the transaction after a released one to apply: %v", err)
+	}
+
+	// Releasing an applied transaction changes nothing
+	gc.ReleaseNonce(tx)
+	next, _ := gc.CreateTransaction("from_addr", "to_addr", 10.0)
+	if next.Nonce != 1 {
+		t.Errorf("Expected nonce 1 after an applied transaction, got %d", next.Nonce)
+	}
+}
+
+func TestReplayRejected(t *testing.T) {
+	gc := NewGoldCoin()
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	if err := gc.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Failed to apply transaction: %v", err)
+	}
+
+	if err := gc.ValidateTransaction(tx); err != ErrNonceTooLow {
+		t.Errorf("Expected ErrNonceTooLow for replayed transaction, got %v", err)
+	}
+
+	if err := gc.ApplyTransaction(tx); err != ErrNonceTooLow {
+		t.Errorf("Expected ErrNonceTooLow applying replayed transaction, got %v", err)
+	}
+}
+
+func TestWrongChainRejected(t *testing.T) {
+	gc := NewGoldCoin()
+	testnet := NewGoldCoin()
+	testnet.ChainID = "gld-testnet"
+
+	tx, _ := testnet.CreateTransaction("from_addr", "to_addr", 100.0)
+
+	if err := gc.ValidateTransaction(tx); err != ErrWrongChain {
+		t.Errorf("Expected ErrWrongChain, got %v", err)
+	}
+
+	// Rewriting the chain ID invalidates the transaction ID
+	tx.ChainID = gc.ChainID
+	if err := gc.ValidateTransaction(tx); err == nil {
+		t.Error("Expected error after tampering with chain ID")
+	}
+}
+
+func TestTransactionExpiry(t *testing.T) {
+	gc := NewGoldCoin()
+	gc.TxExpiryBlocks = 10
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	if tx.ExpiryHeight != 10 {
+		t.Errorf("Expected expiry height 10, got %d", tx.ExpiryHeight)
+	}
+
+	gc.SetBlockHeight(10)
+	if err := gc.ValidateTransaction(tx); err != nil {
+		t.Errorf("Expected transaction valid at its expiry height: %v", err)
+	}
+
+	gc.SetBlockHeight(11)
+	if err := gc.ValidateTransaction(tx); err != ErrTxExpired {
+		t.Errorf("Expected ErrTxExpired, got %v", err)
+	}
+}
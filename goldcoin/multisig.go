@@ -0,0 +1,140 @@
+package goldcoin
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/Bituncoin/Bituncoin/identity"
+)
+
+// psbtVersion is the serialization version of partially-signed transactions
+const psbtVersion = 1
+
+// PartiallySignedTransaction is a multisig transaction passed between signers
+// until enough signatures are collected
+type PartiallySignedTransaction struct {
+	Version     int          `json:"version"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// CreateMultisigTransaction creates an unsigned transaction spending from a
+// multisig address, registering the address if it was not known
+func (gc *GoldCoin) CreateMultisigTransaction(ms *identity.MultisigAddress, to string, amount float64) (*PartiallySignedTransaction, error) {
+	if err := gc.RegisterMultisigAddress(ms); err != nil {
+		return nil, err
+	}
+
+	tx, err := gc.CreateTransaction(ms.Address, to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Multisig = ms
+	tx.Signatures = make(map[string]string)
+
+	// Multisig transactions carry more keys and signatures, so reprice them
+	tx.Fee = gc.GetFeeModel().CalculateFee(amount, tx.Size())
+	tx.ID = tx.generateID()
+
+	return NewPartiallySignedTransaction(tx)
+}
+
+// NewPartiallySignedTransaction wraps a multisig transaction for signing
+func NewPartiallySignedTransaction(tx *Transaction) (*PartiallySignedTransaction, error) {
+	if tx == nil {
+		return nil, errors.New("transaction is nil")
+	}
+
+	if tx.Multisig == nil {
+		return nil, errors.New("transaction is not a multisig transaction")
+	}
+
+	if tx.Signatures == nil {
+		tx.Signatures = make(map[string]string)
+	}
+
+	return &PartiallySignedTransaction{
+		Version:     psbtVersion,
+		Transaction: tx,
+	}, nil
+}
+
+// Sign adds a signature from one of the addresses held by an address manager
+func (p *PartiallySignedTransaction) Sign(am *identity.AddressManager, address string) error {
+	addr, err := am.GetAddress(address)
+	if err != nil {
+		return err
+	}
+
+	signature, err := am.SignMessage(address, p.Transaction.ID)
+	if err != nil {
+		return err
+	}
+
+	return p.AddSignature(addr.PublicKey, signature)
+}
+
+// AddSignature adds a signer's signature after checking it against the transaction
+func (p *PartiallySignedTransaction) AddSignature(publicKey, signature string) error {
+	ms := p.Transaction.Multisig
+	if !ms.HasPublicKey(publicKey) {
+		return errors.New("public key is not a signer of this multisig address")
+	}
+
+	if !identity.VerifyPublicKeySignature(publicKey, p.Transaction.ID, signature) {
+		return errors.New("invalid signature")
+	}
+
+	p.Transaction.Signatures[publicKey] = signature
+	return nil
+}
+
+// SignatureCount returns the number of valid signatures collected so far
+func (p *PartiallySignedTransaction) SignatureCount() int {
+	return p.Transaction.Multisig.CountValidSignatures(p.Transaction.ID, p.Transaction.Signatures)
+}
+
+// IsComplete checks if the signature threshold has been reached
+func (p *PartiallySignedTransaction) IsComplete() bool {
+	return p.SignatureCount() >= p.Transaction.Multisig.Threshold
+}
+
+// Finalize returns the fully signed transaction once the threshold is met
+func (p *PartiallySignedTransaction) Finalize() (*Transaction, error) {
+	if !p.IsComplete() {
+		return nil, errors.New("not enough signatures to finalize")
+	}
+
+	return p.Transaction, nil
+}
+
+// Serialize encodes the partially-signed transaction for passing to the next signer
+func (p *PartiallySignedTransaction) Serialize() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// DeserializePartiallySignedTransaction decodes a partially-signed transaction
+func DeserializePartiallySignedTransaction(data []byte) (*PartiallySignedTransaction, error) {
+	p := &PartiallySignedTransaction{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	if p.Version != psbtVersion {
+		return nil, errors.New("unsupported partially-signed transaction version")
+	}
+
+	if p.Transaction == nil || p.Transaction.Multisig == nil {
+		return nil, errors.New("partially-signed transaction is missing multisig data")
+	}
+
+	if err := p.Transaction.Multisig.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Transaction.Signatures == nil {
+		p.Transaction.Signatures = make(map[string]string)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,188 @@
+package goldcoin
+
+import (
+	"testing"
+
+	"github.com/Bituncoin/Bituncoin/identity"
+)
+
+func newTestMultisig(t *testing.T, threshold, n int) (*identity.AddressManager, []*identity.Address, *identity.MultisigAddress) {
+	am := identity.NewAddressManager()
+
+	signers := make([]*identity.Address, n)
+	publicKeys := make([]string, n)
+	for i := 0; i < n; i++ {
+		addr, err := am.GenerateAddress("signer")
+		if err != nil {
+			t.Fatalf("Failed to generate address: %v", err)
+		}
+		signers[i] = addr
+		publicKeys[i] = addr.PublicKey
+	}
+
+	ms, err := identity.NewMultisigAddress(threshold, publicKeys)
+	if err != nil {
+		t.Fatalf("Failed to create multisig address: %v", err)
+	}
+
+	return am, signers, ms
+}
+
+func TestMultisigAddressIsOrderIndependent(t *testing.T) {
+	_, signers, ms := newTestMultisig(t, 2, 3)
+
+	reordered, err := identity.NewMultisigAddress(2, []string{
+		signers[2].PublicKey, signers[0].PublicKey, signers[1].PublicKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create multisig address: %v", err)
+	}
+
+	if reordered.Address != ms.Address {
+		t.Error("Expected the same address regardless of key order")
+	}
+
+	if _, err := identity.NewMultisigAddress(4, reordered.PublicKeys); err == nil {
+		t.Error("Expected error for threshold above key count")
+	}
+}
+
+func TestMultisigTransactionThreshold(t *testing.T) {
+	gc := NewGoldCoin()
+	am, signers, ms := newTestMultisig(t, 2, 3)
+
+	psbt, err := gc.CreateMultisigTransaction(ms, "to_addr", 100.0)
+	if err != nil {
+		t.Fatalf("Failed to create multisig transaction: %v", err)
+	}
+
+	if err := psbt.Sign(am, signers[0].Address); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	if psbt.IsComplete() {
+		t.Error("Expected transaction to be incomplete with 1 of 2 signatures")
+	}
+
+	if err := gc.ValidateTransaction(psbt.Transaction); err == nil {
+		t.Error("Expected validation to fail below threshold")
+	}
+
+	// Pass the partially-signed transaction to the next signer
+	data, err := psbt.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize: %v", err)
+	}
+
+	received, err := DeserializePartiallySignedTransaction(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+
+	if err := received.Sign(am, signers[2].Address); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	tx, err := received.Finalize()
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	if err := gc.ValidateTransaction(tx); err != nil {
+		t.Errorf("Expected fully signed transaction to validate: %v", err)
+	}
+}
+
+func TestMultisigRejectsForeignSigner(t *testing.T) {
+	gc := NewGoldCoin()
+	am, _, ms := newTestMultisig(t, 1, 2)
+
+	outsider, _ := am.GenerateAddress("outsider")
+
+	psbt, _ := gc.CreateMultisigTransaction(ms, "to_addr", 100.0)
+	if err := psbt.Sign(am, outsider.Address); err == nil {
+		t.Error("Expected error signing with a key outside the multisig set")
+	}
+
+	// Forge a signature entry directly on the transaction
+	signature, _ := am.SignMessage(outsider.Address, psbt.Transaction.ID)
+	psbt.Transaction.Signatures[outsider.PublicKey] = signature
+
+	if err := gc.ValidateTransaction(psbt.Transaction); err == nil {
+		t.Error("Expected validation to ignore signatures from outside the multisig set")
+	}
+}
+
+func TestMultisigSignatureCoversFullPrecision(t *testing.T) {
+	gc := NewGoldCoin()
+	am, signers, ms := newTestMultisig(t, 1, 1)
+
+	psbt, _ := gc.CreateMultisigTransaction(ms, "to_addr", 100.00000001)
+	if err := psbt.Sign(am, signers[0].Address); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	// Change the amount in its 8th decimal and keep the signature
+	forged := *psbt.Transaction
+	forged.Amount = 100.00000009
+	forged.ID = forged.generateID()
+
+	if forged.ID == psbt.Transaction.ID {
+		t.Fatal("Expected amounts differing in the 8th decimal to have different IDs")
+	}
+
+	if err := gc.ValidateTransaction(&forged); err == nil {
+		t.Error("Expected the signature not to validate for a different amount")
+	}
+}
+
+func TestMultisigSenderRequiresDescriptor(t *testing.T) {
+	gc := NewGoldCoin()
+	_, _, ms := newTestMultisig(t, 2, 3)
+
+	psbt, err := gc.CreateMultisigTransaction(ms, "to_addr", 100.0)
+	if err != nil {
+		t.Fatalf("Failed to create multisig transaction: %v", err)
+	}
+
+	// Stripping the descriptor leaves the ID valid, since it does not cover it
+	stripped := *psbt.Transaction
+	stripped.Multisig = nil
+	stripped.Signatures = nil
+	stripped.Fee = gc.GetFeeModel().CalculateFee(stripped.Amount, stripped.Size())
+	stripped.ID = stripped.generateID()
+
+	if err := gc.ValidateTransaction(&stripped); err != ErrMultisigRequired {
+		t.Errorf("Expected ErrMultisigRequired for a stripped descriptor, got %v", err)
+	}
+
+	// A node that only learned of the address by registering it also refuses
+	other := NewGoldCoin()
+	if err := other.RegisterMultisigAddress(ms); err != nil {
+		t.Fatalf("Failed to register multisig address: %v", err)
+	}
+	if err := other.ValidateTransaction(&stripped); err != ErrMultisigRequired {
+		t.Errorf("Expected ErrMultisigRequired after registering, got %v", err)
+	}
+}
+
+func TestAppliedMultisigSpendRevealsAddress(t *testing.T) {
+	wallet := NewGoldCoin()
+	am, signers, ms := newTestMultisig(t, 1, 2)
+
+	psbt, _ := wallet.CreateMultisigTransaction(ms, "to_addr", 100.0)
+	psbt.Sign(am, signers[0].Address) //nolint:errcheck
+	tx, err := psbt.Finalize()
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	node := NewGoldCoin()
+	if err := node.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+
+	if !node.IsMultisigAddress(ms.Address) {
+		t.Error("Expected an applied multisig spend to record the address")
+	}
+}
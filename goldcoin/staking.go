@@ -2,28 +2,105 @@ package goldcoin
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/Bituncoin/Bituncoin/storage"
 )
 
 // StakingPool manages staking for Gold-Coin
 type StakingPool struct {
-	Stakes        map[string]*Stake
-	TotalStaked   float64
-	AnnualReward  float64
-	MinStake      float64
-	LockPeriod    int64 // in seconds
-	mutex         sync.RWMutex
+	Stakes              map[string]*Stake // position ID -> stake
+	Tiers               map[string]*StakingTier
+	TotalStaked         float64
+	AnnualReward        float64
+	MinStake            float64
+	LockPeriod          int64   // in seconds
+	EarlyUnstakePenalty float64 // percent of principal forfeited by untiered positions
+	TotalPenalties      float64
+	RewardReserve       float64 // funds available to pay rewards
+	TotalRewardsPaid    float64
+	indexes             map[string]*RewardIndex // reward index per rate group
+	byAddress           map[string][]string
+	compoundStop        chan struct{}
+	store               storage.KV
+	saved               []byte    // last snapshot written to store
+	treasury            *Treasury // saved with the pool, once attached
+	treasuryBalance     *float64  // saved treasury balance not yet attached
+	chainHeight         uint64
+	chainHash           string
+	nextID              uint64
+	mutex               sync.RWMutex
+}
+
+// StakingTier is a lock period offered at its own APY
+type StakingTier struct {
+	Name                string
+	LockPeriod          int64   // in seconds
+	APY                 float64 // annual reward in percent
+	EarlyUnstakePenalty float64 // percent of principal forfeited when unstaking before unlock
 }
 
-// Stake represents a staking position
+// Default staking tier names
+const (
+	TierFlexible = "flexible"
+	Tier30Days   = "30d"
+	Tier90Days   = "90d"
+	Tier365Days  = "365d"
+)
+
+// Stake represents a staking position. An address may hold many positions,
+// each with its own amount, lock period and reward rate.
 type Stake struct {
-	Address      string
-	Amount       float64
-	StartTime    int64
-	UnlockTime   int64
-	RewardsClaimed float64
-	IsActive     bool
+	ID                  string
+	Address             string
+	Tier                string // empty for positions with a custom lock
+	Amount              float64
+	LockPeriod          int64   // in seconds
+	EarlyUnstakePenalty float64 // percent of principal
+	StartTime           int64
+	UnlockTime          int64
+	RewardIndex         string  // key of the reward index the position accrues from
+	RewardPerTokenPaid  float64 // index value at the position's last settlement
+	AccruedRewards      float64 // rewards settled up to RewardPerTokenPaid
+	RewardsClaimed      float64
+	PenaltyPaid         float64
+	IsActive            bool
+	AutoCompound        bool
+	CompoundInterval    int64 // in seconds
+	LastCompoundTime    int64 // in nanoseconds
+	CompoundHistory     []CompoundEvent
+}
+
+// RewardIndex accumulates the reward earned per staked coin by every position
+// in a rate group. Positions settle against it, so a rate change or a stake
+// change only affects accrual from that moment on, in O(1).
+type RewardIndex struct {
+	Rate           float64 // annual reward in percent
+	RewardPerToken float64 // cumulative reward per staked coin
+	LastUpdate     int64   // in nanoseconds
+}
+
+// valueAt returns the reward per token accumulated up to now
+func (ri *RewardIndex) valueAt(now int64) float64 {
+	if now <= ri.LastUpdate {
+		return ri.RewardPerToken
+	}
+
+	timeElapsed := float64(now-ri.LastUpdate) / float64(time.Second)
+	years := timeElapsed / (365.25 * 24 * 60 * 60)
+	return ri.RewardPerToken + (ri.Rate/100.0)*years
+}
+
+// update checkpoints the index at now
+func (ri *RewardIndex) update(now int64) {
+	ri.RewardPerToken = ri.valueAt(now)
+	if now > ri.LastUpdate {
+		ri.LastUpdate = now
+	}
 }
 
 // NewStakingPool creates a new staking pool
@@ -31,146 +108,400 @@ func NewStakingPool() *StakingPool {
 	return &StakingPool{
 		Stakes:       make(map[string]*Stake),
 		TotalStaked:  0,
-		AnnualReward: 5.0,              // 5% annual reward
-		MinStake:     100.0,            // Minimum 100 GLD
+		AnnualReward: 5.0,               // 5% annual reward
+		MinStake:     100.0,             // Minimum 100 GLD
 		LockPeriod:   30 * 24 * 60 * 60, // 30 days
+		indexes:      make(map[string]*RewardIndex),
+		byAddress:    make(map[string][]string),
+
+		EarlyUnstakePenalty: 10.0, // 10% of principal
+		Tiers: map[string]*StakingTier{
+			TierFlexible: {Name: TierFlexible, LockPeriod: 0, APY: 2.0, EarlyUnstakePenalty: 0},
+			Tier30Days:   {Name: Tier30Days, LockPeriod: 30 * 24 * 60 * 60, APY: 5.0, EarlyUnstakePenalty: 10.0},
+			Tier90Days:   {Name: Tier90Days, LockPeriod: 90 * 24 * 60 * 60, APY: 8.0, EarlyUnstakePenalty: 15.0},
+			Tier365Days:  {Name: Tier365Days, LockPeriod: 365 * 24 * 60 * 60, APY: 12.0, EarlyUnstakePenalty: 25.0},
+		},
 	}
 }
 
-// CreateStake creates a new stake for an address
-func (sp *StakingPool) CreateStake(address string, amount float64) error {
+// CreateStake opens a new position for an address with the pool's default
+// lock period and reward rate
+func (sp *StakingPool) CreateStake(address string, amount float64) (*Stake, error) {
+	sp.mutex.RLock()
+	lockPeriod, rewardRate := sp.LockPeriod, sp.AnnualReward
+	sp.mutex.RUnlock()
+
+	return sp.CreateStakeWithLock(address, amount, lockPeriod, rewardRate)
+}
+
+// CreateStakeWithLock opens a new position with its own lock period (seconds)
+// and annual reward rate (percent)
+func (sp *StakingPool) CreateStakeWithLock(address string, amount float64, lockPeriod int64, rewardRate float64) (*Stake, error) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	return sp.openStake(address, amount, "", lockPeriod, rewardRate, sp.EarlyUnstakePenalty)
+}
+
+// CreateTieredStake opens a new position in a staking tier
+func (sp *StakingPool) CreateTieredStake(address string, amount float64, tierName string) (*Stake, error) {
 	sp.mutex.Lock()
 	defer sp.mutex.Unlock()
 
+	tier, exists := sp.Tiers[tierName]
+	if !exists {
+		return nil, errors.New("staking tier not found")
+	}
+
+	return sp.openStake(address, amount, tier.Name, tier.LockPeriod, tier.APY, tier.EarlyUnstakePenalty)
+}
+
+// openStake records a new position; callers must hold the write lock
+func (sp *StakingPool) openStake(address string, amount float64, tier string, lockPeriod int64, rewardRate, penalty float64) (*Stake, error) {
 	if address == "" {
-		return errors.New("invalid address")
+		return nil, errors.New("invalid address")
 	}
 
 	if amount < sp.MinStake {
-		return errors.New("amount below minimum stake")
+		return nil, errors.New("amount below minimum stake")
 	}
 
-	if _, exists := sp.Stakes[address]; exists {
-		return errors.New("stake already exists for this address")
+	if lockPeriod < 0 || rewardRate < 0 {
+		return nil, errors.New("invalid lock period or reward rate")
 	}
 
+	sp.nextID++
 	now := time.Now().UnixNano()
+
+	// Tier positions share the tier's index; custom positions share one per fixed rate
+	key := fmt.Sprintf("rate:%g", rewardRate)
+	if tier != "" {
+		key = "tier:" + tier
+	}
+	index := sp.rewardIndex(key, rewardRate, now)
+	index.update(now)
+
 	stake := &Stake{
-		Address:        address,
-		Amount:         amount,
-		StartTime:      now,
-		UnlockTime:     now + sp.LockPeriod*int64(time.Second),
-		RewardsClaimed: 0,
-		IsActive:       true,
+		ID:                  positionID(sp.nextID),
+		Address:             address,
+		Tier:                tier,
+		Amount:              amount,
+		LockPeriod:          lockPeriod,
+		EarlyUnstakePenalty: penalty,
+		StartTime:           now,
+		UnlockTime:          now + lockPeriod*int64(time.Second),
+		RewardIndex:         key,
+		RewardPerTokenPaid:  index.RewardPerToken,
+		RewardsClaimed:      0,
+		IsActive:            true,
 	}
 
-	sp.Stakes[address] = stake
+	sp.Stakes[stake.ID] = stake
+	sp.byAddress[address] = append(sp.byAddress[address], stake.ID)
 	sp.TotalStaked += amount
 
-	return nil
+	if err := sp.persist(); err != nil {
+		return nil, err
+	}
+
+	return stake, nil
+}
+
+// positionID formats the ID of the n-th position opened in a pool
+func positionID(n uint64) string {
+	return fmt.Sprintf("stake-%d", n)
 }
 
-// CalculateRewards calculates the current rewards for a stake
-func (sp *StakingPool) CalculateRewards(address string) (float64, error) {
+// activeStake returns an active position; callers must hold the lock
+func (sp *StakingPool) activeStake(positionID string) (*Stake, error) {
+	stake, exists := sp.Stakes[positionID]
+	if !exists {
+		return nil, errors.New("stake not found")
+	}
+
+	if !stake.IsActive {
+		return nil, errors.New("stake is not active")
+	}
+
+	return stake, nil
+}
+
+// rewardIndex returns the index for a rate group, creating it at rate if missing;
+// callers must hold the write lock
+func (sp *StakingPool) rewardIndex(key string, rate float64, now int64) *RewardIndex {
+	index, exists := sp.indexes[key]
+	if !exists {
+		index = &RewardIndex{Rate: rate, LastUpdate: now}
+		sp.indexes[key] = index
+	}
+	return index
+}
+
+// accruedRewards returns the total rewards earned by a position since it started
+func (sp *StakingPool) accruedRewards(stake *Stake, now int64) float64 {
+	index := sp.indexes[stake.RewardIndex]
+	return stake.AccruedRewards + stake.Amount*(index.valueAt(now)-stake.RewardPerTokenPaid)
+}
+
+// settle checkpoints a position against its index so later amount or rate
+// changes only apply from now; callers must hold the write lock
+func (sp *StakingPool) settle(stake *Stake, now int64) {
+	index := sp.indexes[stake.RewardIndex]
+	index.update(now)
+	stake.AccruedRewards += stake.Amount * (index.RewardPerToken - stake.RewardPerTokenPaid)
+	stake.RewardPerTokenPaid = index.RewardPerToken
+}
+
+// GetRewardRate returns the annual reward rate a position currently earns
+func (sp *StakingPool) GetRewardRate(positionID string) (float64, error) {
 	sp.mutex.RLock()
 	defer sp.mutex.RUnlock()
 
-	stake, exists := sp.Stakes[address]
+	stake, exists := sp.Stakes[positionID]
 	if !exists {
 		return 0, errors.New("stake not found")
 	}
 
-	if !stake.IsActive {
-		return 0, errors.New("stake is not active")
-	}
+	return sp.indexes[stake.RewardIndex].Rate, nil
+}
 
-	// Calculate time elapsed in years (StartTime stored in nanoseconds)
-	now := time.Now().UnixNano()
-	timeElapsed := float64(now-stake.StartTime) / float64(time.Second)
-	years := timeElapsed / (365.25 * 24 * 60 * 60)
+// CalculateRewards calculates the current unclaimed rewards for a position.
+// Closed positions report rewards still owed from when they were unstaked.
+func (sp *StakingPool) CalculateRewards(positionID string) (float64, error) {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	stake, exists := sp.Stakes[positionID]
+	if !exists {
+		return 0, errors.New("stake not found")
+	}
 
-	// Calculate rewards
-	rewards := stake.Amount * (sp.AnnualReward / 100.0) * years
-	totalRewards := rewards - stake.RewardsClaimed
+	if !stake.IsActive {
+		return stake.AccruedRewards - stake.RewardsClaimed, nil
+	}
 
-	return totalRewards, nil
+	return sp.accruedRewards(stake, time.Now().UnixNano()) - stake.RewardsClaimed, nil
 }
 
-// ClaimRewards claims the accumulated rewards
-func (sp *StakingPool) ClaimRewards(address string) (float64, error) {
+// ClaimRewards pays the accumulated rewards of a position from the reward
+// reserve. Closed positions may claim rewards left owed at unstake.
+func (sp *StakingPool) ClaimRewards(positionID string) (float64, error) {
 	sp.mutex.Lock()
 	defer sp.mutex.Unlock()
 
-	stake, exists := sp.Stakes[address]
+	stake, exists := sp.Stakes[positionID]
 	if !exists {
 		return 0, errors.New("stake not found")
 	}
 
-	if !stake.IsActive {
-		return 0, errors.New("stake is not active")
+	if stake.IsActive {
+		sp.settle(stake, time.Now().UnixNano())
 	}
 
-	// Calculate rewards (StartTime stored in nanoseconds)
-	now := time.Now().UnixNano()
-	timeElapsed := float64(now-stake.StartTime) / float64(time.Second)
-	years := timeElapsed / (365.25 * 24 * 60 * 60)
-	rewards := stake.Amount * (sp.AnnualReward / 100.0) * years
-	claimableRewards := rewards - stake.RewardsClaimed
-
+	claimableRewards := stake.AccruedRewards - stake.RewardsClaimed
 	if claimableRewards <= 0 {
 		return 0, errors.New("no rewards to claim")
 	}
 
-	stake.RewardsClaimed += claimableRewards
+	if claimableRewards > sp.RewardReserve {
+		return 0, ErrInsufficientReserve
+	}
+
+	sp.payReward(stake, claimableRewards)
+
+	if err := sp.persist(); err != nil {
+		return 0, err
+	}
 
 	return claimableRewards, nil
 }
 
-// Unstake removes a stake and returns the staked amount plus unclaimed rewards
-func (sp *StakingPool) Unstake(address string) (float64, float64, error) {
+// Unstake closes a position and returns the staked amount and the unclaimed
+// rewards paid. Rewards are paid up to the reward reserve; any remainder stays
+// claimable. Unstaking before the unlock time forfeits the early-unstake
+// penalty to the reward reserve.
+func (sp *StakingPool) Unstake(positionID string) (float64, float64, error) {
 	sp.mutex.Lock()
 	defer sp.mutex.Unlock()
 
-	stake, exists := sp.Stakes[address]
-	if !exists {
-		return 0, 0, errors.New("stake not found")
+	stake, err := sp.activeStake(positionID)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	if !stake.IsActive {
-		return 0, 0, errors.New("stake is not active")
+	now := time.Now().UnixNano()
+	sp.settle(stake, now)
+
+	unclaimedRewards := math.Min(stake.AccruedRewards-stake.RewardsClaimed, sp.RewardReserve)
+	if unclaimedRewards > 0 {
+		sp.payReward(stake, unclaimedRewards)
+	} else {
+		unclaimedRewards = 0
 	}
+	stakedAmount := stake.Amount
 
-	now := time.Now().UnixNano()
 	if now < stake.UnlockTime {
-		return 0, 0, errors.New("stake is still locked")
+		penalty := stakedAmount * stake.EarlyUnstakePenalty / 100.0
+		stake.PenaltyPaid = penalty
+		sp.TotalPenalties += penalty
+		sp.RewardReserve += penalty
+		stakedAmount -= penalty
 	}
 
-	// Calculate final rewards (StartTime stored in nanoseconds)
-	timeElapsed := float64(now-stake.StartTime) / float64(time.Second)
-	years := timeElapsed / (365.25 * 24 * 60 * 60)
-	rewards := stake.Amount * (sp.AnnualReward / 100.0) * years
-	unclaimedRewards := rewards - stake.RewardsClaimed
-
-	stakedAmount := stake.Amount
-	
 	// Deactivate stake
 	stake.IsActive = false
-	sp.TotalStaked -= stakedAmount
+	sp.TotalStaked -= stake.Amount
+
+	if err := sp.persist(); err != nil {
+		return 0, 0, err
+	}
 
 	return stakedAmount, unclaimedRewards, nil
 }
 
-// GetStakeInfo returns information about a stake
-func (sp *StakingPool) GetStakeInfo(address string) (*Stake, error) {
+// GetEarlyUnstakePenalty returns the principal that would be forfeited by unstaking a position now
+func (sp *StakingPool) GetEarlyUnstakePenalty(positionID string) (float64, error) {
 	sp.mutex.RLock()
 	defer sp.mutex.RUnlock()
 
-	stake, exists := sp.Stakes[address]
+	stake, err := sp.activeStake(positionID)
+	if err != nil {
+		return 0, err
+	}
+
+	if time.Now().UnixNano() >= stake.UnlockTime {
+		return 0, nil
+	}
+
+	return stake.Amount * stake.EarlyUnstakePenalty / 100.0, nil
+}
+
+// AddTier adds or replaces a staking tier. Existing positions keep their terms.
+func (sp *StakingPool) AddTier(tier *StakingTier) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if tier == nil || tier.Name == "" {
+		return errors.New("invalid tier")
+	}
+
+	if tier.LockPeriod < 0 || tier.APY < 0 || tier.EarlyUnstakePenalty < 0 || tier.EarlyUnstakePenalty > 100 {
+		return errors.New("invalid tier parameters")
+	}
+
+	if existing, exists := sp.Tiers[tier.Name]; exists && existing.APY != tier.APY {
+		sp.setTierAPY(tier.Name, tier.APY)
+	}
+
+	t := *tier
+	sp.Tiers[tier.Name] = &t
+	return sp.persist()
+}
+
+// SetTierAPY changes a tier's APY. Positions in the tier earn the new rate
+// from now on; rewards accrued so far are kept at the old rate.
+func (sp *StakingPool) SetTierAPY(tierName string, apy float64) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	tier, exists := sp.Tiers[tierName]
+	if !exists {
+		return errors.New("staking tier not found")
+	}
+
+	if apy < 0 {
+		return errors.New("invalid APY")
+	}
+
+	sp.setTierAPY(tierName, apy)
+	tier.APY = apy
+	return sp.persist()
+}
+
+// setTierAPY checkpoints the tier's index and reprices it; callers must hold the write lock
+func (sp *StakingPool) setTierAPY(tierName string, apy float64) {
+	index, exists := sp.indexes["tier:"+tierName]
+	if !exists {
+		return
+	}
+
+	index.update(time.Now().UnixNano())
+	index.Rate = apy
+}
+
+// GetTiers returns the available staking tiers
+func (sp *StakingPool) GetTiers() []*StakingTier {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	tiers := make([]*StakingTier, 0, len(sp.Tiers))
+	for _, tier := range sp.Tiers {
+		t := *tier
+		tiers = append(tiers, &t)
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].LockPeriod < tiers[j].LockPeriod
+	})
+
+	return tiers
+}
+
+// clone returns a copy of the position that shares no state with the pool
+func (s *Stake) clone() *Stake {
+	c := *s
+	c.CompoundHistory = append([]CompoundEvent(nil), s.CompoundHistory...)
+	return &c
+}
+
+// GetStakeInfo returns a copy of a position
+func (sp *StakingPool) GetStakeInfo(positionID string) (*Stake, error) {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	stake, exists := sp.Stakes[positionID]
 	if !exists {
 		return nil, errors.New("stake not found")
 	}
 
-	return stake, nil
+	return stake.clone(), nil
+}
+
+// GetStakesByAddress returns copies of every position opened by an address
+func (sp *StakingPool) GetStakesByAddress(address string) []*Stake {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	ids := sp.byAddress[address]
+	stakes := make([]*Stake, 0, len(ids))
+	for _, id := range ids {
+		stakes = append(stakes, sp.Stakes[id].clone())
+	}
+
+	return stakes
+}
+
+// GetAddressStaked returns the total actively staked by an address across positions
+func (sp *StakingPool) GetAddressStaked(address string) float64 {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	total := 0.0
+	for _, id := range sp.byAddress[address] {
+		if stake := sp.Stakes[id]; stake.IsActive {
+			total += stake.Amount
+		}
+	}
+
+	return total
+}
+
+// GetTotalStaked returns the total actively staked in the pool
+func (sp *StakingPool) GetTotalStaked() float64 {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	return sp.TotalStaked
 }
 
 // GetPoolInfo returns information about the staking pool
@@ -191,12 +522,17 @@ func (sp *StakingPool) GetPoolInfo() map[string]interface{} {
 		"minStake":      sp.MinStake,
 		"lockPeriod":    sp.LockPeriod,
 		"activeStakes":  activeStakes,
-		"totalStakers":  len(sp.Stakes),
+		"totalStakers":  len(sp.byAddress),
+		"tiers":         len(sp.Tiers),
+		"penalties":     sp.TotalPenalties,
+		"rewardReserve": sp.RewardReserve,
+		"rewardsPaid":   sp.TotalRewardsPaid,
+		"runwayDays":    sp.runwayDays(),
 	}
 }
 
-// IncreaseStake adds more coins to an existing stake
-func (sp *StakingPool) IncreaseStake(address string, amount float64) error {
+// IncreaseStake adds more coins to a position and restarts its lock period
+func (sp *StakingPool) IncreaseStake(positionID string, amount float64) error {
 	sp.mutex.Lock()
 	defer sp.mutex.Unlock()
 
@@ -204,17 +540,16 @@ func (sp *StakingPool) IncreaseStake(address string, amount float64) error {
 		return errors.New("invalid amount")
 	}
 
-	stake, exists := sp.Stakes[address]
-	if !exists {
-		return errors.New("stake not found")
-	}
-
-	if !stake.IsActive {
-		return errors.New("stake is not active")
+	stake, err := sp.activeStake(positionID)
+	if err != nil {
+		return err
 	}
 
+	now := time.Now().UnixNano()
+	sp.settle(stake, now)
 	stake.Amount += amount
+	stake.UnlockTime = now + stake.LockPeriod*int64(time.Second)
 	sp.TotalStaked += amount
 
-	return nil
+	return sp.persist()
 }
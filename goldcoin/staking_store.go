@@ -0,0 +1,262 @@
+package goldcoin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+// stakingPoolKey is the storage key of the persisted staking pool
+const stakingPoolKey = "goldcoin_staking_pool"
+
+// StakingSchema versions the persisted staking pool. Register a migration
+// here whenever stakingSnapshot changes in a way old data cannot decode into.
+var StakingSchema = newStakingSchema()
+
+// newStakingSchema creates the staking schema with its migrations
+func newStakingSchema() *storage.Schema {
+	schema := storage.NewSchema("goldcoin_staking")
+	schema.Register(&storage.Migration{Version: 1, Description: "staking pool snapshot with reward reserve"})
+	return schema
+}
+
+// stakingSnapshot is the persisted form of a StakingPool
+type stakingSnapshot struct {
+	Stakes              map[string]*Stake       `json:"stakes"`
+	Tiers               map[string]*StakingTier `json:"tiers"`
+	Indexes             map[string]*RewardIndex `json:"indexes"`
+	TotalStaked         float64                 `json:"totalStaked"`
+	AnnualReward        float64                 `json:"annualReward"`
+	MinStake            float64                 `json:"minStake"`
+	LockPeriod          int64                   `json:"lockPeriod"`
+	EarlyUnstakePenalty float64                 `json:"earlyUnstakePenalty"`
+	TotalPenalties      float64                 `json:"totalPenalties"`
+	RewardReserve       float64                 `json:"rewardReserve"`
+	TotalRewardsPaid    float64                 `json:"totalRewardsPaid"`
+	NextID              uint64                  `json:"nextId"`
+	ChainHeight         uint64                  `json:"chainHeight"`
+	ChainHash           string                  `json:"chainHash,omitempty"`
+	Treasury            *float64                `json:"treasury,omitempty"`
+}
+
+// NewStakingPoolWithStore creates a staking pool that is saved to db on every
+// mutation, restoring any previously saved state. Saved state from an older
+// schema version is migrated first.
+func NewStakingPoolWithStore(db storage.KV) (*StakingPool, error) {
+	if db == nil {
+		return nil, errors.New("storage is nil")
+	}
+
+	if _, err := StakingSchema.Migrate(db); err != nil {
+		return nil, err
+	}
+
+	sp := NewStakingPool()
+	sp.store = db
+
+	if !db.Has(stakingPoolKey) {
+		return sp, sp.persist()
+	}
+
+	data, err := db.Get(stakingPoolKey)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &stakingSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	sp.restore(snapshot)
+	sp.saved = data
+
+	if err := sp.checkInvariants(); err != nil {
+		return nil, fmt.Errorf("corrupt staking state: %v", err)
+	}
+
+	return sp, nil
+}
+
+// SetChainTip records the chain block the staking state corresponds to
+func (sp *StakingPool) SetChainTip(height uint64, hash string) error {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	sp.chainHeight = height
+	sp.chainHash = hash
+	return sp.persist()
+}
+
+// AttachTreasury saves the treasury balance with the pool's state, so funding
+// the reward reserve is a single write. A balance restored from storage
+// replaces the treasury's initial allocation.
+func (sp *StakingPool) AttachTreasury(treasury *Treasury) error {
+	if treasury == nil {
+		return errors.New("treasury is nil")
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.treasury != nil {
+		return errors.New("pool already has a treasury")
+	}
+
+	treasury.mutex.Lock()
+	if treasury.pool != nil {
+		treasury.mutex.Unlock()
+		return ErrTreasuryAttached
+	}
+	if sp.treasuryBalance != nil {
+		treasury.balance = *sp.treasuryBalance
+	}
+	treasury.pool = sp
+	treasury.mutex.Unlock()
+
+	sp.treasury = treasury
+	return sp.persist()
+}
+
+// CheckConsistency verifies the staking state is internally consistent and
+// not ahead of, or on another fork from, the chain tip it is restored
+// against. An empty chainHash skips the hash comparison.
+func (sp *StakingPool) CheckConsistency(chainHeight uint64, chainHash string) error {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	if sp.chainHeight > chainHeight {
+		return fmt.Errorf("staking state at height %d is ahead of chain height %d", sp.chainHeight, chainHeight)
+	}
+
+	if sp.chainHeight == chainHeight && chainHash != "" && sp.chainHash != "" && sp.chainHash != chainHash {
+		return fmt.Errorf("staking state block hash %s does not match chain tip %s", sp.chainHash, chainHash)
+	}
+
+	return sp.checkInvariants()
+}
+
+// checkInvariants verifies totals and indexes match the positions; callers must hold the lock
+func (sp *StakingPool) checkInvariants() error {
+	total := 0.0
+	for id, stake := range sp.Stakes {
+		if stake.ID != id {
+			return fmt.Errorf("position %s stored under %s", stake.ID, id)
+		}
+
+		if _, exists := sp.indexes[stake.RewardIndex]; !exists {
+			return fmt.Errorf("position %s references missing reward index %s", id, stake.RewardIndex)
+		}
+
+		if stake.IsActive {
+			total += stake.Amount
+		}
+	}
+
+	if math.Abs(total-sp.TotalStaked) > 1e-6 {
+		return fmt.Errorf("total staked %f does not match active positions %f", sp.TotalStaked, total)
+	}
+
+	if sp.RewardReserve < 0 {
+		return fmt.Errorf("reward reserve is negative: %f", sp.RewardReserve)
+	}
+
+	if sp.treasuryBalance != nil && *sp.treasuryBalance < 0 {
+		return fmt.Errorf("treasury balance is negative: %f", *sp.treasuryBalance)
+	}
+
+	return nil
+}
+
+// persist saves the pool if it has a store. If saving fails, the pool is
+// rolled back to the last saved state so memory never runs ahead of storage;
+// callers must hold the lock.
+func (sp *StakingPool) persist() error {
+	if sp.store == nil {
+		return nil
+	}
+
+	treasury := sp.treasuryBalance
+	if sp.treasury != nil {
+		balance := sp.treasury.GetBalance()
+		treasury = &balance
+	}
+
+	data, err := json.Marshal(&stakingSnapshot{
+		Stakes:              sp.Stakes,
+		Tiers:               sp.Tiers,
+		Indexes:             sp.indexes,
+		TotalStaked:         sp.TotalStaked,
+		AnnualReward:        sp.AnnualReward,
+		MinStake:            sp.MinStake,
+		LockPeriod:          sp.LockPeriod,
+		EarlyUnstakePenalty: sp.EarlyUnstakePenalty,
+		TotalPenalties:      sp.TotalPenalties,
+		RewardReserve:       sp.RewardReserve,
+		TotalRewardsPaid:    sp.TotalRewardsPaid,
+		NextID:              sp.nextID,
+		ChainHeight:         sp.chainHeight,
+		ChainHash:           sp.chainHash,
+		Treasury:            treasury,
+	})
+	if err == nil {
+		err = sp.store.Put(stakingPoolKey, data)
+	}
+
+	if err != nil {
+		sp.rollback()
+		return err
+	}
+
+	sp.saved = data
+	return nil
+}
+
+// rollback restores the last saved state; callers must hold the lock
+func (sp *StakingPool) rollback() {
+	snapshot := &stakingSnapshot{}
+	if sp.saved == nil || json.Unmarshal(sp.saved, snapshot) != nil {
+		return
+	}
+	sp.restore(snapshot)
+}
+
+// restore loads a snapshot into the pool, and any attached treasury, and
+// rebuilds the address index
+func (sp *StakingPool) restore(snapshot *stakingSnapshot) {
+	if snapshot.Stakes != nil {
+		sp.Stakes = snapshot.Stakes
+	}
+	if snapshot.Tiers != nil {
+		sp.Tiers = snapshot.Tiers
+	}
+	if snapshot.Indexes != nil {
+		sp.indexes = snapshot.Indexes
+	}
+
+	sp.TotalStaked = snapshot.TotalStaked
+	sp.AnnualReward = snapshot.AnnualReward
+	sp.MinStake = snapshot.MinStake
+	sp.LockPeriod = snapshot.LockPeriod
+	sp.EarlyUnstakePenalty = snapshot.EarlyUnstakePenalty
+	sp.TotalPenalties = snapshot.TotalPenalties
+	sp.RewardReserve = snapshot.RewardReserve
+	sp.TotalRewardsPaid = snapshot.TotalRewardsPaid
+	sp.nextID = snapshot.NextID
+	sp.chainHeight = snapshot.ChainHeight
+	sp.chainHash = snapshot.ChainHash
+	sp.treasuryBalance = snapshot.Treasury
+	if sp.treasury != nil && snapshot.Treasury != nil {
+		sp.treasury.setBalance(*snapshot.Treasury)
+	}
+
+	sp.byAddress = make(map[string][]string)
+	for i := uint64(1); i <= sp.nextID; i++ {
+		id := positionID(i)
+		if stake, exists := sp.Stakes[id]; exists {
+			sp.byAddress[stake.Address] = append(sp.byAddress[stake.Address], id)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package goldcoin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+func TestStakingPoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	db, _ := storage.NewLevelDB(dir)
+	sp, err := NewStakingPoolWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create persistent staking pool: %v", err)
+	}
+
+	first, _ := sp.CreateTieredStake("address1", 1000.0, Tier90Days)
+	second, _ := sp.CreateStake("address1", 500.0)
+	sp.IncreaseStake(second.ID, 250.0)
+	sp.SetChainTip(42, "hash-42")
+
+	// Simulate a node restart
+	db, _ = storage.NewLevelDB(dir)
+	restored, err := NewStakingPoolWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to restore staking pool: %v", err)
+	}
+
+	if restored.TotalStaked != 1750.0 {
+		t.Errorf("Expected total staked 1750.0 after restart, got %f", restored.TotalStaked)
+	}
+
+	if len(restored.GetStakesByAddress("address1")) != 2 {
+		t.Errorf("Expected 2 positions for address1 after restart")
+	}
+
+	if rate, _ := restored.GetRewardRate(first.ID); rate != 8.0 {
+		t.Errorf("Expected restored tier rate 8.0, got %f", rate)
+	}
+
+	// New positions must not reuse restored IDs
+	third, _ := restored.CreateStake("address2", 100.0)
+	if third.ID == first.ID || third.ID == second.ID {
+		t.Errorf("Expected a fresh position ID, got %s", third.ID)
+	}
+
+	if err := restored.CheckConsistency(42, "hash-42"); err != nil {
+		t.Errorf("Expected consistent state at chain height 42: %v", err)
+	}
+
+	if err := restored.CheckConsistency(41, ""); err == nil {
+		t.Error("Expected error when staking state is ahead of the chain")
+	}
+}
+
+func TestStakingPoolRejectsCorruptState(t *testing.T) {
+	db := storage.NewMemDB()
+	sp, _ := NewStakingPoolWithStore(db)
+	sp.CreateStake("address1", 1000.0)
+
+	// Tamper with the saved total
+	snapshot := &stakingSnapshot{}
+	db.GetJSON(stakingPoolKey, snapshot)
+	snapshot.TotalStaked = 5000.0
+	db.PutJSON(stakingPoolKey, snapshot)
+
+	if _, err := NewStakingPoolWithStore(db); err == nil {
+		t.Error("Expected error restoring inconsistent staking state")
+	}
+}
+
+func TestStakingPoolRefusesNewerSchema(t *testing.T) {
+	db := storage.NewMemDB()
+	if _, err := NewStakingPoolWithStore(db); err != nil {
+		t.Fatalf("Failed to create persistent staking pool: %v", err)
+	}
+
+	if version, _ := StakingSchema.StoredVersion(db); version != StakingSchema.Version() {
+		t.Errorf("Expected new state stamped with version %d, got %d", StakingSchema.Version(), version)
+	}
+
+	db.PutJSON("schema_version:goldcoin_staking", StakingSchema.Version()+1)
+	if _, err := NewStakingPoolWithStore(db); !errors.Is(err, storage.ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestStakingPoolRollsBackFailedPersist(t *testing.T) {
+	db, _ := storage.NewLevelDB(t.TempDir())
+	sp, err := NewStakingPoolWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create persistent staking pool: %v", err)
+	}
+
+	sp.CreateStake("address1", 500.0)
+	db.Close()
+
+	if _, err := sp.CreateStake("address2", 1000.0); err == nil {
+		t.Fatal("Expected stake creation to fail when storage fails")
+	}
+
+	if sp.TotalStaked != 500.0 {
+		t.Errorf("Expected total staked 500.0 after the failed write, got %f", sp.TotalStaked)
+	}
+
+	if len(sp.GetStakesByAddress("address2")) != 0 {
+		t.Error("Expected the unsaved position to be rolled back")
+	}
+
+	if err := sp.CheckConsistency(0, ""); err != nil {
+		t.Errorf("Expected consistent state after rollback, got %v", err)
+	}
+}
+
+func TestTreasurySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	db, _ := storage.NewLevelDB(dir)
+	sp, _ := NewStakingPoolWithStore(db)
+	treasury := NewTreasury(100.0)
+	if err := sp.AttachTreasury(treasury); err != nil {
+		t.Fatalf("Failed to attach treasury: %v", err)
+	}
+
+	if err := sp.FundRewards(treasury, 40.0); err != nil {
+		t.Fatalf("Failed to fund rewards: %v", err)
+	}
+	treasury.Deposit(5.0)
+
+	// Simulate a node restart with the same initial allocation
+	db, _ = storage.NewLevelDB(dir)
+	restored, err := NewStakingPoolWithStore(db)
+	if err != nil {
+		t.Fatalf("Failed to restore staking pool: %v", err)
+	}
+
+	reopened := NewTreasury(100.0)
+	if err := restored.AttachTreasury(reopened); err != nil {
+		t.Fatalf("Failed to attach treasury: %v", err)
+	}
+
+	if balance := reopened.GetBalance(); balance != 65.0 {
+		t.Errorf("Expected treasury balance 65.0 after restart, got %f", balance)
+	}
+
+	if restored.RewardReserve != 40.0 {
+		t.Errorf("Expected reward reserve 40.0 after restart, got %f", restored.RewardReserve)
+	}
+}
+
+func TestAttachedTreasuryRollsBackFailedFunding(t *testing.T) {
+	db, _ := storage.NewLevelDB(t.TempDir())
+	sp, _ := NewStakingPoolWithStore(db)
+	treasury := NewTreasury(100.0)
+	sp.AttachTreasury(treasury)
+	db.Close()
+
+	if err := sp.FundRewards(treasury, 40.0); err == nil {
+		t.Fatal("Expected funding to fail when storage fails")
+	}
+
+	if balance := treasury.GetBalance(); balance != 100.0 {
+		t.Errorf("Expected treasury balance 100.0 after the failed write, got %f", balance)
+	}
+
+	if sp.RewardReserve != 0 {
+		t.Errorf("Expected reward reserve 0 after the failed write, got %f", sp.RewardReserve)
+	}
+
+	if err := NewStakingPool().FundRewards(treasury, 10.0); !errors.Is(err, ErrTreasuryAttached) {
+		t.Errorf("Expected ErrTreasuryAttached funding another pool, got %v", err)
+	}
+}
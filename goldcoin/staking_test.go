@@ -24,7 +24,7 @@ func TestNewStakingPool(t *testing.T) {
 func TestCreateStake(t *testing.T) {
 	sp := NewStakingPool()
 	
-	err := sp.CreateStake("address1", 500.0)
+	created, err := sp.CreateStake("address1", 500.0)
 	if err != nil {
 		t.Fatalf("Failed to create stake: %v", err)
 	}
@@ -33,7 +33,7 @@ func TestCreateStake(t *testing.T) {
 		t.Errorf("Expected total staked 500.0, got %f", sp.TotalStaked)
 	}
 	
-	stake, err := sp.GetStakeInfo("address1")
+	stake, err := sp.GetStakeInfo(created.ID)
 	if err != nil {
 		t.Fatalf("Failed to get stake info: %v", err)
 	}
@@ -50,32 +50,69 @@ func TestCreateStake(t *testing.T) {
 func TestCreateStakeBelowMinimum(t *testing.T) {
 	sp := NewStakingPool()
 	
-	err := sp.CreateStake("address1", 50.0)
+	_, err := sp.CreateStake("address1", 50.0)
 	if err == nil {
 		t.Error("Expected error for stake below minimum, got nil")
 	}
 }
 
-func TestCreateStakeDuplicate(t *testing.T) {
+func TestCreateMultipleStakesPerAddress(t *testing.T) {
 	sp := NewStakingPool()
 	
-	sp.CreateStake("address1", 500.0)
+	first, _ := sp.CreateStake("address1", 500.0)
 	
-	err := sp.CreateStake("address1", 500.0)
-	if err == nil {
-		t.Error("Expected error for duplicate stake, got nil")
+	second, err := sp.CreateStakeWithLock("address1", 300.0, 90*24*60*60, 8.0)
+	if err != nil {
+		t.Fatalf("Failed to create second stake: %v", err)
+	}
+	
+	if first.ID == second.ID {
+		t.Error("Expected distinct position IDs")
+	}
+	
+	rate, _ := sp.GetRewardRate(second.ID)
+	if second.LockPeriod != 90*24*60*60 || rate != 8.0 {
+		t.Errorf("Expected independent lock period and rate, got %d and %f", second.LockPeriod, rate)
+	}
+	
+	if len(sp.GetStakesByAddress("address1")) != 2 {
+		t.Errorf("Expected 2 positions for address1, got %d", len(sp.GetStakesByAddress("address1")))
+	}
+	
+	if sp.GetAddressStaked("address1") != 800.0 {
+		t.Errorf("Expected 800.0 staked by address1, got %f", sp.GetAddressStaked("address1"))
+	}
+}
+
+func TestUnstakePerPosition(t *testing.T) {
+	sp := NewStakingPool()
+	
+	locked, _ := sp.CreateStake("address1", 500.0)
+	flexible, _ := sp.CreateStakeWithLock("address1", 300.0, 0, 5.0)
+	
+	amount, _, err := sp.Unstake(flexible.ID)
+	if err != nil {
+		t.Fatalf("Failed to unstake flexible position: %v", err)
+	}
+	
+	if amount != 300.0 {
+		t.Errorf("Expected 300.0 unstaked, got %f", amount)
+	}
+	
+	if !locked.IsActive || sp.TotalStaked != 500.0 {
+		t.Error("Expected the other position to remain staked")
 	}
 }
 
 func TestCalculateRewards(t *testing.T) {
 	sp := NewStakingPool()
 	
-	sp.CreateStake("address1", 1000.0)
+	stake, _ := sp.CreateStake("address1", 1000.0)
 	
 	// Wait a moment to simulate time passage
 	time.Sleep(10 * time.Millisecond)
 	
-	rewards, err := sp.CalculateRewards("address1")
+	rewards, err := sp.CalculateRewards(stake.ID)
 	if err != nil {
 		t.Fatalf("Failed to calculate rewards: %v", err)
 	}
@@ -89,12 +126,13 @@ func TestCalculateRewards(t *testing.T) {
 func TestClaimRewards(t *testing.T) {
 	sp := NewStakingPool()
 	
-	sp.CreateStake("address1", 1000.0)
+	sp.FundRewards(NewTreasury(100.0), 100.0)
+	stake, _ := sp.CreateStake("address1", 1000.0)
 	
 	// Sleep long enough to accumulate measurable rewards
 	time.Sleep(100 * time.Millisecond)
 	
-	rewards, err := sp.ClaimRewards("address1")
+	rewards, err := sp.ClaimRewards(stake.ID)
 	if err != nil {
 		t.Fatalf("Failed to claim rewards: %v", err)
 	}
@@ -108,20 +146,131 @@ func TestUnstakeBeforeLockPeriod(t *testing.T) {
 	sp := NewStakingPool()
 	sp.LockPeriod = 3600 // 1 hour for testing
 	
-	sp.CreateStake("address1", 1000.0)
-	
-	_, _, err := sp.Unstake("address1")
-	if err == nil {
-		t.Error("Expected error when unstaking before lock period, got nil")
+	stake, _ := sp.CreateStake("address1", 1000.0)
+
+	amount, _, err := sp.Unstake(stake.ID)
+	if err != nil {
+		t.Fatalf("Expected early unstake to succeed with a penalty: %v", err)
+	}
+
+	// Untiered positions forfeit the pool's 10% early-unstake penalty
+	if amount != 900.0 {
+		t.Errorf("Expected 900.0 returned after penalty, got %f", amount)
+	}
+
+	if sp.TotalPenalties != 100.0 {
+		t.Errorf("Expected 100.0 in penalties, got %f", sp.TotalPenalties)
+	}
+}
+
+func TestTieredStake(t *testing.T) {
+	sp := NewStakingPool()
+
+	stake, err := sp.CreateTieredStake("address1", 1000.0, Tier90Days)
+	if err != nil {
+		t.Fatalf("Failed to create tiered stake: %v", err)
+	}
+
+	rate, _ := sp.GetRewardRate(stake.ID)
+	if rate != 8.0 || stake.LockPeriod != 90*24*60*60 {
+		t.Errorf("Expected 90d tier terms, got rate %f and lock %d", rate, stake.LockPeriod)
+	}
+
+	penalty, _ := sp.GetEarlyUnstakePenalty(stake.ID)
+	if penalty != 150.0 {
+		t.Errorf("Expected 150.0 early-unstake penalty, got %f", penalty)
+	}
+
+	if _, err := sp.CreateTieredStake("address1", 1000.0, "7d"); err == nil {
+		t.Error("Expected error for unknown tier")
+	}
+
+	tiers := sp.GetTiers()
+	if len(tiers) != 4 || tiers[0].Name != TierFlexible {
+		t.Errorf("Expected 4 tiers starting with flexible, got %d", len(tiers))
+	}
+}
+
+func TestTierAPYChangeIsProspective(t *testing.T) {
+	sp := NewStakingPool()
+
+	stake, _ := sp.CreateTieredStake("address1", 1000.0, TierFlexible)
+
+	time.Sleep(50 * time.Millisecond)
+
+	before, _ := sp.CalculateRewards(stake.ID)
+
+	if err := sp.SetTierAPY(TierFlexible, 0); err != nil {
+		t.Fatalf("Failed to set tier APY: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	after, _ := sp.CalculateRewards(stake.ID)
+
+	// Rewards earned before the change are kept; none accrue at 0% APY
+	if after < before || after > before*1.5 {
+		t.Errorf("Expected rewards to stay near %e after APY drop, got %e", before, after)
+	}
+
+	if rate, _ := sp.GetRewardRate(stake.ID); rate != 0 {
+		t.Errorf("Expected position rate updated to 0, got %f", rate)
+	}
+}
+
+func TestIncreaseStakeIsNotRetroactive(t *testing.T) {
+	sp := NewStakingPool()
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+
+	index := sp.indexes[stake.RewardIndex]
+	start := index.LastUpdate
+
+	// Simulate one year at 1000 GLD, then doubling the stake
+	index.LastUpdate = start - int64(365.25*24*60*60)*int64(time.Second)
+	if err := sp.IncreaseStake(stake.ID, 1000.0); err != nil {
+		t.Fatalf("Failed to increase stake: %v", err)
+	}
+
+	// One year at 10% on 1000 GLD is 100 GLD, unaffected by the increase
+	if stake.AccruedRewards < 99.99 || stake.AccruedRewards > 100.01 {
+		t.Errorf("Expected ~100.0 accrued before the increase, got %f", stake.AccruedRewards)
+	}
+
+	rewards, _ := sp.CalculateRewards(stake.ID)
+	if rewards < 99.99 || rewards > 100.01 {
+		t.Errorf("Expected ~100.0 claimable right after the increase, got %f", rewards)
+	}
+}
+
+func TestPositionsShareTierIndex(t *testing.T) {
+	sp := NewStakingPool()
+
+	first, _ := sp.CreateTieredStake("address1", 1000.0, Tier30Days)
+	second, _ := sp.CreateTieredStake("address2", 2000.0, Tier30Days)
+
+	if first.RewardIndex != second.RewardIndex {
+		t.Fatal("Expected positions in the same tier to share a reward index")
+	}
+
+	index := sp.indexes[first.RewardIndex]
+	index.LastUpdate -= int64(365.25*24*60*60) * int64(time.Second)
+
+	r1, _ := sp.CalculateRewards(first.ID)
+	r2, _ := sp.CalculateRewards(second.ID)
+
+	// Both accrue at the tier's 5% in proportion to their amount
+	if r2 < 2*r1*0.999 || r2 > 2*r1*1.001 {
+		t.Errorf("Expected rewards proportional to amount, got %f and %f", r1, r2)
 	}
 }
 
 func TestIncreaseStake(t *testing.T) {
 	sp := NewStakingPool()
 	
-	sp.CreateStake("address1", 500.0)
+	created, _ := sp.CreateStake("address1", 500.0)
 	
-	err := sp.IncreaseStake("address1", 300.0)
+	err := sp.IncreaseStake(created.ID, 300.0)
 	if err != nil {
 		t.Fatalf("Failed to increase stake: %v", err)
 	}
@@ -130,7 +279,7 @@ func TestIncreaseStake(t *testing.T) {
 		t.Errorf("Expected total staked 800.0, got %f", sp.TotalStaked)
 	}
 	
-	stake, _ := sp.GetStakeInfo("address1")
+	stake, _ := sp.GetStakeInfo(created.ID)
 	if stake.Amount != 800.0 {
 		t.Errorf("Expected stake amount 800.0, got %f", stake.Amount)
 	}
@@ -152,3 +301,102 @@ func TestGetPoolInfo(t *testing.T) {
 		t.Errorf("Expected 2 active stakes in pool info, got %v", info["activeStakes"])
 	}
 }
+
+func TestCompoundRewards(t *testing.T) {
+	sp := NewStakingPool()
+	sp.FundRewards(NewTreasury(1000.0), 1000.0)
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+	sp.indexes[stake.RewardIndex].LastUpdate -= int64(365.25*24*60*60) * int64(time.Second)
+
+	amount, err := sp.CompoundRewards(stake.ID)
+	if err != nil {
+		t.Fatalf("Failed to compound: %v", err)
+	}
+
+	if amount < 99.99 || amount > 100.01 {
+		t.Errorf("Expected ~100.0 compounded, got %f", amount)
+	}
+
+	if stake.Amount != 1000.0+amount || sp.TotalStaked != stake.Amount {
+		t.Errorf("Expected principal and pool total to include compounded rewards, got %f and %f", stake.Amount, sp.TotalStaked)
+	}
+
+	history, _ := sp.GetCompoundHistory(stake.ID)
+	if len(history) != 1 || history[0].NewPrincipal != stake.Amount {
+		t.Errorf("Expected one compounding event, got %d", len(history))
+	}
+
+	if rewards, _ := sp.CalculateRewards(stake.ID); rewards > 0.01 {
+		t.Errorf("Expected no claimable rewards after compounding, got %f", rewards)
+	}
+}
+
+func TestProcessAutoCompoundRespectsInterval(t *testing.T) {
+	sp := NewStakingPool()
+	sp.FundRewards(NewTreasury(1000.0), 1000.0)
+
+	optedIn, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+	optedOut, _ := sp.CreateStakeWithLock("address2", 1000.0, 0, 10.0)
+
+	if err := sp.SetAutoCompound(optedIn.ID, true, 3600); err != nil {
+		t.Fatalf("Failed to enable auto-compound: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if n, _ := sp.ProcessAutoCompound(); n != 0 {
+		t.Errorf("Expected no compounding before the interval, got %d", n)
+	}
+
+	optedIn.LastCompoundTime -= 3600 * int64(time.Second)
+
+	if n, _ := sp.ProcessAutoCompound(); n != 1 {
+		t.Errorf("Expected 1 position compounded, got %d", n)
+	}
+
+	if len(optedOut.CompoundHistory) != 0 {
+		t.Error("Expected positions without auto-compound to be left alone")
+	}
+}
+
+func TestGetStakeInfoReturnsCopy(t *testing.T) {
+	sp := NewStakingPool()
+
+	created, _ := sp.CreateStake("address1", 500.0)
+
+	stake, _ := sp.GetStakeInfo(created.ID)
+	stake.Amount = 1.0
+	stake.CompoundHistory = append(stake.CompoundHistory, CompoundEvent{})
+
+	for _, s := range sp.GetStakesByAddress("address1") {
+		s.IsActive = false
+	}
+
+	again, _ := sp.GetStakeInfo(created.ID)
+	if again.Amount != 500.0 || !again.IsActive || len(again.CompoundHistory) != 0 {
+		t.Error("Expected changes to returned positions to leave the pool untouched")
+	}
+}
+
+func TestCompoundHistoryIsCapped(t *testing.T) {
+	sp := NewStakingPool()
+	sp.FundRewards(NewTreasury(1000.0), 1000.0)
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+	stake.CompoundHistory = make([]CompoundEvent, MaxCompoundHistory)
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := sp.CompoundRewards(stake.ID); err != nil {
+		t.Fatalf("Failed to compound: %v", err)
+	}
+
+	history, _ := sp.GetCompoundHistory(stake.ID)
+	if len(history) != MaxCompoundHistory {
+		t.Errorf("Expected %d events, got %d", MaxCompoundHistory, len(history))
+	}
+
+	if history[len(history)-1].Amount <= 0 {
+		t.Error("Expected the latest event to be kept")
+	}
+}
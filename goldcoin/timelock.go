@@ -0,0 +1,274 @@
+package goldcoin
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CreateTimeLockedTransaction creates a transaction that only becomes valid at
+// lockHeight and lockTime (either may be 0). Its expiry window starts at the
+// lock height. Its nonce comes from the sender's time-lock nonces, so it does
+// not hold back the sender's other transactions while it waits.
+func (gc *GoldCoin) CreateTimeLockedTransaction(from, to string, amount float64, lockHeight uint64, lockTime int64) (*Transaction, error) {
+	if lockHeight == 0 && lockTime == 0 {
+		return nil, errors.New("time-locked transaction requires a lock height or lock time")
+	}
+
+	if lockTime < 0 {
+		return nil, errors.New("invalid lock time")
+	}
+
+	if err := checkTransfer(from, to, amount); err != nil {
+		return nil, err
+	}
+
+	gc.mutex.Lock()
+	nonce := gc.lockNonces[from]
+	gc.lockNonces[from] = nonce + 1
+	gc.mutex.Unlock()
+
+	tx := gc.newTransaction(from, to, amount, nonce)
+
+	tx.LockHeight = lockHeight
+	tx.LockTime = lockTime
+
+	switch {
+	case lockTime != 0:
+		// Block heights cannot be predicted from a wall-clock lock
+		tx.ExpiryHeight = 0
+	case gc.TxExpiryBlocks > 0 && lockHeight+gc.TxExpiryBlocks > tx.ExpiryHeight:
+		tx.ExpiryHeight = lockHeight + gc.TxExpiryBlocks
+	}
+
+	tx.ID = tx.generateID()
+	return tx, nil
+}
+
+// IsTimeLocked checks if the transaction carries a lock height or lock time
+func (tx *Transaction) IsTimeLocked() bool {
+	return tx.LockHeight != 0 || tx.LockTime != 0
+}
+
+// IsEligible checks if the transaction's locks are satisfied at a height and unix time
+func (tx *Transaction) IsEligible(height uint64, now int64) bool {
+	return height >= tx.LockHeight && now >= tx.LockTime
+}
+
+// TimeLockPool holds valid time-locked transactions until they become eligible
+type TimeLockPool struct {
+	pending map[string]*Transaction
+	mutex   sync.RWMutex
+}
+
+// NewTimeLockPool creates a new time-lock pool
+func NewTimeLockPool() *TimeLockPool {
+	return &TimeLockPool{
+		pending: make(map[string]*Transaction),
+	}
+}
+
+// Add validates a time-locked transaction and holds it until eligible
+func (p *TimeLockPool) Add(gc *GoldCoin, tx *Transaction) error {
+	if err := gc.ValidateTransaction(tx); err != nil && err != ErrTxLocked {
+		return err
+	}
+
+	if !tx.IsTimeLocked() {
+		return errors.New("transaction is not time-locked")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.pending[tx.ID]; exists {
+		return errors.New("transaction already pending")
+	}
+
+	p.pending[tx.ID] = tx
+	return nil
+}
+
+// Remove drops a pending transaction
+func (p *TimeLockPool) Remove(txID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.pending[txID]; !exists {
+		return errors.New("transaction not found")
+	}
+
+	delete(p.pending, txID)
+	return nil
+}
+
+// Pending returns all held transactions ordered by sender and nonce
+func (p *TimeLockPool) Pending() []*Transaction {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	txs := make([]*Transaction, 0, len(p.pending))
+	for _, tx := range p.pending {
+		txs = append(txs, tx)
+	}
+	sortByNonce(txs)
+
+	return txs
+}
+
+// ReleaseEligible removes and returns the transactions whose locks are
+// satisfied at height and now, ordered by sender and nonce. Transactions
+// that expired while held are dropped instead.
+func (p *TimeLockPool) ReleaseEligible(height uint64, now int64) []*Transaction {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	released := make([]*Transaction, 0)
+	for id, tx := range p.pending {
+		if tx.ExpiryHeight != 0 && height > tx.ExpiryHeight {
+			delete(p.pending, id)
+			continue
+		}
+		if tx.IsEligible(height, now) {
+			released = append(released, tx)
+			delete(p.pending, id)
+		}
+	}
+	sortByNonce(released)
+
+	return released
+}
+
+// PruneExpired drops transactions that passed their expiry height while held
+func (p *TimeLockPool) PruneExpired(height uint64) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pruned := 0
+	for id, tx := range p.pending {
+		if tx.ExpiryHeight != 0 && height > tx.ExpiryHeight {
+			delete(p.pending, id)
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
+// Size returns the number of held transactions
+func (p *TimeLockPool) Size() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return len(p.pending)
+}
+
+// sortByNonce orders transactions by sender, then nonce
+func sortByNonce(txs []*Transaction) {
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].From != txs[j].From {
+			return txs[i].From < txs[j].From
+		}
+		return txs[i].Nonce < txs[j].Nonce
+	})
+}
+
+// VestingSchedule releases TotalAmount from Grantor to Beneficiary linearly
+// between StartTime and EndTime, with nothing vested before CliffTime
+type VestingSchedule struct {
+	ID          string
+	Grantor     string
+	Beneficiary string
+	TotalAmount float64
+	StartTime   int64
+	CliffTime   int64
+	EndTime     int64
+	Released    float64
+	mutex       sync.Mutex
+}
+
+// NewVestingSchedule creates a linear vesting schedule (times are unix seconds)
+func NewVestingSchedule(id, grantor, beneficiary string, total float64, start, cliff, end int64) (*VestingSchedule, error) {
+	if grantor == "" || beneficiary == "" {
+		return nil, errors.New("invalid addresses: grantor and beneficiary cannot be empty")
+	}
+
+	if total <= 0 {
+		return nil, errors.New("invalid amount: must be greater than 0")
+	}
+
+	if end <= start {
+		return nil, errors.New("invalid schedule: end must be after start")
+	}
+
+	if cliff < start || cliff > end {
+		return nil, errors.New("invalid schedule: cliff must be between start and end")
+	}
+
+	return &VestingSchedule{
+		ID:          id,
+		Grantor:     grantor,
+		Beneficiary: beneficiary,
+		TotalAmount: total,
+		StartTime:   start,
+		CliffTime:   cliff,
+		EndTime:     end,
+	}, nil
+}
+
+// VestedAmount returns the total amount vested at a unix time
+func (vs *VestingSchedule) VestedAmount(now int64) float64 {
+	switch {
+	case now < vs.CliffTime:
+		return 0
+	case now >= vs.EndTime:
+		return vs.TotalAmount
+	default:
+		elapsed := float64(now - vs.StartTime)
+		duration := float64(vs.EndTime - vs.StartTime)
+		return vs.TotalAmount * elapsed / duration
+	}
+}
+
+// Releasable returns the vested amount not yet released at a unix time
+func (vs *VestingSchedule) Releasable(now int64) float64 {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	return vs.VestedAmount(now) - vs.Released
+}
+
+// Release transfers the releasable amount to the beneficiary. The amount
+// counts as released only once the transfer has been applied; a failed
+// transfer gives its nonce back, so the release can be retried.
+func (vs *VestingSchedule) Release(gc *GoldCoin) (*Transaction, error) {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	amount := vs.VestedAmount(time.Now().Unix()) - vs.Released
+	if amount <= 0 {
+		return nil, errors.New("nothing to release")
+	}
+
+	tx, err := gc.CreateTransaction(vs.Grantor, vs.Beneficiary, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gc.ApplyTransaction(tx); err != nil {
+		gc.ReleaseNonce(tx)
+		return nil, err
+	}
+
+	vs.Released += amount
+	return tx, nil
+}
+
+// IsComplete checks if the full amount has been released
+func (vs *VestingSchedule) IsComplete() bool {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	return vs.Released >= vs.TotalAmount
+}
@@ -0,0 +1,182 @@
+package goldcoin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeLockedTransactionHeld(t *testing.T) {
+	gc := NewGoldCoin()
+	pool := NewTimeLockPool()
+
+	tx, err := gc.CreateTimeLockedTransaction("from_addr", "to_addr", 100.0, 50, 0)
+	if err != nil {
+		t.Fatalf("Failed to create time-locked transaction: %v", err)
+	}
+
+	if tx.ExpiryHeight != 50+gc.TxExpiryBlocks {
+		t.Errorf("Expected expiry window to start at lock height, got %d", tx.ExpiryHeight)
+	}
+
+	if err := gc.ValidateTransaction(tx); err != ErrTxLocked {
+		t.Errorf("Expected ErrTxLocked, got %v", err)
+	}
+
+	if err := pool.Add(gc, tx); err != nil {
+		t.Fatalf("Failed to add to pool: %v", err)
+	}
+
+	if released := pool.ReleaseEligible(49, time.Now().Unix()); len(released) != 0 {
+		t.Errorf("Expected no release before lock height, got %d", len(released))
+	}
+
+	released := pool.ReleaseEligible(50, time.Now().Unix())
+	if len(released) != 1 || released[0].ID != tx.ID {
+		t.Fatalf("Expected transaction released at lock height")
+	}
+
+	if pool.Size() != 0 {
+		t.Errorf("Expected empty pool, got %d", pool.Size())
+	}
+
+	gc.SetBlockHeight(50)
+	if err := gc.ApplyTransaction(tx); err != nil {
+		t.Errorf("Failed to apply released transaction: %v", err)
+	}
+}
+
+func TestTimeLockPoolRejectsInvalid(t *testing.T) {
+	gc := NewGoldCoin()
+	pool := NewTimeLockPool()
+
+	tx, _ := gc.CreateTimeLockedTransaction("from_addr", "to_addr", 100.0, 0, time.Now().Unix()+3600)
+	tx.Amount = 1000.0
+
+	if err := pool.Add(gc, tx); err == nil {
+		t.Error("Expected error adding tampered transaction")
+	}
+
+	plain, _ := gc.CreateTransaction("from_addr", "to_addr", 100.0)
+	if err := pool.Add(gc, plain); err == nil {
+		t.Error("Expected error adding transaction without a lock")
+	}
+}
+
+func TestVestingScheduleLinearRelease(t *testing.T) {
+	now := time.Now().Unix()
+
+	vs, err := NewVestingSchedule("grant-1", "treasury", "employee", 1200.0, now-600, now-300, now+600)
+	if err != nil {
+		t.Fatalf("Failed to create vesting schedule: %v", err)
+	}
+
+	if vs.VestedAmount(now-400) != 0 {
+		t.Error("Expected nothing vested before the cliff")
+	}
+
+	if vs.VestedAmount(now) != 600.0 {
+		t.Errorf("Expected 600 vested halfway, got %f", vs.VestedAmount(now))
+	}
+
+	if vs.VestedAmount(now+700) != 1200.0 {
+		t.Errorf("Expected full amount vested after end, got %f", vs.VestedAmount(now+700))
+	}
+
+	gc := NewGoldCoin()
+	tx, err := vs.Release(gc)
+	if err != nil {
+		t.Fatalf("Failed to release: %v", err)
+	}
+
+	if tx.To != "employee" || tx.Amount < 600.0 {
+		t.Errorf("Expected release of at least 600 to employee, got %f to %s", tx.Amount, tx.To)
+	}
+
+	if vs.Releasable(now) > 0 {
+		t.Error("Expected nothing releasable after release")
+	}
+}
+
+func TestTimeLockedTransactionDoesNotBlockSender(t *testing.T) {
+	gc := NewGoldCoin()
+
+	locked, _ := gc.CreateTimeLockedTransaction("from_addr", "to_addr", 100.0, 50, 0)
+
+	tx, _ := gc.CreateTransaction("from_addr", "to_addr", 10.0)
+	if err := gc.ApplyTransaction(tx); err != nil {
+		t.Fatalf("Expected a send while a time-locked transaction is held, got %v", err)
+	}
+
+	gc.SetBlockHeight(50)
+	if err := gc.ApplyTransaction(locked); err != nil {
+		t.Fatalf("Failed to apply released transaction: %v", err)
+	}
+
+	if err := gc.ApplyTransaction(locked); err != ErrNonceTooLow {
+		t.Errorf("Expected a replayed time-locked transaction to be rejected, got %v", err)
+	}
+}
+
+func TestTimeLockPoolDropsExpired(t *testing.T) {
+	gc := NewGoldCoin()
+	pool := NewTimeLockPool()
+
+	tx, _ := gc.CreateTimeLockedTransaction("from_addr", "to_addr", 100.0, 10, 0)
+	pool.Add(gc, tx) //nolint:errcheck
+
+	if released := pool.ReleaseEligible(tx.ExpiryHeight+1, time.Now().Unix()); len(released) != 0 {
+		t.Errorf("Expected an expired transaction not to be released, got %d", len(released))
+	}
+
+	if pool.Size() != 0 {
+		t.Error("Expected the expired transaction to be dropped")
+	}
+}
+
+func TestVestingReleaseCountsOnlyApplied(t *testing.T) {
+	now := time.Now().Unix()
+	vs, _ := NewVestingSchedule("grant-1", "treasury", "employee", 1200.0, now-600, now-600, now+600)
+
+	// An unapplied transaction from the grantor leaves a nonce gap
+	gc := NewGoldCoin()
+	abandoned, _ := gc.CreateTransaction("treasury", "elsewhere", 1.0)
+
+	if _, err := vs.Release(gc); err == nil {
+		t.Fatal("Expected the release to fail")
+	}
+
+	if vs.Released != 0 {
+		t.Errorf("Expected a failed release not to count, got %f released", vs.Released)
+	}
+
+	// Once the gap is given back the release goes through
+	gc.ReleaseNonce(abandoned)
+	if _, err := vs.Release(gc); err != nil {
+		t.Fatalf("Expected the release to recover: %v", err)
+	}
+
+	if vs.Released <= 0 {
+		t.Error("Expected the recovered release to count")
+	}
+}
+
+func TestFailedVestingReleaseGivesBackNonce(t *testing.T) {
+	_, _, ms := newTestMultisig(t, 1, 2)
+	now := time.Now().Unix()
+	vs, _ := NewVestingSchedule("grant-1", ms.Address, "employee", 1200.0, now-600, now-600, now+600)
+
+	// A multisig grantor cannot release without signatures
+	gc := NewGoldCoin()
+	gc.RegisterMultisigAddress(ms) //nolint:errcheck
+
+	for i := 0; i < 2; i++ {
+		if _, err := vs.Release(gc); err != ErrMultisigRequired {
+			t.Fatalf("Expected ErrMultisigRequired, got %v", err)
+		}
+	}
+
+	tx, _ := gc.CreateTransaction(ms.Address, "employee", 1.0)
+	if tx.Nonce != 0 {
+		t.Errorf("Expected failed releases to give back their nonces, got nonce %d", tx.Nonce)
+	}
+}
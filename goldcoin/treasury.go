@@ -0,0 +1,237 @@
+package goldcoin
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// UnboundedRunway is the reward runway reported when nothing accrues
+const UnboundedRunway = -1.0
+
+var (
+	// ErrInsufficientReserve is returned when the reward reserve cannot cover a payout
+	ErrInsufficientReserve = errors.New("insufficient reward reserve")
+	// ErrInsufficientTreasury is returned when the treasury cannot cover a transfer
+	ErrInsufficientTreasury = errors.New("insufficient treasury balance")
+	// ErrTreasuryAttached is returned for a treasury saved with another pool
+	ErrTreasuryAttached = errors.New("treasury is attached to another pool")
+)
+
+// Treasury is the account staking reward reserves are funded from
+type Treasury struct {
+	balance float64
+	pool    *StakingPool // saves the balance with its state, once attached
+	mutex   sync.Mutex
+}
+
+// NewTreasury creates a treasury holding an initial allocation
+func NewTreasury(balance float64) *Treasury {
+	return &Treasury{balance: balance}
+}
+
+// Deposit credits the treasury
+func (t *Treasury) Deposit(amount float64) error {
+	if err := t.credit(amount); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+// Withdraw debits the treasury
+func (t *Treasury) Withdraw(amount float64) error {
+	if err := t.debit(amount); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+// credit adds to the balance without saving it
+func (t *Treasury) credit(amount float64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if amount <= 0 {
+		return errors.New("invalid amount")
+	}
+
+	t.balance += amount
+	return nil
+}
+
+// debit takes from the balance without saving it
+func (t *Treasury) debit(amount float64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if amount <= 0 {
+		return errors.New("invalid amount")
+	}
+
+	if amount > t.balance {
+		return ErrInsufficientTreasury
+	}
+
+	t.balance -= amount
+	return nil
+}
+
+// save stores the balance with the pool it is attached to, if any. A failed
+// save rolls the pool back, which restores the last saved balance.
+func (t *Treasury) save() error {
+	pool := t.owner()
+	if pool == nil {
+		return nil
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	return pool.persist()
+}
+
+// owner returns the pool the treasury is attached to
+func (t *Treasury) owner() *StakingPool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.pool
+}
+
+// setBalance replaces the balance with a saved one
+func (t *Treasury) setBalance(balance float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.balance = balance
+}
+
+// GetBalance returns the treasury balance
+func (t *Treasury) GetBalance() float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.balance
+}
+
+// FundRewards moves treasury funds into the reward reserve. The treasury is
+// only debited if the reserve is credited, and a treasury attached to the
+// pool is saved in the same write as the reserve.
+func (sp *StakingPool) FundRewards(treasury *Treasury, amount float64) error {
+	if treasury == nil {
+		return errors.New("treasury is nil")
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	owner := treasury.owner()
+	if owner != nil && owner != sp {
+		return ErrTreasuryAttached
+	}
+
+	if err := treasury.debit(amount); err != nil {
+		return err
+	}
+
+	if err := sp.addReserve(amount); err != nil {
+		if owner == nil {
+			treasury.credit(amount) //nolint:errcheck // returns the amount debited above
+		}
+		// An attached treasury was restored by the pool's rollback
+		return err
+	}
+
+	return nil
+}
+
+// FundRewardsFromMint mints new coins into the reward reserve. Minting is
+// bounded by the coin's MaxSupply, and undone if the reserve cannot be credited.
+func (sp *StakingPool) FundRewardsFromMint(gc *GoldCoin, amount uint64) error {
+	if amount == 0 {
+		return errors.New("invalid amount")
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if err := gc.Mint(amount); err != nil {
+		return err
+	}
+
+	if err := sp.addReserve(float64(amount)); err != nil {
+		gc.unmint(amount)
+		return err
+	}
+
+	return nil
+}
+
+// addReserve credits the reward reserve and saves the pool; callers must hold the write lock
+func (sp *StakingPool) addReserve(amount float64) error {
+	sp.RewardReserve += amount
+	return sp.persist()
+}
+
+// payReward moves a payout from the reserve to a position; callers must hold
+// the write lock and have checked the reserve
+func (sp *StakingPool) payReward(stake *Stake, amount float64) {
+	stake.RewardsClaimed += amount
+	sp.RewardReserve -= amount
+	sp.TotalRewardsPaid += amount
+}
+
+// GetRewardEmissionRate returns the rewards currently accruing per day across active positions
+func (sp *StakingPool) GetRewardEmissionRate() float64 {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	return sp.dailyEmission()
+}
+
+// GetRewardRunway returns the days the reserve can pay current emissions,
+// net of rewards already accrued and owed. It is UnboundedRunway when
+// nothing accrues.
+func (sp *StakingPool) GetRewardRunway() float64 {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	return sp.runwayDays()
+}
+
+// dailyEmission sums the daily reward accrual of active positions; callers must hold the lock
+func (sp *StakingPool) dailyEmission() float64 {
+	daily := 0.0
+	for _, stake := range sp.Stakes {
+		if stake.IsActive {
+			daily += stake.Amount * sp.indexes[stake.RewardIndex].Rate / 100.0 / 365.25
+		}
+	}
+	return daily
+}
+
+// runwayDays computes the reserve runway; callers must hold the lock
+func (sp *StakingPool) runwayDays() float64 {
+	now := time.Now().UnixNano()
+
+	owed := 0.0
+	for _, stake := range sp.Stakes {
+		if stake.IsActive {
+			owed += sp.accruedRewards(stake, now) - stake.RewardsClaimed
+		} else {
+			owed += stake.AccruedRewards - stake.RewardsClaimed
+		}
+	}
+
+	available := sp.RewardReserve - owed
+	if available < 0 {
+		return 0
+	}
+
+	daily := sp.dailyEmission()
+	if daily == 0 {
+		return UnboundedRunway
+	}
+
+	return available / daily
+}
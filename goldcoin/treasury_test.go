@@ -0,0 +1,174 @@
+package goldcoin
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/storage"
+)
+
+// ageIndex moves a position's reward index back by the given number of days
+func ageIndex(sp *StakingPool, stake *Stake, days float64) {
+	sp.indexes[stake.RewardIndex].LastUpdate -= int64(days * 24 * 60 * 60 * float64(time.Second))
+}
+
+func TestClaimRewardsRequiresReserve(t *testing.T) {
+	sp := NewStakingPool()
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+	ageIndex(sp, stake, 365.25)
+
+	if _, err := sp.ClaimRewards(stake.ID); err != ErrInsufficientReserve {
+		t.Fatalf("Expected ErrInsufficientReserve, got %v", err)
+	}
+
+	if err := sp.FundRewards(NewTreasury(500.0), 500.0); err != nil {
+		t.Fatalf("Failed to fund rewards: %v", err)
+	}
+
+	rewards, err := sp.ClaimRewards(stake.ID)
+	if err != nil {
+		t.Fatalf("Failed to claim rewards: %v", err)
+	}
+
+	if math.Abs(sp.RewardReserve-(500.0-rewards)) > 1e-9 || sp.TotalRewardsPaid != rewards {
+		t.Errorf("Expected reserve to drop by %f, got reserve %f paid %f", rewards, sp.RewardReserve, sp.TotalRewardsPaid)
+	}
+}
+
+func TestUnstakePaysRewardsUpToReserve(t *testing.T) {
+	sp := NewStakingPool()
+	sp.FundRewards(NewTreasury(40.0), 40.0)
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 0, 10.0)
+	ageIndex(sp, stake, 365.25)
+
+	amount, rewards, err := sp.Unstake(stake.ID)
+	if err != nil {
+		t.Fatalf("Failed to unstake: %v", err)
+	}
+
+	if amount != 1000.0 || rewards != 40.0 {
+		t.Errorf("Expected 1000.0 principal and 40.0 of rewards, got %f and %f", amount, rewards)
+	}
+
+	if sp.RewardReserve != 0 {
+		t.Errorf("Expected reserve to be drained, got %f", sp.RewardReserve)
+	}
+
+	owed, _ := sp.CalculateRewards(stake.ID)
+	if owed < 59.99 || owed > 60.01 {
+		t.Errorf("Expected ~60.0 still owed, got %f", owed)
+	}
+
+	sp.FundRewards(NewTreasury(100.0), 100.0)
+
+	if paid, err := sp.ClaimRewards(stake.ID); err != nil || paid != owed {
+		t.Errorf("Expected owed rewards to be claimable after refunding, got %f (%v)", paid, err)
+	}
+}
+
+func TestFundRewardsFromMint(t *testing.T) {
+	gc := NewGoldCoin()
+	sp := NewStakingPool()
+
+	if err := sp.FundRewardsFromMint(gc, 1000); err != nil {
+		t.Fatalf("Failed to fund from mint: %v", err)
+	}
+
+	if gc.CircSupply != 1000 || sp.RewardReserve != 1000.0 {
+		t.Errorf("Expected 1000 minted into the reserve, got supply %d reserve %f", gc.CircSupply, sp.RewardReserve)
+	}
+
+	if err := sp.FundRewardsFromMint(gc, gc.MaxSupply); err == nil {
+		t.Error("Expected minting past max supply to fail")
+	}
+
+	if sp.RewardReserve != 1000.0 {
+		t.Errorf("Expected failed mint to leave reserve unchanged, got %f", sp.RewardReserve)
+	}
+}
+
+func TestRewardRunway(t *testing.T) {
+	sp := NewStakingPool()
+
+	if runway := sp.GetRewardRunway(); runway != UnboundedRunway {
+		t.Errorf("Expected unbounded runway with no positions, got %f", runway)
+	}
+
+	if _, err := json.Marshal(sp.GetPoolInfo()); err != nil {
+		t.Errorf("Expected pool info to encode with an unbounded runway: %v", err)
+	}
+
+	sp.CreateStakeWithLock("address1", 36525.0, 0, 10.0)
+	sp.FundRewards(NewTreasury(100.0), 100.0)
+
+	if rate := sp.GetRewardEmissionRate(); math.Abs(rate-10.0) > 1e-9 {
+		t.Errorf("Expected 10.0 emitted per day, got %f", rate)
+	}
+
+	if runway := sp.GetRewardRunway(); runway < 9.99 || runway > 10.0 {
+		t.Errorf("Expected ~10 days of runway, got %f", runway)
+	}
+}
+
+func TestFundRewardsDebitsTreasury(t *testing.T) {
+	sp := NewStakingPool()
+	treasury := NewTreasury(100.0)
+
+	if err := sp.FundRewards(treasury, 60.0); err != nil {
+		t.Fatalf("Failed to fund rewards: %v", err)
+	}
+
+	if treasury.GetBalance() != 40.0 || sp.RewardReserve != 60.0 {
+		t.Errorf("Expected 60.0 moved to the reserve, got treasury %f reserve %f", treasury.GetBalance(), sp.RewardReserve)
+	}
+
+	if err := sp.FundRewards(treasury, 50.0); err != ErrInsufficientTreasury {
+		t.Errorf("Expected ErrInsufficientTreasury, got %v", err)
+	}
+
+	if treasury.GetBalance() != 40.0 || sp.RewardReserve != 60.0 {
+		t.Errorf("Expected a failed transfer to change nothing, got treasury %f reserve %f", treasury.GetBalance(), sp.RewardReserve)
+	}
+}
+
+func TestFailedFundingUndoesTransfer(t *testing.T) {
+	db, _ := storage.NewLevelDB(t.TempDir())
+	sp, _ := NewStakingPoolWithStore(db)
+	gc := NewGoldCoin()
+	treasury := NewTreasury(100.0)
+	db.Close()
+
+	if err := sp.FundRewards(treasury, 50.0); err == nil {
+		t.Fatal("Expected funding to fail when storage fails")
+	}
+
+	if treasury.GetBalance() != 100.0 {
+		t.Errorf("Expected the treasury to be refunded, got %f", treasury.GetBalance())
+	}
+
+	if err := sp.FundRewardsFromMint(gc, 1000); err == nil {
+		t.Fatal("Expected funding from mint to fail when storage fails")
+	}
+
+	if gc.CircSupply != 0 || sp.RewardReserve != 0 {
+		t.Errorf("Expected the mint to be undone, got supply %d reserve %f", gc.CircSupply, sp.RewardReserve)
+	}
+}
+
+func TestEarlyUnstakePenaltyReturnsToReserve(t *testing.T) {
+	sp := NewStakingPool()
+
+	stake, _ := sp.CreateStakeWithLock("address1", 1000.0, 3600, 10.0)
+	amount, _, err := sp.Unstake(stake.ID)
+	if err != nil {
+		t.Fatalf("Failed to unstake: %v", err)
+	}
+
+	if penalty := 1000.0 - amount; penalty <= 0 || math.Abs(sp.RewardReserve-penalty) > 1e-9 {
+		t.Errorf("Expected the %f penalty in the reserve, got %f", penalty, sp.RewardReserve)
+	}
+}
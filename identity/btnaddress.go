@@ -1,66 +1,135 @@
 package identity
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"sync"
+	"time"
 )
 
-// Address represents a blockchain address
+// ErrAddressLocked is returned when signing with an address whose key is not unlocked
+var ErrAddressLocked = errors.New("address is locked")
+
+// Address represents a blockchain address. It never carries the private key.
 type Address struct {
-	PublicKey  string
-	PrivateKey string
-	Address    string
-	Label      string
-	CreatedAt  int64
+	PublicKey string
+	Address   string
+	Label     string
+	CreatedAt int64
+}
+
+// unlockedKey is a decrypted private key held in memory
+type unlockedKey struct {
+	privateKey []byte
+	timer      *time.Timer
 }
 
 // AddressManager manages blockchain addresses
 type AddressManager struct {
 	Addresses map[string]*Address
+	keystore  *KeyStore
+	unlocked  map[string]*unlockedKey
 	mutex     sync.RWMutex
 }
 
-// NewAddressManager creates a new address manager
+// NewAddressManager creates a new in-memory address manager
 func NewAddressManager() *AddressManager {
 	return &AddressManager{
 		Addresses: make(map[string]*Address),
+		unlocked:  make(map[string]*unlockedKey),
 	}
 }
 
-// GenerateAddress generates a new blockchain address
+// NewAddressManagerWithKeyStore creates an address manager backed by an encrypted keystore
+func NewAddressManagerWithKeyStore(ks *KeyStore) (*AddressManager, error) {
+	if ks == nil {
+		return nil, errors.New("keystore is nil")
+	}
+
+	am := NewAddressManager()
+	am.keystore = ks
+
+	addresses, err := ks.Addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addresses {
+		am.Addresses[addr.Address] = addr
+	}
+
+	return am, nil
+}
+
+// GenerateAddress generates a new blockchain address whose key is kept
+// unlocked in memory only. Use GenerateEncryptedAddress to persist the key.
 func (am *AddressManager) GenerateAddress(label string) (*Address, error) {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
 
-	// Generate random private key
-	privateKey := make([]byte, 32)
-	_, err := rand.Read(privateKey)
+	privateKey, addr, err := newKey(label)
 	if err != nil {
 		return nil, err
 	}
 
-	// Generate public key from private key (simplified)
-	publicKeyHash := sha256.Sum256(privateKey)
-	publicKey := hex.EncodeToString(publicKeyHash[:])
+	am.Addresses[addr.Address] = addr
+	am.unlocked[addr.Address] = &unlockedKey{privateKey: privateKey}
+	return copyAddress(addr), nil
+}
 
-	// Generate address from public key
-	addressHash := sha256.Sum256([]byte(publicKey))
-	address := "GLD" + hex.EncodeToString(addressHash[:20])
+// GenerateEncryptedAddress generates a new address and stores its key in the
+// keystore encrypted with the passphrase. The new address starts locked.
+func (am *AddressManager) GenerateEncryptedAddress(label, passphrase string) (*Address, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if am.keystore == nil {
+		return nil, errors.New("no keystore configured")
+	}
+
+	privateKey, addr, err := newKey(label)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(privateKey)
+
+	if err := am.keystore.StoreKey(addr, privateKey, passphrase); err != nil {
+		return nil, err
+	}
+
+	am.Addresses[addr.Address] = addr
+	return copyAddress(addr), nil
+}
+
+// newKey generates a random ed25519 seed and derives its address
+func newKey(label string) ([]byte, *Address, error) {
+	// Generate random private key seed
+	privateKey := make([]byte, ed25519.SeedSize)
+	_, err := rand.Read(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Derive the ed25519 public key from the seed
+	publicKey := hex.EncodeToString(ed25519.NewKeyFromSeed(privateKey).Public().(ed25519.PublicKey))
 
 	addr := &Address{
-		PublicKey:  publicKey,
-		PrivateKey: hex.EncodeToString(privateKey),
-		Address:    address,
-		Label:      label,
-		CreatedAt:  0,
+		PublicKey: publicKey,
+		Address:   PublicKeyToAddress(publicKey),
+		Label:     label,
+		CreatedAt: time.Now().Unix(),
 	}
 
-	am.Addresses[address] = addr
-	return addr, nil
+	return privateKey, addr, nil
+}
+
+// copyAddress returns a copy so callers cannot mutate managed records
+func copyAddress(addr *Address) *Address {
+	c := *addr
+	return &c
 }
 
 // GetAddress retrieves an address by its address string
@@ -73,7 +142,81 @@ func (am *AddressManager) GetAddress(address string) (*Address, error) {
 		return nil, errors.New("address not found")
 	}
 
-	return addr, nil
+	return copyAddress(addr), nil
+}
+
+// Unlock decrypts the key of an address from the keystore. A zero timeout
+// keeps the key unlocked until Lock is called.
+func (am *AddressManager) Unlock(address, passphrase string, timeout time.Duration) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if am.keystore == nil {
+		return errors.New("no keystore configured")
+	}
+
+	if _, exists := am.Addresses[address]; !exists {
+		return errors.New("address not found")
+	}
+
+	privateKey, _, err := am.keystore.GetKey(address, passphrase)
+	if err != nil {
+		return err
+	}
+
+	am.lockLocked(address)
+
+	key := &unlockedKey{privateKey: privateKey}
+	if timeout > 0 {
+		key.timer = time.AfterFunc(timeout, func() {
+			am.mutex.Lock()
+			defer am.mutex.Unlock()
+
+			// Only relock if this unlock has not been replaced since
+			if am.unlocked[address] == key {
+				am.lockLocked(address)
+			}
+		})
+	}
+
+	am.unlocked[address] = key
+	return nil
+}
+
+// Lock removes the decrypted key of an address from memory
+func (am *AddressManager) Lock(address string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if _, exists := am.Addresses[address]; !exists {
+		return errors.New("address not found")
+	}
+
+	am.lockLocked(address)
+	return nil
+}
+
+// IsUnlocked checks if the key of an address is available for signing
+func (am *AddressManager) IsUnlocked(address string) bool {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	_, unlocked := am.unlocked[address]
+	return unlocked
+}
+
+// lockLocked zeroes and drops an unlocked key; callers must hold the write lock
+func (am *AddressManager) lockLocked(address string) {
+	key, exists := am.unlocked[address]
+	if !exists {
+		return
+	}
+
+	if key.timer != nil {
+		key.timer.Stop()
+	}
+	zeroBytes(key.privateKey)
+	delete(am.unlocked, address)
 }
 
 // ValidateAddress validates an address format
@@ -96,13 +239,14 @@ func (am *AddressManager) ListAddresses() []*Address {
 
 	addresses := make([]*Address, 0, len(am.Addresses))
 	for _, addr := range am.Addresses {
-		addresses = append(addresses, addr)
+		addresses = append(addresses, copyAddress(addr))
 	}
 
 	return addresses
 }
 
-// DeleteAddress removes an address
+// DeleteAddress removes an address from the manager and locks its key.
+// Key files are left on disk; use KeyStore.Delete to remove them.
 func (am *AddressManager) DeleteAddress(address string) error {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
@@ -111,6 +255,7 @@ func (am *AddressManager) DeleteAddress(address string) error {
 		return errors.New("address not found")
 	}
 
+	am.lockLocked(address)
 	delete(am.Addresses, address)
 	return nil
 }
@@ -120,21 +265,42 @@ func (am *AddressManager) SignMessage(address, message string) (string, error) {
 	am.mutex.RLock()
 	defer am.mutex.RUnlock()
 
-	addr, exists := am.Addresses[address]
-	if !exists {
+	if _, exists := am.Addresses[address]; !exists {
 		return "", errors.New("address not found")
 	}
 
-	// Simple signing (in production, use proper cryptographic signing)
-	data := fmt.Sprintf("%s:%s:%s", addr.PrivateKey, message, address)
-	hash := sha256.Sum256([]byte(data))
-	signature := hex.EncodeToString(hash[:])
+	key, unlocked := am.unlocked[address]
+	if !unlocked {
+		return "", ErrAddressLocked
+	}
+
+	signature := ed25519.Sign(ed25519.NewKeyFromSeed(key.privateKey), []byte(message))
+	return hex.EncodeToString(signature), nil
+}
+
+// PublicKeyToAddress derives the address of a hex-encoded public key
+func PublicKeyToAddress(publicKey string) string {
+	addressHash := sha256.Sum256([]byte(publicKey))
+	return "GLD" + hex.EncodeToString(addressHash[:20])
+}
+
+// VerifyPublicKeySignature verifies an ed25519 signature made by a hex-encoded public key
+func VerifyPublicKeySignature(publicKey, message, signature string) bool {
+	pub, err := hex.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
 
-	return signature, nil
+	return ed25519.Verify(ed25519.PublicKey(pub), []byte(message), sig)
 }
 
-// VerifySignature verifies a message signature
+// VerifySignature checks the format of a message signature. The address alone
+// does not carry the public key; use VerifyPublicKeySignature when it is known.
 func VerifySignature(address, message, signature string) bool {
-	// Simple verification (in production, use proper cryptographic verification)
-	return signature != "" && len(signature) == 64
+	return signature != "" && len(signature) == ed25519.SignatureSize*2
 }
@@ -0,0 +1,396 @@
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// keyStoreVersion follows the Web3 Secret Storage layout
+	keyStoreVersion = 3
+
+	// StandardScryptN and StandardScryptP are the KDF parameters for production key files
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+
+	// LightScryptN and LightScryptP trade strength for speed (tests, low-power devices)
+	LightScryptN = 1 << 12
+	LightScryptP = 6
+
+	scryptR     = 8
+	scryptDKLen = 32
+
+	keyFileExt = ".json"
+)
+
+var (
+	// ErrKeyNotFound is returned when no key file exists for an address
+	ErrKeyNotFound = errors.New("key not found in keystore")
+	// ErrDecrypt is returned when a key file cannot be decrypted with the given passphrase
+	ErrDecrypt = errors.New("could not decrypt key with given passphrase")
+)
+
+// EncryptedKey is the on-disk representation of a private key
+type EncryptedKey struct {
+	Version   int        `json:"version"`
+	ID        string     `json:"id"`
+	Address   string     `json:"address"`
+	PublicKey string     `json:"publicKey"`
+	Label     string     `json:"label"`
+	CreatedAt int64      `json:"createdAt"`
+	Crypto    CryptoJSON `json:"crypto"`
+}
+
+// CryptoJSON holds the cipher and KDF parameters of an encrypted key
+type CryptoJSON struct {
+	Cipher       string           `json:"cipher"`
+	CipherText   string           `json:"ciphertext"`
+	CipherParams CipherParamsJSON `json:"cipherparams"`
+	KDF          string           `json:"kdf"`
+	KDFParams    ScryptParamsJSON `json:"kdfparams"`
+}
+
+// CipherParamsJSON holds the AES-GCM nonce
+type CipherParamsJSON struct {
+	Nonce string `json:"nonce"`
+}
+
+// ScryptParamsJSON holds the scrypt parameters used to derive the encryption key
+type ScryptParamsJSON struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// KeyStore stores private keys encrypted with a passphrase in a directory
+type KeyStore struct {
+	dir     string
+	scryptN int
+	scryptP int
+}
+
+// NewKeyStore creates a keystore in dir using the standard scrypt parameters
+func NewKeyStore(dir string) (*KeyStore, error) {
+	return newKeyStore(dir, StandardScryptN, StandardScryptP)
+}
+
+// NewLightKeyStore creates a keystore in dir using the light scrypt parameters
+func NewLightKeyStore(dir string) (*KeyStore, error) {
+	return newKeyStore(dir, LightScryptN, LightScryptP)
+}
+
+func newKeyStore(dir string, scryptN, scryptP int) (*KeyStore, error) {
+	if dir == "" {
+		return nil, errors.New("keystore directory cannot be empty")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{
+		dir:     dir,
+		scryptN: scryptN,
+		scryptP: scryptP,
+	}, nil
+}
+
+// StoreKey encrypts a private key with the passphrase and writes it to disk
+func (ks *KeyStore) StoreKey(addr *Address, privateKey []byte, passphrase string) error {
+	if addr == nil {
+		return errors.New("address is nil")
+	}
+
+	if passphrase == "" {
+		return errors.New("passphrase cannot be empty")
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, ks.scryptN, scryptR, ks.scryptP, scryptDKLen)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(derivedKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	// The address is bound as additional data so a ciphertext cannot be moved to another key file
+	cipherText := gcm.Seal(nil, nonce, privateKey, []byte(addr.Address))
+
+	key := &EncryptedKey{
+		Version:   keyStoreVersion,
+		ID:        hex.EncodeToString(id),
+		Address:   addr.Address,
+		PublicKey: addr.PublicKey,
+		Label:     addr.Label,
+		CreatedAt: addr.CreatedAt,
+		Crypto: CryptoJSON{
+			Cipher:       "aes-256-gcm",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: CipherParamsJSON{Nonce: hex.EncodeToString(nonce)},
+			KDF:          "scrypt",
+			KDFParams: ScryptParamsJSON{
+				N:     ks.scryptN,
+				R:     scryptR,
+				P:     ks.scryptP,
+				DKLen: scryptDKLen,
+				Salt:  hex.EncodeToString(salt),
+			},
+		},
+	}
+
+	data, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeKeyFile(ks.keyPath(addr.Address), data)
+}
+
+// GetKey decrypts the private key for an address
+func (ks *KeyStore) GetKey(address, passphrase string) ([]byte, *Address, error) {
+	key, err := ks.readKey(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, err := decryptKey(key, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateKey, key.toAddress(), nil
+}
+
+// HasAddress checks if a key file exists for an address
+func (ks *KeyStore) HasAddress(address string) bool {
+	_, err := os.Stat(ks.keyPath(address))
+	return err == nil
+}
+
+// Addresses returns the public information of every key in the keystore
+func (ks *KeyStore) Addresses() ([]*Address, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]*Address, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExt {
+			continue
+		}
+
+		key, err := ks.readKey(strings.TrimSuffix(entry.Name(), keyFileExt))
+		if err != nil {
+			continue
+		}
+
+		addresses = append(addresses, key.toAddress())
+	}
+
+	return addresses, nil
+}
+
+// Delete removes the key file for an address after checking the passphrase
+func (ks *KeyStore) Delete(address, passphrase string) error {
+	privateKey, _, err := ks.GetKey(address, passphrase)
+	if err != nil {
+		return err
+	}
+	zeroBytes(privateKey)
+
+	return os.Remove(ks.keyPath(address))
+}
+
+// keyPath returns the key file path for an address
+func (ks *KeyStore) keyPath(address string) string {
+	return filepath.Join(ks.dir, address+keyFileExt)
+}
+
+// readKey loads and parses a key file
+func (ks *KeyStore) readKey(address string) (*EncryptedKey, error) {
+	if err := ValidateAddress(address); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(ks.keyPath(address))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	key := &EncryptedKey{}
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, err
+	}
+
+	if key.Version != keyStoreVersion {
+		return nil, errors.New("unsupported key file version")
+	}
+
+	if key.Address != address {
+		return nil, errors.New("key file address mismatch")
+	}
+
+	return key, nil
+}
+
+// toAddress returns the public part of an encrypted key
+func (key *EncryptedKey) toAddress() *Address {
+	return &Address{
+		PublicKey: key.PublicKey,
+		Address:   key.Address,
+		Label:     key.Label,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+// decryptKey derives the encryption key from the passphrase and opens the ciphertext
+func decryptKey(key *EncryptedKey, passphrase string) ([]byte, error) {
+	if key.Crypto.Cipher != "aes-256-gcm" {
+		return nil, errors.New("unsupported cipher: " + key.Crypto.Cipher)
+	}
+
+	if key.Crypto.KDF != "scrypt" {
+		return nil, errors.New("unsupported KDF: " + key.Crypto.KDF)
+	}
+
+	// The parameters come from the file, so bound them before deriving the
+	// key: a crafted file could otherwise demand any amount of memory and
+	// CPU before the MAC check rejects it
+	params := key.Crypto.KDFParams
+	if !validScryptParams(params) {
+		return nil, errors.New("scrypt parameters out of range")
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := hex.DecodeString(key.Crypto.CipherParams.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText, err := hex.DecodeString(key.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+
+	privateKey, err := gcm.Open(nil, nonce, cipherText, []byte(key.Address))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return privateKey, nil
+}
+
+// validScryptParams reports whether params are no more expensive than the
+// parameters the keystore writes
+func validScryptParams(params ScryptParamsJSON) bool {
+	if params.R != scryptR || params.DKLen != scryptDKLen {
+		return false
+	}
+	if params.N <= 1 || params.N&(params.N-1) != 0 || params.N > StandardScryptN {
+		return false
+	}
+	if params.P < 1 || params.P > LightScryptP {
+		return false
+	}
+	return params.N*params.P <= StandardScryptN*StandardScryptP
+}
+
+// newGCM creates an AES-GCM cipher from a derived key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeKeyFile writes a key file atomically with owner-only permissions
+func writeKeyFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// zeroBytes overwrites key material before it is released
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,166 @@
+package identity
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T) (*AddressManager, *KeyStore, string) {
+	dir := t.TempDir()
+
+	ks, err := NewLightKeyStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create keystore: %v", err)
+	}
+
+	am, err := NewAddressManagerWithKeyStore(ks)
+	if err != nil {
+		t.Fatalf("Failed to create address manager: %v", err)
+	}
+
+	return am, ks, dir
+}
+
+func TestGenerateEncryptedAddress(t *testing.T) {
+	am, _, dir := newTestManager(t)
+
+	addr, err := am.GenerateEncryptedAddress("Alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to generate encrypted address: %v", err)
+	}
+
+	if am.IsUnlocked(addr.Address) {
+		t.Error("Expected new encrypted address to start locked")
+	}
+
+	path := filepath.Join(dir, addr.Address+".json")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected key file on disk: %v", err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"cipher": "aes-256-gcm"`) {
+		t.Error("Expected key file to use aes-256-gcm")
+	}
+}
+
+func TestUnlockAndSign(t *testing.T) {
+	am, _, _ := newTestManager(t)
+
+	addr, _ := am.GenerateEncryptedAddress("Alice", "correct horse")
+
+	if _, err := am.SignMessage(addr.Address, "hello"); err != ErrAddressLocked {
+		t.Errorf("Expected ErrAddressLocked, got %v", err)
+	}
+
+	if err := am.Unlock(addr.Address, "wrong", 0); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for wrong passphrase, got %v", err)
+	}
+
+	if err := am.Unlock(addr.Address, "correct horse", 0); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+
+	if _, err := am.SignMessage(addr.Address, "hello"); err != nil {
+		t.Errorf("Failed to sign with unlocked address: %v", err)
+	}
+
+	if err := am.Lock(addr.Address); err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+
+	if _, err := am.SignMessage(addr.Address, "hello"); err != ErrAddressLocked {
+		t.Errorf("Expected ErrAddressLocked after Lock, got %v", err)
+	}
+}
+
+func TestUnlockTimeout(t *testing.T) {
+	am, _, _ := newTestManager(t)
+
+	addr, _ := am.GenerateEncryptedAddress("Alice", "correct horse")
+
+	if err := am.Unlock(addr.Address, "correct horse", 50*time.Millisecond); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+
+	if !am.IsUnlocked(addr.Address) {
+		t.Fatal("Expected address to be unlocked")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if am.IsUnlocked(addr.Address) {
+		t.Error("Expected address to relock after timeout")
+	}
+}
+
+func TestKeyStoreReload(t *testing.T) {
+	am, _, dir := newTestManager(t)
+
+	addr, _ := am.GenerateEncryptedAddress("Alice", "correct horse")
+
+	ks, _ := NewLightKeyStore(dir)
+	reloaded, err := NewAddressManagerWithKeyStore(ks)
+	if err != nil {
+		t.Fatalf("Failed to reload address manager: %v", err)
+	}
+
+	loaded, err := reloaded.GetAddress(addr.Address)
+	if err != nil {
+		t.Fatalf("Expected address to be loaded from keystore: %v", err)
+	}
+
+	if loaded.Label != "Alice" || loaded.PublicKey != addr.PublicKey {
+		t.Error("Loaded address does not match generated address")
+	}
+
+	if err := reloaded.Unlock(addr.Address, "correct horse", 0); err != nil {
+		t.Errorf("Failed to unlock reloaded address: %v", err)
+	}
+}
+
+func TestKeyStoreDelete(t *testing.T) {
+	am, ks, _ := newTestManager(t)
+
+	addr, _ := am.GenerateEncryptedAddress("Alice", "correct horse")
+
+	if err := ks.Delete(addr.Address, "wrong"); err == nil {
+		t.Error("Expected error deleting key with wrong passphrase")
+	}
+
+	if err := ks.Delete(addr.Address, "correct horse"); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+
+	if ks.HasAddress(addr.Address) {
+		t.Error("Expected key file to be removed")
+	}
+}
+
+func TestKeyStoreRejectsExpensiveScryptParams(t *testing.T) {
+	am, ks, dir := newTestManager(t)
+
+	addr, _ := am.GenerateEncryptedAddress("Alice", "correct horse")
+	path := filepath.Join(dir, addr.Address+".json")
+
+	var key EncryptedKey
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &key) //nolint:errcheck
+
+	key.Crypto.KDFParams.N = 1 << 30
+	data, _ = json.Marshal(&key)
+	os.WriteFile(path, data, 0600) //nolint:errcheck
+
+	if _, _, err := ks.GetKey(addr.Address, "correct horse"); err == nil || err == ErrDecrypt {
+		t.Errorf("Expected oversized scrypt parameters to be rejected, got %v", err)
+	}
+}
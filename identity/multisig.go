@@ -0,0 +1,125 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxMultisigKeys is the maximum number of public keys in a multisig address
+const MaxMultisigKeys = 15
+
+// MultisigAddress is an M-of-N address controlled by a set of public keys
+type MultisigAddress struct {
+	Address    string
+	Threshold  int
+	PublicKeys []string
+}
+
+// NewMultisigAddress derives an M-of-N multisig address from a set of public keys.
+// Keys are sorted so the same set always yields the same address.
+func NewMultisigAddress(threshold int, publicKeys []string) (*MultisigAddress, error) {
+	if len(publicKeys) == 0 {
+		return nil, errors.New("multisig requires at least one public key")
+	}
+
+	if len(publicKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig supports at most %d public keys", MaxMultisigKeys)
+	}
+
+	if threshold < 1 || threshold > len(publicKeys) {
+		return nil, errors.New("invalid threshold: must be between 1 and the number of public keys")
+	}
+
+	keys := make([]string, len(publicKeys))
+	seen := make(map[string]bool, len(publicKeys))
+	for i, key := range publicKeys {
+		key = strings.ToLower(key)
+
+		pub, err := hex.DecodeString(key)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key: %s", key)
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate public key: %s", key)
+		}
+		seen[key] = true
+		keys[i] = key
+	}
+	sort.Strings(keys)
+
+	ms := &MultisigAddress{
+		Threshold:  threshold,
+		PublicKeys: keys,
+	}
+	ms.Address = ms.deriveAddress()
+
+	return ms, nil
+}
+
+// deriveAddress hashes the threshold and sorted key set into an address
+func (ms *MultisigAddress) deriveAddress() string {
+	data := fmt.Sprintf("multisig:%d:%s", ms.Threshold, strings.Join(ms.PublicKeys, ","))
+	hash := sha256.Sum256([]byte(data))
+	return "GLD" + hex.EncodeToString(hash[:20])
+}
+
+// Validate checks that the key set and threshold are well formed and match the address
+func (ms *MultisigAddress) Validate() error {
+	if ms == nil {
+		return errors.New("multisig address is nil")
+	}
+
+	expected, err := NewMultisigAddress(ms.Threshold, ms.PublicKeys)
+	if err != nil {
+		return err
+	}
+
+	if expected.Address != ms.Address {
+		return errors.New("multisig address does not match its public keys")
+	}
+
+	return nil
+}
+
+// HasPublicKey checks if a public key is one of the signers
+func (ms *MultisigAddress) HasPublicKey(publicKey string) bool {
+	publicKey = strings.ToLower(publicKey)
+	for _, key := range ms.PublicKeys {
+		if key == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// CountValidSignatures counts distinct signers with a valid signature over message.
+// signatures maps a signer's public key to its hex signature.
+func (ms *MultisigAddress) CountValidSignatures(message string, signatures map[string]string) int {
+	signed := make(map[string]bool, len(signatures))
+	for publicKey, signature := range signatures {
+		publicKey = strings.ToLower(publicKey)
+		if signed[publicKey] || !ms.HasPublicKey(publicKey) {
+			continue
+		}
+
+		if VerifyPublicKeySignature(publicKey, message, signature) {
+			signed[publicKey] = true
+		}
+	}
+	return len(signed)
+}
+
+// VerifySignatures checks that at least Threshold signers signed message
+func (ms *MultisigAddress) VerifySignatures(message string, signatures map[string]string) error {
+	valid := ms.CountValidSignatures(message, signatures)
+	if valid < ms.Threshold {
+		return fmt.Errorf("insufficient signatures: have %d valid, need %d", valid, ms.Threshold)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// BanThreshold is the misbehavior score at which a peer is banned
+	BanThreshold = 100
+
+	// DefaultBanDuration is how long a peer is banned for misbehaving
+	DefaultBanDuration = 24 * time.Hour
+
+	// ScoreDecayInterval is how often a peer's misbehavior score drops by
+	// one point, so occasional faults are forgiven over time
+	ScoreDecayInterval = time.Minute
+
+	// ScoreMalformedMessage is added for a message that cannot be decoded,
+	// breaks protocol limits or arrives before the handshake
+	ScoreMalformedMessage = 20
+	// ScoreInvalidBlock is added for headers or blocks that fail validation
+	ScoreInvalidBlock = 50
+	// ScoreInvalidTransaction is added for a transaction the node rejects
+	ScoreInvalidTransaction = 10
+	// ScoreRateLimited is added for each transaction dropped for exceeding
+	// the peer's rate limit
+	ScoreRateLimited = 1
+
+	// DefaultMaxInbound, DefaultMaxOutbound and DefaultMaxPerIP are the
+	// default connection limits
+	DefaultMaxInbound  = 64
+	DefaultMaxOutbound = 16
+	DefaultMaxPerIP    = 4
+
+	// maxDisconnectedPeers bounds the disconnected peers remembered for
+	// their misbehavior score
+	maxDisconnectedPeers = 1000
+)
+
+var (
+	// ErrPeerBanned is returned when connecting to or from a banned node or address
+	ErrPeerBanned = errors.New("peer is banned")
+	// ErrTooManyPeers is returned when a connection would exceed the inbound or outbound limit
+	ErrTooManyPeers = errors.New("connection limit reached")
+	// ErrTooManyFromIP is returned when an address already has the most inbound connections allowed
+	ErrTooManyFromIP = errors.New("too many connections from address")
+)
+
+// Ban is a temporary ban of a node ID or IP address
+type Ban struct {
+	Target    string    `json:"target"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SetConnectionLimits sets the most inbound and outbound connections, and
+// the most inbound connections from one IP address. A limit of 0 or less
+// means no limit.
+func (n *Network) SetConnectionLimits(maxInbound, maxOutbound, maxPerIP int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.maxInbound = maxInbound
+	n.maxOutbound = maxOutbound
+	n.maxPerIP = maxPerIP
+}
+
+// Misbehaving adds points to a peer's misbehavior score, which decays by
+// one point every ScoreDecayInterval. A peer reaching BanThreshold is
+// disconnected and banned for DefaultBanDuration.
+func (n *Network) Misbehaving(peerID string, points int, reason string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if p, exists := n.peers[peerID]; exists {
+		n.penalize(p, points, reason)
+	}
+}
+
+// Ban bans a node ID or IP address for duration, or DefaultBanDuration if
+// duration is not positive, and disconnects the peers it matches
+func (n *Network) Ban(target string, duration time.Duration, reason string) {
+	if duration <= 0 {
+		duration = DefaultBanDuration
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.ban(target, duration, reason)
+	for id, p := range n.peers {
+		if id == target || p.ip == target {
+			n.dropPeer(p)
+		}
+	}
+	n.saveBans() //nolint:errcheck
+}
+
+// Unban lifts the ban on a node ID or IP address
+func (n *Network) Unban(target string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if _, exists := n.bans[target]; !exists {
+		return errors.New("ban not found")
+	}
+
+	delete(n.bans, target)
+	return n.saveBans()
+}
+
+// PersistBans loads the bans saved at path and saves every later change
+// there, so bans survive a restart. Expired bans are dropped on load.
+func (n *Network) PersistBans(path string) error {
+	var saved []*Ban
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	for _, ban := range saved {
+		if ban.Target != "" && now.Before(ban.ExpiresAt) {
+			n.bans[ban.Target] = ban
+		}
+	}
+
+	n.banPath = path
+	return n.saveBans()
+}
+
+// IsBanned reports whether a node ID or IP address is banned
+func (n *Network) IsBanned(target string) bool {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.banned(target, time.Now())
+}
+
+// GetBans returns the active bans, soonest to expire first
+func (n *Network) GetBans() []Ban {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(n.bans))
+	for target, ban := range n.bans {
+		if !now.Before(ban.ExpiresAt) {
+			delete(n.bans, target)
+			continue
+		}
+		bans = append(bans, *ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ExpiresAt.Before(bans[j].ExpiresAt) })
+	return bans
+}
+
+// penalize adds to a peer's decayed score, banning it at the threshold;
+// callers must hold the lock
+func (n *Network) penalize(p *Peer, points int, reason string) {
+	decayScore(p, time.Now())
+	p.Score += points
+	if p.Score < BanThreshold {
+		return
+	}
+
+	n.ban(p.ID, DefaultBanDuration, reason)
+	// Every process on this machine shares the loopback address, so only
+	// remote addresses are banned along with the node
+	if ip := net.ParseIP(p.ip); ip != nil && !ip.IsLoopback() {
+		n.ban(p.ip, DefaultBanDuration, reason)
+	}
+	n.dropPeer(p)
+	n.saveBans() //nolint:errcheck
+}
+
+// decayScore takes one point off a peer's score for every
+// ScoreDecayInterval since it was last decayed
+func decayScore(p *Peer, now time.Time) {
+	if p.Score == 0 {
+		p.scoredAt = now
+		return
+	}
+
+	steps := int(now.Sub(p.scoredAt) / ScoreDecayInterval)
+	if steps <= 0 {
+		return
+	}
+
+	if steps >= p.Score {
+		p.Score = 0
+		p.scoredAt = now
+		return
+	}
+	p.Score -= steps
+	p.scoredAt = p.scoredAt.Add(time.Duration(steps) * ScoreDecayInterval)
+}
+
+// prunePeers forgets disconnected peers whose score has decayed to zero,
+// and the longest unseen ones beyond maxDisconnectedPeers; callers must
+// hold the lock
+func (n *Network) prunePeers(now time.Time) {
+	disconnected := make([]*Peer, 0)
+	for id, p := range n.peers {
+		if p.Status != PeerStatusDisconnected {
+			continue
+		}
+
+		decayScore(p, now)
+		if p.Score == 0 {
+			delete(n.peers, id)
+			continue
+		}
+		disconnected = append(disconnected, p)
+	}
+
+	if len(disconnected) <= maxDisconnectedPeers {
+		return
+	}
+
+	sort.Slice(disconnected, func(i, j int) bool { return disconnected[i].LastSeen.Before(disconnected[j].LastSeen) })
+	for _, p := range disconnected[:len(disconnected)-maxDisconnectedPeers] {
+		delete(n.peers, p.ID)
+	}
+}
+
+// saveBans writes the active bans to the ban file, if bans are persisted;
+// callers must hold the lock
+func (n *Network) saveBans() error {
+	if n.banPath == "" {
+		return nil
+	}
+
+	now := time.Now()
+	saved := make([]*Ban, 0, len(n.bans))
+	for _, ban := range n.bans {
+		if now.Before(ban.ExpiresAt) {
+			saved = append(saved, ban)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Target < saved[j].Target })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return saveFileAtomic(n.banPath, data)
+}
+
+// ban records a ban; callers must hold the lock
+func (n *Network) ban(target string, duration time.Duration, reason string) {
+	now := time.Now()
+	n.bans[target] = &Ban{Target: target, Reason: reason, CreatedAt: now, ExpiresAt: now.Add(duration)}
+}
+
+// banned reports whether a target is banned at now; callers must hold the lock
+func (n *Network) banned(target string, now time.Time) bool {
+	ban, exists := n.bans[target]
+	return exists && now.Before(ban.ExpiresAt)
+}
+
+// dropPeer closes a peer's connection and forgets it; callers must hold the lock
+func (n *Network) dropPeer(p *Peer) {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.Status = PeerStatusDisconnected
+	delete(n.peers, p.ID)
+}
+
+// admit checks a new connection against the bans and connection limits,
+// counting inbound handshakes in progress; callers must hold the lock
+func (n *Network) admit(ip string, inbound bool) error {
+	if n.banned(ip, time.Now()) {
+		return ErrPeerBanned
+	}
+
+	inboundCount, outboundCount, fromIP := 0, 0, n.handshakes[ip]
+	for _, pending := range n.handshakes {
+		inboundCount += pending
+	}
+	for _, p := range n.peers {
+		if p.Status == PeerStatusDisconnected {
+			continue
+		}
+		if !p.Inbound {
+			outboundCount++
+			continue
+		}
+		inboundCount++
+		if p.ip == ip {
+			fromIP++
+		}
+	}
+
+	if !inbound {
+		if n.maxOutbound > 0 && outboundCount >= n.maxOutbound {
+			return ErrTooManyPeers
+		}
+		return nil
+	}
+
+	if n.maxInbound > 0 && inboundCount >= n.maxInbound {
+		return ErrTooManyPeers
+	}
+	if n.maxPerIP > 0 && fromIP >= n.maxPerIP {
+		return ErrTooManyFromIP
+	}
+	return nil
+}
+
+// hostOf returns the host part of a network address
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// isProtocolError reports whether a read error was caused by the peer
+// breaking the wire protocol, rather than the connection failing
+func isProtocolError(err error) bool {
+	return errors.Is(err, ErrBadMagic) || errors.Is(err, ErrUnknownMessageType) ||
+		errors.Is(err, ErrMessageTooLarge) || errors.Is(err, ErrChecksumMismatch) ||
+		errors.Is(err, ErrMalformedMessage) || errors.Is(err, ErrPeerIDMismatch)
+}
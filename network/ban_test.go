@@ -0,0 +1,231 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Stop()
+
+	client, _ := NewNetwork("127.0.0.1:0")
+	if _, err := client.Connect(addr); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	waitFor(t, "server to see the client", func() bool { return len(server.GetConnectedPeers()) == 1 })
+
+	server.Misbehaving(client.GetNodeID(), ScoreInvalidBlock, "test")
+	if server.IsBanned(client.GetNodeID()) {
+		t.Fatal("Expected a peer below the threshold not to be banned")
+	}
+
+	server.Misbehaving(client.GetNodeID(), ScoreInvalidBlock, "test")
+	if !server.IsBanned(client.GetNodeID()) {
+		t.Fatal("Expected a peer reaching the threshold to be banned")
+	}
+
+	if server.GetPeerCount() != 0 {
+		t.Error("Expected the banned peer to be disconnected")
+	}
+
+	// A reconnect from the banned node is refused
+	client.Connect(addr) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+	if server.GetPeerCount() != 0 {
+		t.Error("Expected the banned peer to be refused")
+	}
+
+	bans := server.GetBans()
+	if len(bans) != 1 || bans[0].Target != client.GetNodeID() {
+		t.Errorf("Expected only the node ID to be banned for a loopback peer, got %+v", bans)
+	}
+}
+
+func TestBanExpiresAndUnban(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+
+	n.Ban("10.0.0.1", 20*time.Millisecond, "test")
+	n.Ban("node1", time.Hour, "test")
+	if !n.IsBanned("10.0.0.1") || !n.IsBanned("node1") {
+		t.Fatal("Expected targets to be banned")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n.IsBanned("10.0.0.1") {
+		t.Error("Expected the ban to expire")
+	}
+
+	if bans := n.GetBans(); len(bans) != 1 || bans[0].Target != "node1" {
+		t.Errorf("Expected only the unexpired ban, got %+v", bans)
+	}
+
+	if err := n.Unban("node1"); err != nil {
+		t.Errorf("Failed to unban: %v", err)
+	}
+	if n.IsBanned("node1") {
+		t.Error("Expected the ban to be lifted")
+	}
+
+	if err := n.Unban("node1"); err == nil {
+		t.Error("Expected an error lifting a ban that does not exist")
+	}
+}
+
+func TestInboundConnectionLimits(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Stop()
+	server.SetConnectionLimits(0, 0, 2)
+
+	for i := 0; i < 3; i++ {
+		client, _ := NewNetwork("127.0.0.1:0")
+		client.Connect(addr) //nolint:errcheck
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if count := server.GetPeerCount(); count != 2 {
+		t.Errorf("Expected 2 connections from one address, got %d", count)
+	}
+
+	server.SetConnectionLimits(2, 0, 0)
+	client, _ := NewNetwork("127.0.0.1:0")
+	client.Connect(addr) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	if count := server.GetPeerCount(); count != 2 {
+		t.Errorf("Expected the inbound limit to hold at 2, got %d", count)
+	}
+}
+
+func TestOutboundConnectionLimit(t *testing.T) {
+	first, firstAddr := startTestServer(t)
+	defer first.Stop()
+	second, secondAddr := startTestServer(t)
+	defer second.Stop()
+
+	client, _ := NewNetwork("127.0.0.1:0")
+	client.SetConnectionLimits(0, 1, 0)
+
+	if _, err := client.Connect(firstAddr); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	if _, err := client.Connect(secondAddr); err != ErrTooManyPeers {
+		t.Errorf("Expected ErrTooManyPeers, got %v", err)
+	}
+}
+
+func TestProtocolErrorsAreScored(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected, conn: local}
+	n.peers[peer.ID] = peer
+
+	go remote.Write(make([]byte, frameHeaderSize)) //nolint:errcheck
+	n.readLoop(peer)
+
+	if peer.Score != ScoreMalformedMessage {
+		t.Errorf("Expected score %d after a bad frame, got %d", ScoreMalformedMessage, peer.Score)
+	}
+}
+
+func TestPendingHandshakesCountAgainstLimits(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Stop()
+	server.SetConnectionLimits(0, 0, 2)
+
+	// Connections that never complete the TLS handshake still hold a slot
+	for i := 0; i < 2; i++ {
+		raw, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer raw.Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	client, _ := NewNetwork("127.0.0.1:0")
+	if _, err := client.Connect(addr); err == nil {
+		t.Error("Expected a connection over the limit to fail while handshakes are pending")
+	}
+}
+
+func TestScoreDecaysOverTime(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected, Score: 90, scoredAt: time.Now().Add(-20 * ScoreDecayInterval)}
+	n.peers[peer.ID] = peer
+
+	n.Misbehaving(peer.ID, ScoreMalformedMessage, "test")
+
+	if n.IsBanned(peer.ID) {
+		t.Error("Expected old misbehavior to have decayed before the new points")
+	}
+
+	if peer.Score != 90 {
+		t.Errorf("Expected score 90 after decay, got %d", peer.Score)
+	}
+}
+
+func TestDisconnectedPeersArePruned(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	now := time.Now()
+	n.peers["forgiven"] = &Peer{ID: "forgiven", Status: PeerStatusDisconnected}
+	n.peers["scored"] = &Peer{ID: "scored", Status: PeerStatusDisconnected, Score: 50, scoredAt: now}
+	n.peers["connected"] = &Peer{ID: "connected", Status: PeerStatusConnected}
+
+	n.prunePeers(now)
+
+	if _, exists := n.peers["forgiven"]; exists {
+		t.Error("Expected a disconnected peer with no score to be forgotten")
+	}
+	if _, exists := n.peers["scored"]; !exists {
+		t.Error("Expected a disconnected peer to be remembered while it has a score")
+	}
+	if _, exists := n.peers["connected"]; !exists {
+		t.Error("Expected a connected peer to be kept")
+	}
+
+	for i := 0; i < maxDisconnectedPeers+10; i++ {
+		id := fmt.Sprintf("peer%d", i)
+		n.peers[id] = &Peer{ID: id, Status: PeerStatusDisconnected, Score: 1, scoredAt: now, LastSeen: now.Add(time.Duration(i) * time.Second)}
+	}
+	n.prunePeers(now)
+
+	if count := n.GetPeerCount(); count != maxDisconnectedPeers+1 {
+		t.Errorf("Expected %d peers after pruning, got %d", maxDisconnectedPeers+1, count)
+	}
+	if _, exists := n.peers["peer0"]; exists {
+		t.Error("Expected the longest unseen peer to be forgotten first")
+	}
+}
+
+func TestBansSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	n, _ := NewNetwork("127.0.0.1:0")
+	if err := n.PersistBans(path); err != nil {
+		t.Fatalf("Failed to load bans: %v", err)
+	}
+	n.Ban("10.0.0.9", time.Hour, "test")
+	n.Ban("10.0.0.10", time.Hour, "test")
+	if err := n.Unban("10.0.0.10"); err != nil {
+		t.Fatalf("Failed to unban: %v", err)
+	}
+
+	restarted, _ := NewNetwork("127.0.0.1:0")
+	if err := restarted.PersistBans(path); err != nil {
+		t.Fatalf("Failed to reload bans: %v", err)
+	}
+
+	if !restarted.IsBanned("10.0.0.9") {
+		t.Error("Expected the ban to survive a restart")
+	}
+	if restarted.IsBanned("10.0.0.10") {
+		t.Error("Expected a lifted ban to stay lifted")
+	}
+}
@@ -0,0 +1,709 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTargetPeers is how many outbound peers discovery maintains
+	DefaultTargetPeers = 8
+
+	// DefaultDiscoveryInterval is how often discovery checks the peer count
+	DefaultDiscoveryInterval = 30 * time.Second
+
+	// MaxPeerListEntries is the most addresses in one peer_list message
+	MaxPeerListEntries = 250
+
+	// MaxKnownAddresses bounds the address book
+	MaxKnownAddresses = 2000
+
+	// Sources of addresses in the address book
+	SourceSeed      = "seed"
+	SourceHandshake = "handshake"
+	SourcePeerList  = "peer_list"
+
+	// retryBackoff is the wait after a first failed dial; it doubles with
+	// each further failure up to maxRetryBackoff
+	retryBackoff    = 30 * time.Second
+	maxRetryBackoff = time.Hour
+
+	// maxAddressFailures is how many consecutive failed dials an address
+	// survives, unless it was reachable within addressExpiry
+	maxAddressFailures = 10
+	addressExpiry      = 7 * 24 * time.Hour
+
+	// peerListInterval is how often a peer may send us an unrequested
+	// peer list, or ask for ours
+	peerListInterval = 10 * time.Minute
+)
+
+// KnownAddress is a node in the address book
+type KnownAddress struct {
+	ID          string    `json:"id"`
+	Address     string    `json:"address"`
+	Source      string    `json:"source"`
+	LastSeen    time.Time `json:"lastSeen"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	Failures    int       `json:"failures"`
+}
+
+// dialable reports whether the address may be dialled at now
+func (a *KnownAddress) dialable(now time.Time) bool {
+	if a.Failures == 0 {
+		return true
+	}
+
+	backoff := maxRetryBackoff
+	if a.Failures < 8 {
+		backoff = min(retryBackoff<<(a.Failures-1), maxRetryBackoff)
+	}
+	return now.Sub(a.LastAttempt) >= backoff
+}
+
+// expired reports whether the address has failed long enough to forget
+func (a *KnownAddress) expired(now time.Time) bool {
+	return a.Failures >= maxAddressFailures && now.Sub(a.LastSuccess) > addressExpiry
+}
+
+// AddressBook remembers the addresses of nodes, keyed by node ID, and can
+// persist them to a file so a restarted node does not depend on its seeds
+type AddressBook struct {
+	path      string
+	addresses map[string]*KnownAddress
+	dirty     bool
+	mutex     sync.Mutex
+}
+
+// NewAddressBook creates an address book stored at path, loading the
+// addresses saved there. An empty path keeps the book in memory only.
+func NewAddressBook(path string) (*AddressBook, error) {
+	b := &AddressBook{path: path, addresses: make(map[string]*KnownAddress)}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*KnownAddress
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range saved {
+		if addr.ID != "" && validPeerAddress(addr.Address) {
+			b.addresses[addr.ID] = addr
+		}
+	}
+
+	return b, nil
+}
+
+// Add records the address of a node and reports whether the book changed.
+// An address learned from a peer list never replaces one we have
+// connected to, since it comes from a third party.
+func (b *AddressBook) Add(id, address, source string) bool {
+	if id == "" || !validPeerAddress(address) {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if existing, exists := b.addresses[id]; exists {
+		if existing.Address == address {
+			existing.LastSeen = now
+			return false
+		}
+		if source == SourcePeerList && !existing.LastSuccess.IsZero() {
+			return false
+		}
+
+		existing.Address = address
+		existing.Source = source
+		existing.LastSeen = now
+		existing.Failures = 0
+		b.dirty = true
+		return true
+	}
+
+	if len(b.addresses) >= MaxKnownAddresses && !b.evict(now) {
+		return false
+	}
+
+	b.addresses[id] = &KnownAddress{ID: id, Address: address, Source: source, LastSeen: now}
+	b.dirty = true
+	return true
+}
+
+// Remove forgets a node
+func (b *AddressBook) Remove(id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, exists := b.addresses[id]; exists {
+		delete(b.addresses, id)
+		b.dirty = true
+	}
+}
+
+// Get returns the address of a node
+func (b *AddressBook) Get(id string) (KnownAddress, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	addr, exists := b.addresses[id]
+	if !exists {
+		return KnownAddress{}, false
+	}
+	return *addr, true
+}
+
+// Addresses returns every known address, most recently seen first
+func (b *AddressBook) Addresses() []KnownAddress {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	addresses := make([]KnownAddress, 0, len(b.addresses))
+	for _, addr := range b.addresses {
+		addresses = append(addresses, *addr)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].LastSeen.After(addresses[j].LastSeen)
+	})
+	return addresses
+}
+
+// Len returns the number of known addresses
+func (b *AddressBook) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.addresses)
+}
+
+// Save writes the address book to its file if it changed since the last save
+func (b *AddressBook) Save() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.path == "" || !b.dirty {
+		return nil
+	}
+
+	saved := make([]*KnownAddress, 0, len(b.addresses))
+	for _, addr := range b.addresses {
+		saved = append(saved, addr)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].ID < saved[j].ID })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := saveFileAtomic(b.path, data); err != nil {
+		return err
+	}
+
+	b.dirty = false
+	return nil
+}
+
+// markAttempt records that a dial to a node is starting
+func (b *AddressBook) markAttempt(id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if addr, exists := b.addresses[id]; exists {
+		addr.LastAttempt = time.Now()
+		b.dirty = true
+	}
+}
+
+// markSuccess records a successful connection to a node at address
+func (b *AddressBook) markSuccess(id, address, source string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	addr, exists := b.addresses[id]
+	if !exists {
+		if len(b.addresses) >= MaxKnownAddresses && !b.evict(now) {
+			return
+		}
+		addr = &KnownAddress{ID: id, Source: source}
+		b.addresses[id] = addr
+	}
+
+	addr.Address = address
+	addr.LastSeen = now
+	addr.LastSuccess = now
+	addr.Failures = 0
+	b.dirty = true
+}
+
+// markFailure records a failed dial, forgetting the node once it has
+// failed too often
+func (b *AddressBook) markFailure(id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	addr, exists := b.addresses[id]
+	if !exists {
+		return
+	}
+
+	addr.Failures++
+	if addr.expired(time.Now()) {
+		delete(b.addresses, id)
+	}
+	b.dirty = true
+}
+
+// candidates returns up to limit addresses to dial, skipping nodes in
+// exclude and addresses still backing off. Nodes reached before are
+// preferred; the rest are in random order.
+func (b *AddressBook) candidates(exclude map[string]bool, limit int) []KnownAddress {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	var reached, unreached []KnownAddress
+	for id, addr := range b.addresses {
+		if exclude[id] || !addr.dialable(now) {
+			continue
+		}
+		if addr.LastSuccess.IsZero() {
+			unreached = append(unreached, *addr)
+		} else {
+			reached = append(reached, *addr)
+		}
+	}
+
+	rand.Shuffle(len(reached), func(i, j int) { reached[i], reached[j] = reached[j], reached[i] })
+	rand.Shuffle(len(unreached), func(i, j int) { unreached[i], unreached[j] = unreached[j], unreached[i] })
+
+	result := append(reached, unreached...)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// shareable returns up to limit random addresses of nodes we have reached,
+// except the node asking
+func (b *AddressBook) shareable(except string, limit int) []PeerAddress {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	shared := make([]PeerAddress, 0)
+	for id, addr := range b.addresses {
+		if id != except && !addr.LastSuccess.IsZero() {
+			shared = append(shared, PeerAddress{ID: id, Address: addr.Address})
+		}
+	}
+
+	rand.Shuffle(len(shared), func(i, j int) { shared[i], shared[j] = shared[j], shared[i] })
+	if len(shared) > limit {
+		shared = shared[:limit]
+	}
+	return shared
+}
+
+// evict makes room for a new address by dropping the never reached address
+// with the most failures, oldest first; callers must hold the lock
+func (b *AddressBook) evict(now time.Time) bool {
+	var worst *KnownAddress
+	for _, addr := range b.addresses {
+		if !addr.LastSuccess.IsZero() {
+			continue
+		}
+		if worst == nil || addr.Failures > worst.Failures ||
+			(addr.Failures == worst.Failures && addr.LastSeen.Before(worst.LastSeen)) {
+			worst = addr
+		}
+	}
+
+	if worst == nil {
+		return false
+	}
+	delete(b.addresses, worst.ID)
+	return true
+}
+
+// validPeerAddress reports whether address is a dialable host:port
+func validPeerAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || port == "" || port == "0" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsUnspecified()
+}
+
+// saveFileAtomic replaces the file at path with data, so a crash leaves
+// either the old or the new contents
+func saveFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// PeerAddress is a node shared in a peer list
+type PeerAddress struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// PeerListPayload shares known nodes. With Request set it also asks the
+// receiver to reply with its own list.
+type PeerListPayload struct {
+	Request bool          `json:"request,omitempty"`
+	Peers   []PeerAddress `json:"peers"`
+}
+
+// Discovery keeps the node connected to enough peers. It learns addresses
+// from handshakes and peer_list exchanges, and dials addresses from the
+// address book, falling back to the seed nodes, until the target count of
+// outbound peers is reached. Only outbound peers count, since inbound ones
+// are chosen by the remote side.
+type Discovery struct {
+	network     *Network
+	book        *AddressBook
+	seeds       []string
+	targetPeers int
+	interval    time.Duration
+	dialing     map[string]bool      // node IDs, or addresses of seeds, being dialled
+	seedTried   map[string]time.Time // last dial of each seed
+	listSent    map[string]time.Time // last peer list sent to each peer
+	listTaken   map[string]time.Time // last unrequested peer list accepted from each peer
+	listAsked   map[string]bool      // peers asked for their list, until they reply
+	stop        chan struct{}
+	mutex       sync.Mutex
+}
+
+// NewDiscovery creates peer discovery for n using book. Seeds are
+// "host:port" or "nodeID@host:port" addresses dialled when no address in
+// the book can be; SetSeeds replaces them.
+func NewDiscovery(n *Network, book *AddressBook, seeds []string) *Discovery {
+	d := &Discovery{
+		network:     n,
+		book:        book,
+		seeds:       seeds,
+		targetPeers: DefaultTargetPeers,
+		interval:    DefaultDiscoveryInterval,
+		dialing:     make(map[string]bool),
+		seedTried:   make(map[string]time.Time),
+		listSent:    make(map[string]time.Time),
+		listTaken:   make(map[string]time.Time),
+		listAsked:   make(map[string]bool),
+	}
+
+	n.Handle(MessageHandshake, d.handleHandshake)
+	n.Handle(MessagePeerList, d.handlePeerList)
+
+	return d
+}
+
+// SetSeeds replaces the seed nodes. Every seed must be a "host:port" or
+// "nodeID@host:port" address.
+func (d *Discovery) SetSeeds(seeds []string) error {
+	for _, seed := range seeds {
+		if _, address := splitPeerAddress(seed); !validPeerAddress(address) {
+			return fmt.Errorf("invalid seed address %q", seed)
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.seeds = append([]string(nil), seeds...)
+	return nil
+}
+
+// SetTargetPeers sets how many outbound peers discovery maintains
+func (d *Discovery) SetTargetPeers(target int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.targetPeers = target
+}
+
+// Start begins maintaining the target peer count
+func (d *Discovery) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stop != nil {
+		return errors.New("discovery already running")
+	}
+
+	d.stop = make(chan struct{})
+	go d.loop(d.interval, d.stop)
+	return nil
+}
+
+// Stop ends discovery and saves the address book
+func (d *Discovery) Stop() error {
+	d.mutex.Lock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+	d.mutex.Unlock()
+
+	return d.book.Save()
+}
+
+// loop checks the peer count every interval until stopped
+func (d *Discovery) loop(interval time.Duration, stop chan struct{}) {
+	d.tick()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick dials new peers when below the target, asks peers for more
+// addresses when the book runs short, and saves the book
+func (d *Discovery) tick() {
+	peers := d.network.GetPeers()
+	now := time.Now()
+
+	d.mutex.Lock()
+	exclude := map[string]bool{d.network.GetNodeID(): true}
+	outbound := 0
+	connected := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if p.Status == PeerStatusDisconnected {
+			continue
+		}
+		exclude[p.ID] = true
+		if !p.Inbound {
+			outbound++
+		}
+		if p.Status == PeerStatusConnected {
+			connected = append(connected, p.ID)
+		}
+	}
+	for id := range d.dialing {
+		exclude[id] = true
+	}
+
+	// Forget per-peer state of peers that have gone away
+	for _, state := range []map[string]time.Time{d.listSent, d.listTaken} {
+		for id := range state {
+			if !exclude[id] {
+				delete(state, id)
+			}
+		}
+	}
+	for id := range d.listAsked {
+		if !exclude[id] {
+			delete(d.listAsked, id)
+		}
+	}
+
+	need := d.targetPeers - outbound - len(d.dialing)
+	var dials []KnownAddress
+	var seeds []string
+	var ask []string
+	if need > 0 {
+		dials = d.book.candidates(exclude, need)
+		for _, addr := range dials {
+			d.dialing[addr.ID] = true
+		}
+
+		if len(dials) == 0 {
+			seeds = d.seedsToDial(now, exclude, need)
+		}
+
+		if len(dials) < need {
+			for _, id := range connected {
+				if !d.listAsked[id] && now.Sub(d.listSent[id]) >= peerListInterval {
+					d.listAsked[id] = true
+					d.listSent[id] = now
+					ask = append(ask, id)
+				}
+			}
+		}
+	}
+	d.mutex.Unlock()
+
+	for _, addr := range dials {
+		go d.dial(addr.ID, addr.Address, addr.Source)
+	}
+	for _, seed := range seeds {
+		go d.dialSeed(seed)
+	}
+	for _, id := range ask {
+		d.sendPeerList(id, true)
+	}
+
+	d.book.Save() //nolint:errcheck
+}
+
+// seedsToDial returns up to limit seeds not connected, being dialled or
+// dialled recently; callers must hold the lock
+func (d *Discovery) seedsToDial(now time.Time, exclude map[string]bool, limit int) []string {
+	seeds := make([]string, 0)
+	for _, seed := range d.seeds {
+		if len(seeds) == limit {
+			break
+		}
+
+		id, address := splitPeerAddress(seed)
+		if exclude[id] || d.dialing[address] || now.Sub(d.seedTried[seed]) < retryBackoff {
+			continue
+		}
+
+		d.seedTried[seed] = now
+		d.dialing[address] = true
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+// dial connects to a node from the address book, where it was learned from source
+func (d *Discovery) dial(id, address, source string) {
+	d.book.markAttempt(id)
+
+	_, err := d.network.Connect(id + "@" + address)
+	switch {
+	case err == nil:
+		d.book.markSuccess(id, address, source)
+	case !errors.Is(err, ErrAlreadyConnected) && !errors.Is(err, ErrTooManyPeers):
+		d.book.markFailure(id)
+	}
+
+	d.mutex.Lock()
+	delete(d.dialing, id)
+	d.mutex.Unlock()
+}
+
+// dialSeed connects to a seed node
+func (d *Discovery) dialSeed(seed string) {
+	_, address := splitPeerAddress(seed)
+
+	peer, err := d.network.Connect(seed)
+	if err == nil {
+		d.book.markSuccess(peer.ID, address, SourceSeed)
+	}
+
+	d.mutex.Lock()
+	delete(d.dialing, address)
+	d.mutex.Unlock()
+}
+
+// handleHandshake records the address a peer listens on and sends it the
+// nodes we know
+func (d *Discovery) handleHandshake(peer *Peer, msg *Message) {
+	if !peer.Inbound {
+		d.book.markSuccess(peer.ID, peer.Address, SourceHandshake)
+	} else if address := d.network.listenAddressOf(peer.ID); address != "" {
+		// An inbound peer has only claimed this address; it is verified
+		// when we dial it
+		d.book.Add(peer.ID, address, SourceHandshake)
+	}
+
+	d.mutex.Lock()
+	short := d.book.Len() < d.targetPeers
+	send := time.Since(d.listSent[peer.ID]) >= peerListInterval
+	if send {
+		d.listSent[peer.ID] = time.Now()
+		d.listAsked[peer.ID] = short
+	}
+	d.mutex.Unlock()
+
+	if send {
+		d.sendPeerList(peer.ID, short)
+	}
+}
+
+// handlePeerList adds the nodes a peer shares to the address book and
+// replies to requests for ours
+func (d *Discovery) handlePeerList(peer *Peer, msg *Message) {
+	var list PeerListPayload
+	if err := json.Unmarshal(msg.Payload, &list); err != nil || len(list.Peers) > MaxPeerListEntries {
+		d.network.Misbehaving(peer.ID, ScoreMalformedMessage, "malformed peer_list")
+		return
+	}
+
+	now := time.Now()
+	d.mutex.Lock()
+	// Take a list we asked for, or one unrequested list per interval, so a
+	// peer cannot flood the book
+	accept := d.listAsked[peer.ID] || now.Sub(d.listTaken[peer.ID]) >= peerListInterval
+	if accept && !d.listAsked[peer.ID] {
+		d.listTaken[peer.ID] = now
+	}
+	d.listAsked[peer.ID] = false
+
+	reply := list.Request && now.Sub(d.listSent[peer.ID]) >= peerListInterval
+	if reply {
+		d.listSent[peer.ID] = now
+	}
+	d.mutex.Unlock()
+
+	if accept {
+		self := d.network.GetNodeID()
+		for _, p := range list.Peers {
+			if p.ID != self && p.ID != peer.ID {
+				d.book.Add(p.ID, p.Address, SourcePeerList)
+			}
+		}
+	}
+
+	if reply {
+		d.sendPeerList(peer.ID, false)
+	}
+}
+
+// sendPeerList sends a peer the nodes we have reached
+func (d *Discovery) sendPeerList(peerID string, request bool) {
+	list := &PeerListPayload{Request: request, Peers: d.book.shareable(peerID, MaxPeerListEntries)}
+	d.network.Send(peerID, MessagePeerList, list) //nolint:errcheck
+}
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startDiscoveryNode starts a network with discovery bootstrapped from seeds
+func startDiscoveryNode(t *testing.T, seeds []string) (*Network, *Discovery) {
+	n, err := NewNetwork("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	book, _ := NewAddressBook("")
+	d := NewDiscovery(n, book, seeds)
+	d.interval = 20 * time.Millisecond
+	if err := n.Start(); err != nil {
+		t.Fatalf("Failed to start network: %v", err)
+	}
+
+	return n, d
+}
+
+func isConnectedTo(n *Network, peerID string) bool {
+	for _, p := range n.GetConnectedPeers() {
+		if p.ID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiscoveryFindsPeersThroughSeed(t *testing.T) {
+	seed, _ := startDiscoveryNode(t, nil)
+	defer seed.Stop()
+
+	other, _ := startDiscoveryNode(t, nil)
+	defer other.Stop()
+
+	// The seed has reached other, so it shares other's address
+	if _, err := seed.Connect(other.listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	client, discovery := startDiscoveryNode(t, []string{seed.GetNodeID() + "@" + seed.listener.Addr().String()})
+	defer client.Stop()
+	discovery.Start()
+	defer discovery.Stop()
+
+	waitFor(t, "connection to the seed", func() bool { return isConnectedTo(client, seed.GetNodeID()) })
+	waitFor(t, "connection to the discovered peer", func() bool { return isConnectedTo(client, other.GetNodeID()) })
+
+	addr, known := discovery.book.Get(other.GetNodeID())
+	if !known || addr.LastSuccess.IsZero() {
+		t.Errorf("Expected the discovered peer to be recorded as reached, got %+v", addr)
+	}
+}
+
+func TestDiscoveryStopsAtTargetPeers(t *testing.T) {
+	var seeds []string
+	for i := 0; i < 3; i++ {
+		n, _ := startDiscoveryNode(t, nil)
+		defer n.Stop()
+		seeds = append(seeds, n.listener.Addr().String())
+	}
+
+	client, discovery := startDiscoveryNode(t, seeds)
+	defer client.Stop()
+	discovery.SetTargetPeers(1)
+	discovery.Start()
+	defer discovery.Stop()
+
+	waitFor(t, "a connection", func() bool { return len(client.GetConnectedPeers()) > 0 })
+	time.Sleep(100 * time.Millisecond)
+
+	if count := client.GetPeerCount(); count != 1 {
+		t.Errorf("Expected 1 peer, got %d", count)
+	}
+}
+
+func TestDiscoveryUsesConfiguredSeeds(t *testing.T) {
+	seed, _ := startDiscoveryNode(t, nil)
+	defer seed.Stop()
+
+	client, discovery := startDiscoveryNode(t, nil)
+	defer client.Stop()
+
+	if err := discovery.SetSeeds([]string{"not-an-address"}); err == nil {
+		t.Error("Expected an invalid seed to be rejected")
+	}
+
+	if err := discovery.SetSeeds([]string{seed.listener.Addr().String()}); err != nil {
+		t.Fatalf("Failed to set seeds: %v", err)
+	}
+	discovery.Start()
+	defer discovery.Stop()
+
+	waitFor(t, "connection to the seed", func() bool { return isConnectedTo(client, seed.GetNodeID()) })
+}
+
+func TestDiscoveryDialKeepsAddressSource(t *testing.T) {
+	other, _ := startDiscoveryNode(t, nil)
+	defer other.Stop()
+
+	// Without discovery's handshake handler, only dial records the address
+	client, _ := NewNetwork("127.0.0.1:0")
+	book, _ := NewAddressBook("")
+	discovery := &Discovery{network: client, book: book, dialing: make(map[string]bool)}
+
+	discovery.dial(other.GetNodeID(), other.listener.Addr().String(), SourceSeed)
+	defer client.Disconnect(other.GetNodeID()) //nolint:errcheck
+
+	addr, known := book.Get(other.GetNodeID())
+	if !known || addr.Source != SourceSeed {
+		t.Errorf("Expected the address to keep source %s, got %+v", SourceSeed, addr)
+	}
+}
+
+func TestAddressBookPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	book, err := NewAddressBook(path)
+	if err != nil {
+		t.Fatalf("Failed to create address book: %v", err)
+	}
+
+	book.Add("node1", "10.0.0.1:8081", SourcePeerList)
+	book.markSuccess("node2", "10.0.0.2:8081", SourceSeed)
+	if err := book.Save(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	reopened, err := NewAddressBook(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen address book: %v", err)
+	}
+
+	if reopened.Len() != 2 {
+		t.Fatalf("Expected 2 addresses, got %d", reopened.Len())
+	}
+
+	addr, _ := reopened.Get("node2")
+	if addr.Address != "10.0.0.2:8081" || addr.Source != SourceSeed || addr.LastSuccess.IsZero() {
+		t.Errorf("Expected node2 to survive a restart, got %+v", addr)
+	}
+}
+
+func TestAddressBookKeepsReachedAddress(t *testing.T) {
+	book, _ := NewAddressBook("")
+	book.markSuccess("node1", "10.0.0.1:8081", SourceHandshake)
+
+	if book.Add("node1", "10.6.6.6:8081", SourcePeerList) {
+		t.Error("Expected a peer list not to replace a reached address")
+	}
+
+	if !book.Add("node1", "10.0.0.9:8081", SourceHandshake) {
+		t.Error("Expected the node itself to update its address")
+	}
+
+	for _, bad := range []string{"10.0.0.1", "0.0.0.0:8081", ":8081", "10.0.0.1:0"} {
+		if book.Add("node2", bad, SourcePeerList) {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestAddressBookBacksOffFailedAddresses(t *testing.T) {
+	book, _ := NewAddressBook("")
+	book.Add("node1", "10.0.0.1:8081", SourcePeerList)
+	book.markAttempt("node1")
+	book.markFailure("node1")
+
+	if len(book.candidates(nil, 10)) != 0 {
+		t.Error("Expected a failed address to wait before being dialled again")
+	}
+
+	for i := 1; i < maxAddressFailures; i++ {
+		book.markFailure("node1")
+	}
+	if book.Len() != 0 {
+		t.Error("Expected an address that keeps failing to be forgotten")
+	}
+}
+
+func TestAdvertisedAddress(t *testing.T) {
+	tests := []struct {
+		conn, advertised, expected string
+	}{
+		{"10.0.0.5:51234", "10.0.0.5:8081", "10.0.0.5:8081"},
+		{"10.0.0.5:51234", "0.0.0.0:8081", "10.0.0.5:8081"},
+		{"10.0.0.5:51234", ":8081", "10.0.0.5:8081"},
+		{"10.0.0.5:51234", "127.0.0.1:0", ""},
+		{"10.0.0.5:51234", "garbage", ""},
+	}
+
+	for _, tt := range tests {
+		if got := advertisedAddress(tt.conn, tt.advertised); got != tt.expected {
+			t.Errorf("Expected %q for %q, got %q", tt.expected, tt.advertised, got)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package network
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// InvTypeTransaction identifies a transaction in an inventory
+	InvTypeTransaction = "tx"
+
+	// MaxInvItems is the most items in one inv or get_data message
+	MaxInvItems = 1000
+
+	// DefaultTxRate and DefaultTxBurst limit the unrequested transactions
+	// accepted from one peer: DefaultTxRate per second on average, bursts
+	// of DefaultTxBurst
+	DefaultTxRate  = 50
+	DefaultTxBurst = 200
+
+	// seenCacheSize bounds the IDs remembered to suppress duplicates
+	seenCacheSize = 100000
+	// txCacheSize bounds the transactions kept to answer get_data
+	txCacheSize = 5000
+	// peerKnownSize bounds the IDs remembered per peer
+	peerKnownSize = 10000
+	// getDataTimeout is how long to wait for a requested item before
+	// asking another peer that announced it
+	getDataTimeout = 30 * time.Second
+)
+
+// ErrTransactionNotReady is returned by a transaction handler for a
+// transaction that may become valid later, such as one whose earlier
+// nonces have not arrived. It is not relayed or scored, and is fetched
+// again if announced later.
+var ErrTransactionNotReady = errors.New("transaction not ready")
+
+// InvItem names an item a node has
+type InvItem struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// InvPayload announces items, or requests them in a get_data message
+type InvPayload struct {
+	Items []InvItem `json:"items"`
+}
+
+// itemCache remembers up to capacity items, evicting the oldest first
+type itemCache struct {
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+// cacheEntry is an item in an itemCache
+type cacheEntry struct {
+	id    string
+	value json.RawMessage
+}
+
+// newItemCache creates an empty cache
+func newItemCache(capacity int) *itemCache {
+	return &itemCache{capacity: capacity, items: make(map[string]*list.Element), order: list.New()}
+}
+
+// add stores an item and reports whether it was new
+func (c *itemCache) add(id string, value json.RawMessage) bool {
+	if _, exists := c.items[id]; exists {
+		return false
+	}
+
+	c.items[id] = c.order.PushBack(&cacheEntry{id: id, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Front()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).id)
+	}
+	return true
+}
+
+// remove forgets an item
+func (c *itemCache) remove(id string) {
+	if elem, exists := c.items[id]; exists {
+		c.order.Remove(elem)
+		delete(c.items, id)
+	}
+}
+
+// has reports whether an item is cached
+func (c *itemCache) has(id string) bool {
+	_, exists := c.items[id]
+	return exists
+}
+
+// get returns the value of a cached item
+func (c *itemCache) get(id string) (json.RawMessage, bool) {
+	elem, exists := c.items[id]
+	if !exists {
+		return nil, false
+	}
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// rateLimiter is a token bucket
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is available
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// gossipPeer is what gossip tracks about one peer
+type gossipPeer struct {
+	known   *itemCache
+	limiter *rateLimiter
+}
+
+// Gossip relays transactions across the network. New transactions are
+// announced by ID with inv messages; peers fetch the ones they have not
+// seen with get_data. Each transaction is accepted and relayed once, and
+// never announced to a peer known to have it.
+type Gossip struct {
+	network   *Network
+	seen      *itemCache
+	txs       *itemCache
+	requested map[string]time.Time
+	peers     map[string]*gossipPeer
+	txRate    float64
+	txBurst   float64
+	dropped   int // unrequested transactions dropped by rate limits
+	onTx      func(peer *Peer, id string, tx json.RawMessage) error
+	mutex     sync.Mutex
+}
+
+// NewGossip creates transaction gossip on n and registers its handlers
+func NewGossip(n *Network) *Gossip {
+	g := &Gossip{
+		network:   n,
+		seen:      newItemCache(seenCacheSize),
+		txs:       newItemCache(txCacheSize),
+		requested: make(map[string]time.Time),
+		peers:     make(map[string]*gossipPeer),
+		txRate:    DefaultTxRate,
+		txBurst:   DefaultTxBurst,
+	}
+
+	n.Handle(MessageInventory, g.handleInventory)
+	n.Handle(MessageGetData, g.handleGetData)
+	n.Handle(MessageTransaction, g.handleTransaction)
+
+	n.mutex.Lock()
+	n.gossip = g
+	n.mutex.Unlock()
+
+	return g
+}
+
+// SetTransactionHandler registers a callback for each new transaction
+// received from a peer. Returning an error rejects the transaction so it is
+// not relayed, and scores the peer that sent it unless the error is
+// ErrTransactionNotReady.
+func (g *Gossip) SetTransactionHandler(handler func(peer *Peer, id string, tx json.RawMessage) error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.onTx = handler
+}
+
+// SetTransactionRate sets the per-peer limit on unrequested transactions
+// for peers seen from now on. Transactions we asked for with get_data are
+// never limited.
+func (g *Gossip) SetTransactionRate(perSecond float64, burst int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.txRate = perSecond
+	g.txBurst = float64(burst)
+}
+
+// AnnounceTransaction publishes a local transaction and returns its ID
+func (g *Gossip) AnnounceTransaction(tx interface{}) (string, error) {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > MaxMessageSize(MessageTransaction)-maxFromSize-16 {
+		return "", ErrMessageTooLarge
+	}
+
+	id := TransactionID(data)
+
+	g.mutex.Lock()
+	g.seen.add(id, nil)
+	g.txs.add(id, data)
+	g.mutex.Unlock()
+
+	g.relay(id, "")
+	return id, nil
+}
+
+// HasSeen reports whether a transaction ID has been seen
+func (g *Gossip) HasSeen(id string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.seen.has(id)
+}
+
+// GetStatus returns a summary of the gossip state
+func (g *Gossip) GetStatus() map[string]interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return map[string]interface{}{
+		"seen":        g.seen.order.Len(),
+		"held":        g.txs.order.Len(),
+		"peers":       len(g.peers),
+		"rateLimited": g.dropped,
+	}
+}
+
+// TransactionID returns the gossip ID of an encoded transaction
+func TransactionID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// handleInventory requests announced transactions we have not seen
+func (g *Gossip) handleInventory(peer *Peer, msg *Message) {
+	var inv InvPayload
+	if err := json.Unmarshal(msg.Payload, &inv); err != nil || len(inv.Items) > MaxInvItems {
+		g.network.Misbehaving(peer.ID, ScoreMalformedMessage, "malformed inv")
+		return
+	}
+
+	now := time.Now()
+	wanted := make([]InvItem, 0)
+
+	g.mutex.Lock()
+	if len(g.requested) > seenCacheSize {
+		for id, at := range g.requested {
+			if now.Sub(at) >= getDataTimeout {
+				delete(g.requested, id)
+			}
+		}
+	}
+
+	state := g.peer(peer.ID)
+	for _, item := range inv.Items {
+		if item.Type != InvTypeTransaction {
+			continue
+		}
+
+		state.known.add(item.ID, nil)
+		if g.seen.has(item.ID) {
+			continue
+		}
+		if at, pending := g.requested[item.ID]; pending && now.Sub(at) < getDataTimeout {
+			continue
+		}
+
+		g.requested[item.ID] = now
+		wanted = append(wanted, item)
+	}
+	g.mutex.Unlock()
+
+	if len(wanted) > 0 {
+		g.network.Send(peer.ID, MessageGetData, &InvPayload{Items: wanted}) //nolint:errcheck
+	}
+}
+
+// handleGetData sends the requested transactions we still hold
+func (g *Gossip) handleGetData(peer *Peer, msg *Message) {
+	var req InvPayload
+	if err := json.Unmarshal(msg.Payload, &req); err != nil || len(req.Items) > MaxInvItems {
+		g.network.Misbehaving(peer.ID, ScoreMalformedMessage, "malformed get_data")
+		return
+	}
+
+	for _, item := range req.Items {
+		if item.Type != InvTypeTransaction {
+			continue
+		}
+
+		g.mutex.Lock()
+		tx, exists := g.txs.get(item.ID)
+		if exists {
+			g.peer(peer.ID).known.add(item.ID, nil)
+		}
+		g.mutex.Unlock()
+
+		if exists {
+			g.network.Send(peer.ID, MessageTransaction, tx) //nolint:errcheck
+		}
+	}
+}
+
+// handleTransaction accepts a transaction the first time it is seen and
+// relays it to every peer that does not have it yet. Transactions pushed
+// without being requested are rate limited per peer.
+func (g *Gossip) handleTransaction(peer *Peer, msg *Message) {
+	// IDs are taken over compact JSON, as sent by AnnounceTransaction, so
+	// the same transaction has one ID however a peer formatted it
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, msg.Payload); err != nil {
+		g.network.Misbehaving(peer.ID, ScoreMalformedMessage, "malformed transaction")
+		return
+	}
+	tx := json.RawMessage(compact.Bytes())
+	id := TransactionID(tx)
+
+	g.mutex.Lock()
+	state := g.peer(peer.ID)
+	_, requested := g.requested[id]
+	if !requested && !state.limiter.allow(time.Now()) {
+		g.dropped++
+		g.mutex.Unlock()
+		g.network.Misbehaving(peer.ID, ScoreRateLimited, "transaction rate limit exceeded")
+		return
+	}
+
+	state.known.add(id, nil)
+	delete(g.requested, id)
+	if !g.seen.add(id, nil) {
+		g.mutex.Unlock()
+		return
+	}
+	handler := g.onTx
+	g.mutex.Unlock()
+
+	if handler != nil {
+		if err := handler(peer, id, tx); errors.Is(err, ErrTransactionNotReady) {
+			g.mutex.Lock()
+			g.seen.remove(id)
+			g.mutex.Unlock()
+			return
+		} else if err != nil {
+			g.network.Misbehaving(peer.ID, ScoreInvalidTransaction, "invalid transaction")
+			return
+		}
+	}
+
+	g.mutex.Lock()
+	g.txs.add(id, tx)
+	g.mutex.Unlock()
+
+	g.relay(id, peer.ID)
+}
+
+// relay announces a transaction to connected peers not known to have it
+func (g *Gossip) relay(id, from string) {
+	peers := g.network.GetConnectedPeers()
+	connected := make(map[string]bool, len(peers))
+	targets := make([]string, 0, len(peers))
+
+	g.mutex.Lock()
+	for _, p := range peers {
+		connected[p.ID] = true
+		if p.ID == from {
+			continue
+		}
+
+		state := g.peer(p.ID)
+		if state.known.add(id, nil) {
+			targets = append(targets, p.ID)
+		}
+	}
+
+	// Forget peers that have gone away
+	for peerID := range g.peers {
+		if !connected[peerID] && peerID != from {
+			delete(g.peers, peerID)
+		}
+	}
+	g.mutex.Unlock()
+
+	inv := &InvPayload{Items: []InvItem{{Type: InvTypeTransaction, ID: id}}}
+	for _, peerID := range targets {
+		g.network.Send(peerID, MessageInventory, inv) //nolint:errcheck
+	}
+}
+
+// peer returns the state of a peer, creating it on first use; callers must hold the lock
+func (g *Gossip) peer(peerID string) *gossipPeer {
+	state, exists := g.peers[peerID]
+	if !exists {
+		state = &gossipPeer{
+			known:   newItemCache(peerKnownSize),
+			limiter: &rateLimiter{rate: g.txRate, burst: g.txBurst, tokens: g.txBurst, last: time.Now()},
+		}
+		g.peers[peerID] = state
+	}
+	return state
+}
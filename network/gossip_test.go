@@ -0,0 +1,247 @@
+package network
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gossipNode is a started network with gossip that counts accepted transactions
+type gossipNode struct {
+	network  *Network
+	gossip   *Gossip
+	received map[string]int
+	mutex    sync.Mutex
+}
+
+func startGossipNode(t *testing.T) *gossipNode {
+	n, err := NewNetwork("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	node := &gossipNode{network: n, gossip: NewGossip(n), received: make(map[string]int)}
+	node.gossip.SetTransactionHandler(func(peer *Peer, id string, tx json.RawMessage) error {
+		node.mutex.Lock()
+		node.received[id]++
+		node.mutex.Unlock()
+		return nil
+	})
+
+	if err := n.Start(); err != nil {
+		t.Fatalf("Failed to start network: %v", err)
+	}
+	return node
+}
+
+func (node *gossipNode) count(id string) int {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.received[id]
+}
+
+func TestGossipRelaysThroughPeers(t *testing.T) {
+	a, b, c := startGossipNode(t), startGossipNode(t), startGossipNode(t)
+	defer a.network.Stop()
+	defer b.network.Stop()
+	defer c.network.Stop()
+
+	// a - b - c, plus a - c so c hears the transaction twice
+	a.network.Connect(b.network.listener.Addr().String())
+	b.network.Connect(c.network.listener.Addr().String())
+	a.network.Connect(c.network.listener.Addr().String())
+	waitFor(t, "peers to connect", func() bool { return len(b.network.GetConnectedPeers()) == 2 && len(c.network.GetConnectedPeers()) == 2 })
+
+	id, err := a.gossip.AnnounceTransaction(map[string]string{"txId": "tx1"})
+	if err != nil {
+		t.Fatalf("Failed to announce: %v", err)
+	}
+
+	waitFor(t, "transaction to reach c", func() bool { return c.count(id) > 0 })
+	time.Sleep(50 * time.Millisecond)
+
+	if b.count(id) != 1 || c.count(id) != 1 {
+		t.Errorf("Expected each node to accept the transaction once, got b=%d c=%d", b.count(id), c.count(id))
+	}
+
+	if a.count(id) != 0 {
+		t.Errorf("Expected the transaction not to bounce back to its origin, got %d", a.count(id))
+	}
+}
+
+func TestGossipRejectedTransactionIsNotRelayed(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	g := NewGossip(n)
+	g.SetTransactionHandler(func(*Peer, string, json.RawMessage) error { return ErrMalformedMessage })
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected}
+	n.peers[peer.ID] = peer
+	g.handleTransaction(peer, &Message{Type: MessageTransaction, Payload: json.RawMessage(`{"txId": "bad"}`)})
+
+	id := TransactionID([]byte(`{"txId":"bad"}`))
+	if !g.HasSeen(id) {
+		t.Error("Expected a rejected transaction to be remembered as seen")
+	}
+
+	if _, held := g.txs.get(id); held {
+		t.Error("Expected a rejected transaction not to be offered to peers")
+	}
+
+	if peer.Score != ScoreInvalidTransaction {
+		t.Errorf("Expected score %d for a rejected transaction, got %d", ScoreInvalidTransaction, peer.Score)
+	}
+}
+
+func TestGossipTransactionNotReadyIsForgotten(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	g := NewGossip(n)
+	g.SetTransactionHandler(func(*Peer, string, json.RawMessage) error { return ErrTransactionNotReady })
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected}
+	n.peers[peer.ID] = peer
+	g.handleTransaction(peer, &Message{Type: MessageTransaction, Payload: json.RawMessage(`{"txId":"later"}`)})
+
+	if g.HasSeen(TransactionID([]byte(`{"txId":"later"}`))) {
+		t.Error("Expected a transaction that is not ready to be fetched again later")
+	}
+
+	if peer.Score != 0 {
+		t.Errorf("Expected no score for a transaction that is not ready, got %d", peer.Score)
+	}
+}
+
+func TestGossipRateLimitsPeers(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	g := NewGossip(n)
+	g.SetTransactionRate(1, 3)
+
+	accepted := 0
+	g.SetTransactionHandler(func(*Peer, string, json.RawMessage) error {
+		accepted++
+		return nil
+	})
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected}
+	n.peers[peer.ID] = peer
+	for i := 0; i < 10; i++ {
+		payload, _ := json.Marshal(map[string]int{"n": i})
+		g.handleTransaction(peer, &Message{Type: MessageTransaction, Payload: payload})
+	}
+
+	if accepted != 3 {
+		t.Errorf("Expected the burst of 3 to be accepted, got %d", accepted)
+	}
+
+	if dropped := g.GetStatus()["rateLimited"]; dropped != 7 {
+		t.Errorf("Expected 7 transactions counted as rate limited, got %v", dropped)
+	}
+
+	if peer.Score != 7*ScoreRateLimited {
+		t.Errorf("Expected score %d for rate-limited transactions, got %d", 7*ScoreRateLimited, peer.Score)
+	}
+
+	// Transactions we asked for are neither limited nor scored
+	for i := 10; i < 20; i++ {
+		payload, _ := json.Marshal(map[string]int{"n": i})
+		g.requested[TransactionID(payload)] = time.Now()
+		g.handleTransaction(peer, &Message{Type: MessageTransaction, Payload: payload})
+	}
+	if accepted != 13 {
+		t.Errorf("Expected every requested transaction to be accepted, got %d", accepted)
+	}
+	if peer.Score != 7*ScoreRateLimited {
+		t.Errorf("Expected no score for requested transactions, got %d", peer.Score)
+	}
+
+	// Another peer has its own budget
+	payload, _ := json.Marshal(map[string]int{"n": 100})
+	g.handleTransaction(&Peer{ID: "peer2"}, &Message{Type: MessageTransaction, Payload: payload})
+	if accepted != 14 {
+		t.Errorf("Expected a different peer not to be limited, got %d", accepted)
+	}
+}
+
+func TestBroadcastAnnouncesTransactionsThroughGossip(t *testing.T) {
+	a, b := startGossipNode(t), startGossipNode(t)
+	defer a.network.Stop()
+	defer b.network.Stop()
+
+	if _, err := a.network.Connect(b.network.listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	waitFor(t, "peers to connect", func() bool { return len(b.network.GetConnectedPeers()) == 1 })
+
+	tx := map[string]string{"txId": "tx1"}
+	if err := a.network.Broadcast(MessageTransaction, tx); err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+	data, _ := json.Marshal(tx)
+	id := TransactionID(data)
+
+	if !a.gossip.HasSeen(id) {
+		t.Error("Expected a broadcast transaction to be recorded by gossip")
+	}
+
+	waitFor(t, "transaction to reach b", func() bool { return b.count(id) > 0 })
+
+	// A second broadcast of the same transaction is suppressed
+	a.network.Broadcast(MessageTransaction, tx) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+	if b.count(id) != 1 {
+		t.Errorf("Expected the transaction to be delivered once, got %d", b.count(id))
+	}
+}
+
+func TestGossipDoesNotScoreRequestedTransactions(t *testing.T) {
+	a, b := startGossipNode(t), startGossipNode(t)
+	defer a.network.Stop()
+	defer b.network.Stop()
+
+	if _, err := a.network.Connect(b.network.listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	waitFor(t, "peers to connect", func() bool { return len(b.network.GetConnectedPeers()) == 1 })
+
+	// Well past the burst, but every transaction is one b asked for
+	ids := make([]string, 0, 2*DefaultTxBurst)
+	for i := 0; i < 2*DefaultTxBurst; i++ {
+		id, err := a.gossip.AnnounceTransaction(map[string]int{"n": i})
+		if err != nil {
+			t.Fatalf("Failed to announce: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	waitFor(t, "transactions to reach b", func() bool {
+		for _, id := range ids {
+			if b.count(id) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	if b.network.IsBanned(a.network.GetNodeID()) {
+		t.Error("Expected a peer answering our requests not to be banned")
+	}
+
+	if dropped := b.gossip.GetStatus()["rateLimited"]; dropped != 0 {
+		t.Errorf("Expected no requested transaction to be rate limited, got %v", dropped)
+	}
+}
+
+func TestItemCacheEvictsOldest(t *testing.T) {
+	cache := newItemCache(2)
+	cache.add("a", nil)
+	cache.add("b", nil)
+	cache.add("c", nil)
+
+	if cache.has("a") || !cache.has("b") || !cache.has("c") {
+		t.Error("Expected the oldest item to be evicted")
+	}
+
+	if cache.add("b", nil) {
+		t.Error("Expected adding a cached item to report it was not new")
+	}
+}
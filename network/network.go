@@ -1,7 +1,9 @@
 package network
 
 import (
-	"crypto/rand"
+	"bufio"
+	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -15,12 +17,18 @@ import (
 type MessageType string
 
 const (
-	MessageHandshake    MessageType = "handshake"
-	MessageBlock        MessageType = "block"
-	MessageTransaction  MessageType = "transaction"
-	MessagePeerList     MessageType = "peer_list"
-	MessagePing         MessageType = "ping"
-	MessagePong         MessageType = "pong"
+	MessageHandshake   MessageType = "handshake"
+	MessageBlock       MessageType = "block"
+	MessageTransaction MessageType = "transaction"
+	MessagePeerList    MessageType = "peer_list"
+	MessagePing        MessageType = "ping"
+	MessagePong        MessageType = "pong"
+	MessageGetHeaders  MessageType = "get_headers"
+	MessageHeaders     MessageType = "headers"
+	MessageGetBlocks   MessageType = "get_blocks"
+	MessageBlocks      MessageType = "blocks"
+	MessageInventory   MessageType = "inv"
+	MessageGetData     MessageType = "get_data"
 )
 
 // PeerStatus represents the connection status of a peer
@@ -46,46 +54,94 @@ type HandshakePayload struct {
 	Version     string `json:"version"`
 	Address     string `json:"address"`
 	BlockHeight int    `json:"blockHeight"`
+	// ProtocolVersion and MinProtocolVersion are the range of wire protocol
+	// versions the sender speaks
+	ProtocolVersion    int `json:"protocolVersion"`
+	MinProtocolVersion int `json:"minProtocolVersion"`
 }
 
 // Peer represents a connected peer node in the BTNG network
 type Peer struct {
-	ID          string     `json:"id"`
-	Address     string     `json:"address"`
-	Status      PeerStatus `json:"status"`
-	Version     string     `json:"version"`
-	BlockHeight int        `json:"blockHeight"`
-	ConnectedAt time.Time  `json:"connectedAt"`
-	LastSeen    time.Time  `json:"lastSeen"`
-	conn        net.Conn
+	ID              string     `json:"id"`
+	PublicKey       string     `json:"publicKey"`
+	Address         string     `json:"address"`
+	ListenAddress   string     `json:"listenAddress"`
+	Inbound         bool       `json:"inbound"`
+	Status          PeerStatus `json:"status"`
+	Version         string     `json:"version"`
+	ProtocolVersion int        `json:"protocolVersion"`
+	BlockHeight     int        `json:"blockHeight"`
+	Score           int        `json:"score"`
+	ConnectedAt     time.Time  `json:"connectedAt"`
+	LastSeen        time.Time  `json:"lastSeen"`
+	conn            net.Conn
+	ip              string
+	scoredAt        time.Time // when Score last decayed
 }
 
-// Network manages the BTNG peer-to-peer network
+// ErrAlreadyConnected is returned when connecting to a node that already has
+// a live connection
+var ErrAlreadyConnected = errors.New("already connected to peer")
+
+// Network manages the BTNG peer-to-peer network. Peer connections are
+// encrypted with TLS 1.3 and mutually authenticated: each node's ID is
+// derived from its key, so a peer cannot claim another node's ID. Peers
+// that misbehave are scored and banned, and connections are limited per
+// direction and per IP address.
 type Network struct {
 	nodeID      string
+	key         ed25519.PrivateKey
+	tlsConfig   *tls.Config
 	listenAddr  string
 	peers       map[string]*Peer
 	listener    net.Listener
 	isRunning   bool
 	version     string
 	blockHeight int
+	handlers    map[MessageType][]func(*Peer, *Message)
 	onMessage   func(*Peer, *Message)
+	gossip      *Gossip // relays transactions, if attached
+	bans        map[string]*Ban
+	banPath     string         // file bans are saved to, if any
+	handshakes  map[string]int // inbound TLS handshakes in progress per IP
+	maxInbound  int
+	maxOutbound int
+	maxPerIP    int
 	mutex       sync.RWMutex
 }
 
-// NewNetwork creates a new BTNG network manager
+// NewNetwork creates a new BTNG network manager with a fresh node key. Use
+// NewNetworkWithKey to keep the same node ID across restarts.
 func NewNetwork(listenAddr string) (*Network, error) {
-	nodeID, err := generateNodeID()
+	key, err := GenerateNodeKey()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate node ID: %w", err)
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+
+	return NewNetworkWithKey(listenAddr, key)
+}
+
+// NewNetworkWithKey creates a new BTNG network manager whose identity is key
+func NewNetworkWithKey(listenAddr string, key ed25519.PrivateKey) (*Network, error) {
+	tlsConfig, err := newTLSConfig(key)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Network{
-		nodeID:     nodeID,
-		listenAddr: listenAddr,
-		peers:      make(map[string]*Peer),
-		isRunning:  false,
-		version:    "1.0.0",
+		nodeID:      NodeIDFromPublicKey(key.Public().(ed25519.PublicKey)),
+		key:         key,
+		tlsConfig:   tlsConfig,
+		listenAddr:  listenAddr,
+		peers:       make(map[string]*Peer),
+		handlers:    make(map[MessageType][]func(*Peer, *Message)),
+		bans:        make(map[string]*Ban),
+		handshakes:  make(map[string]int),
+		maxInbound:  DefaultMaxInbound,
+		maxOutbound: DefaultMaxOutbound,
+		maxPerIP:    DefaultMaxPerIP,
+		isRunning:   false,
+		version:     "1.0.0",
 	}, nil
 }
 
@@ -96,6 +152,15 @@ func (n *Network) SetMessageHandler(handler func(*Peer, *Message)) {
 	n.onMessage = handler
 }
 
+// Handle registers a handler for one message type. Handlers run in the
+// order they were added, after the network's own processing and before the
+// handler set with SetMessageHandler.
+func (n *Network) Handle(msgType MessageType, handler func(*Peer, *Message)) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.handlers[msgType] = append(n.handlers[msgType], handler)
+}
+
 // SetBlockHeight updates the locally advertised block height
 func (n *Network) SetBlockHeight(height int) {
 	n.mutex.Lock()
@@ -151,23 +216,35 @@ func (n *Network) Stop() error {
 	return nil
 }
 
-// Connect dials a remote peer and performs the BTNG handshake
+// Connect dials a remote peer and performs the BTNG handshake. The address
+// may be given as "nodeID@host:port" to require the peer to prove it owns
+// that node ID.
 func (n *Network) Connect(address string) (*Peer, error) {
+	expectedID, address := splitPeerAddress(address)
+
 	n.mutex.RLock()
 	for _, p := range n.peers {
-		if p.Address == address && p.Status == PeerStatusConnected {
+		if (p.Address == address || p.ID == expectedID) && p.Status == PeerStatusConnected {
 			n.mutex.RUnlock()
-			return nil, errors.New("already connected to peer")
+			return nil, ErrAlreadyConnected
 		}
 	}
+	if expectedID != "" && n.banned(expectedID, time.Now()) {
+		n.mutex.RUnlock()
+		return nil, ErrPeerBanned
+	}
+	if err := n.admit("", false); err != nil {
+		n.mutex.RUnlock()
+		return nil, err
+	}
 	n.mutex.RUnlock()
 
-	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	conn, peerID, pub, err := n.dialSecure(address, expectedID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+		return nil, err
 	}
 
-	peer, err := n.registerPeer(conn, address)
+	peer, err := n.registerPeer(conn, address, peerID, pub, false)
 	if err != nil {
 		conn.Close()
 		return nil, err
@@ -193,35 +270,27 @@ func (n *Network) Disconnect(peerID string) error {
 		return errors.New("peer not found")
 	}
 
-	if peer.conn != nil {
-		peer.conn.Close()
-	}
-
-	peer.Status = PeerStatusDisconnected
-	delete(n.peers, peerID)
-
+	n.dropPeer(peer)
 	return nil
 }
 
-// Broadcast sends a message to all connected peers
+// Broadcast sends a message to all connected peers. Once gossip is attached,
+// transactions are announced through it instead, so they are deduplicated
+// and fetched only by peers that do not have them.
 func (n *Network) Broadcast(msgType MessageType, payload interface{}) error {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
+	n.mutex.RLock()
+	gossip := n.gossip
+	n.mutex.RUnlock()
 
-	msg := &Message{
-		Type:      msgType,
-		From:      n.nodeID,
-		Timestamp: time.Now().Unix(),
-		Payload:   json.RawMessage(data),
+	if msgType == MessageTransaction && gossip != nil {
+		_, err := gossip.AnnounceTransaction(payload)
+		return err
 	}
 
-	encoded, err := json.Marshal(msg)
+	encoded, err := n.encodeMessage(msgType, payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return err
 	}
-	encoded = append(encoded, '\n')
 
 	n.mutex.RLock()
 	defer n.mutex.RUnlock()
@@ -260,23 +329,10 @@ func (n *Network) Send(peerID string, msgType MessageType, payload interface{})
 		return errors.New("peer connection is nil")
 	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	msg := &Message{
-		Type:      msgType,
-		From:      n.nodeID,
-		Timestamp: time.Now().Unix(),
-		Payload:   json.RawMessage(data),
-	}
-
-	encoded, err := json.Marshal(msg)
+	encoded, err := n.encodeMessage(msgType, payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return err
 	}
-	encoded = append(encoded, '\n')
 
 	_, err = peer.conn.Write(encoded)
 	return err
@@ -289,13 +345,14 @@ func (n *Network) GetPeers() []*Peer {
 
 	peers := make([]*Peer, 0, len(n.peers))
 	for _, p := range n.peers {
-		peers = append(peers, p)
+		snapshot := *p
+		peers = append(peers, &snapshot)
 	}
 
 	return peers
 }
 
-// GetConnectedPeers returns only peers with an active connection
+// GetConnectedPeers returns a snapshot of the peers with an active connection
 func (n *Network) GetConnectedPeers() []*Peer {
 	n.mutex.RLock()
 	defer n.mutex.RUnlock()
@@ -303,7 +360,8 @@ func (n *Network) GetConnectedPeers() []*Peer {
 	peers := make([]*Peer, 0)
 	for _, p := range n.peers {
 		if p.Status == PeerStatusConnected {
-			peers = append(peers, p)
+			snapshot := *p
+			peers = append(peers, &snapshot)
 		}
 	}
 
@@ -334,10 +392,15 @@ func (n *Network) GetStatus() map[string]interface{} {
 	n.mutex.RLock()
 	defer n.mutex.RUnlock()
 
-	connected := 0
+	connected, inbound, outbound := 0, 0, 0
 	for _, p := range n.peers {
 		if p.Status == PeerStatusConnected {
 			connected++
+			if p.Inbound {
+				inbound++
+			} else {
+				outbound++
+			}
 		}
 	}
 
@@ -349,6 +412,9 @@ func (n *Network) GetStatus() map[string]interface{} {
 		"blockHeight":    n.blockHeight,
 		"totalPeers":     len(n.peers),
 		"connectedPeers": connected,
+		"inboundPeers":   inbound,
+		"outboundPeers":  outbound,
+		"bannedCount":    len(n.bans),
 	}
 }
 
@@ -368,29 +434,73 @@ func (n *Network) acceptLoop(ln net.Listener) {
 			continue
 		}
 
-		peer, err := n.registerPeer(conn, conn.RemoteAddr().String())
-		if err != nil {
-			conn.Close()
-			continue
-		}
+		go n.acceptPeer(conn)
+	}
+}
+
+// acceptPeer authenticates an inbound connection and starts reading from it
+func (n *Network) acceptPeer(raw net.Conn) {
+	// Turn away banned addresses and excess connections before spending
+	// anything on the TLS handshake. Handshakes in progress hold a slot, so
+	// unauthenticated connections cannot get around the limits.
+	ip := hostOf(raw.RemoteAddr().String())
+	n.mutex.Lock()
+	err := n.admit(ip, true)
+	if err == nil {
+		n.handshakes[ip]++
+	}
+	n.mutex.Unlock()
+	if err != nil {
+		raw.Close()
+		return
+	}
+
+	conn := tls.Server(raw, n.tlsConfig)
+	peerID, pub, err := secureConn(conn)
+
+	n.mutex.Lock()
+	if n.handshakes[ip]--; n.handshakes[ip] <= 0 {
+		delete(n.handshakes, ip)
+	}
+	n.mutex.Unlock()
 
-		// Greet the inbound peer so it can mark us as connected
-		n.sendHandshake(peer) //nolint:errcheck
+	if err != nil || peerID == n.nodeID {
+		conn.Close()
+		return
+	}
 
-		go n.readLoop(peer)
+	peer, err := n.registerPeer(conn, raw.RemoteAddr().String(), peerID, pub, true)
+	if err != nil {
+		conn.Close()
+		return
 	}
+
+	// Greet the inbound peer so it can mark us as connected
+	n.sendHandshake(peer) //nolint:errcheck
+
+	n.readLoop(peer)
 }
 
-// readLoop reads newline-delimited JSON messages from a peer connection
+// readLoop reads framed messages from a peer connection. A read or framing
+// error closes the connection, since the stream can no longer be trusted.
 func (n *Network) readLoop(peer *Peer) {
-	decoder := json.NewDecoder(peer.conn)
+	reader := bufio.NewReader(peer.conn)
 
 	for {
-		var msg Message
-		if err := decoder.Decode(&msg); err != nil {
+		msg, err := readFrame(reader)
+		if err == nil && msg.From != peer.ID {
+			// The sender must be the node authenticated on this connection
+			err = ErrPeerIDMismatch
+		}
+		if err != nil {
+			peer.conn.Close()
 			n.mutex.Lock()
-			if p, exists := n.peers[peer.ID]; exists {
+			// The entry may already belong to a newer connection from the same node
+			if p, exists := n.peers[peer.ID]; exists && p == peer {
 				p.Status = PeerStatusDisconnected
+				if isProtocolError(err) {
+					n.penalize(p, ScoreMalformedMessage, err.Error())
+				}
 			}
 			n.mutex.Unlock()
 			return
@@ -402,72 +512,210 @@ func (n *Network) readLoop(peer *Peer) {
 		}
 		n.mutex.Unlock()
 
-		n.handleMessage(peer, &msg)
+		n.handleMessage(peer, msg)
 	}
 }
 
-// handleMessage dispatches an incoming message
+// handleMessage dispatches an incoming message. Until the handshake
+// completes, anything but a handshake is dropped and scored.
 func (n *Network) handleMessage(peer *Peer, msg *Message) {
+	if msg.Type != MessageHandshake && !n.handshakeDone(peer) {
+		n.Misbehaving(peer.ID, ScoreMalformedMessage, "message before handshake")
+		return
+	}
+
 	switch msg.Type {
 	case MessageHandshake:
 		var payload HandshakePayload
-		if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-			n.mutex.Lock()
-			if p, exists := n.peers[peer.ID]; exists {
-				p.Version = payload.Version
-				p.BlockHeight = payload.BlockHeight
-				p.Status = PeerStatusConnected
-			}
-			n.mutex.Unlock()
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.NodeID != peer.ID {
+			n.Misbehaving(peer.ID, ScoreMalformedMessage, "invalid handshake")
+			n.removePeer(peer.ID)
+			return
+		}
+
+		version, err := negotiateVersion(payload.ProtocolVersion, payload.MinProtocolVersion)
+		if err != nil {
+			n.removePeer(peer.ID)
+			return
 		}
 
+		n.mutex.Lock()
+		if p, exists := n.peers[peer.ID]; exists {
+			p.Version = payload.Version
+			p.ListenAddress = advertisedAddress(p.Address, payload.Address)
+			p.ProtocolVersion = version
+			p.BlockHeight = payload.BlockHeight
+			p.Status = PeerStatusConnected
+		}
+		n.mutex.Unlock()
+
 	case MessagePing:
 		n.Send(peer.ID, MessagePong, map[string]int64{"timestamp": time.Now().Unix()}) //nolint:errcheck
 	}
 
-	// Forward to user-supplied handler
 	n.mutex.RLock()
+	handlers := n.handlers[msg.Type]
 	handler := n.onMessage
 	n.mutex.RUnlock()
 
+	for _, h := range handlers {
+		h(peer, msg)
+	}
+
+	// Forward to user-supplied handler
 	if handler != nil {
 		handler(peer, msg)
 	}
 }
 
+// handshakeDone reports whether a peer's handshake has been accepted on its
+// current connection
+func (n *Network) handshakeDone(peer *Peer) bool {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	p, exists := n.peers[peer.ID]
+	return exists && p == peer && p.Status == PeerStatusConnected
+}
+
 // sendHandshake sends a handshake message to a peer
 func (n *Network) sendHandshake(peer *Peer) error {
 	n.mutex.RLock()
+	address := n.listenAddr
+	if n.listener != nil {
+		// The bound address, in case the configured port was 0
+		address = n.listener.Addr().String()
+	}
 	payload := HandshakePayload{
-		NodeID:      n.nodeID,
-		Version:     n.version,
-		Address:     n.listenAddr,
-		BlockHeight: n.blockHeight,
+		NodeID:             n.nodeID,
+		Version:            n.version,
+		Address:            address,
+		BlockHeight:        n.blockHeight,
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
 	}
 	n.mutex.RUnlock()
 
 	return n.Send(peer.ID, MessageHandshake, payload)
 }
 
-// registerPeer records a new peer connection
-func (n *Network) registerPeer(conn net.Conn, address string) (*Peer, error) {
-	peerID, err := generateNodeID()
+// listenAddressOf returns the address a connected peer accepts connections
+// on, or "" if it did not advertise a usable one
+func (n *Network) listenAddressOf(peerID string) string {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	if p, exists := n.peers[peerID]; exists {
+		return p.ListenAddress
+	}
+	return ""
+}
+
+// advertisedAddress resolves the listen address a peer advertised in its
+// handshake. A peer listening on all interfaces does not know its public
+// address, so the host it connected from is used instead.
+func advertisedAddress(connAddr, advertised string) string {
+	host, port, err := net.SplitHostPort(advertised)
+	if err != nil || port == "" || port == "0" {
+		return ""
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, _, err = net.SplitHostPort(connAddr); err != nil {
+			return ""
+		}
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// peerHeights returns the block height of every connected peer
+func (n *Network) peerHeights() map[string]int {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	heights := make(map[string]int)
+	for id, p := range n.peers {
+		if p.Status == PeerStatusConnected {
+			heights[id] = p.BlockHeight
+		}
+	}
+	return heights
+}
+
+// updatePeerHeight raises the recorded block height of a peer
+func (n *Network) updatePeerHeight(peerID string, height int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if p, exists := n.peers[peerID]; exists && height > p.BlockHeight {
+		p.BlockHeight = height
+	}
+}
+
+// setPeerHeight overwrites the recorded block height of a peer
+func (n *Network) setPeerHeight(peerID string, height int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if p, exists := n.peers[peerID]; exists {
+		p.BlockHeight = height
+	}
+}
+
+// encodeMessage wraps a payload in a message from this node and frames it
+func (n *Network) encodeMessage(msgType MessageType, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate peer ID: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	return encodeFrame(&Message{
+		Type:      msgType,
+		From:      n.nodeID,
+		Timestamp: time.Now().Unix(),
+		Payload:   json.RawMessage(data),
+	})
+}
+
+// registerPeer records a new authenticated peer connection. A node may only
+// have one live connection; a disconnected entry is replaced.
+func (n *Network) registerPeer(conn net.Conn, address, peerID string, pub ed25519.PublicKey, inbound bool) (*Peer, error) {
 	peer := &Peer{
 		ID:          peerID,
+		PublicKey:   hex.EncodeToString(pub),
 		Address:     address,
+		Inbound:     inbound,
 		Status:      PeerStatusConnecting,
 		ConnectedAt: time.Now(),
 		LastSeen:    time.Now(),
 		conn:        conn,
+		ip:          hostOf(conn.RemoteAddr().String()),
 	}
 
 	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.banned(peerID, time.Now()) {
+		return nil, ErrPeerBanned
+	}
+
+	existing, exists := n.peers[peerID]
+	if exists && existing.Status != PeerStatusDisconnected {
+		return nil, ErrAlreadyConnected
+	}
+
+	if err := n.admit(peer.ip, inbound); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		// A reconnecting node keeps its misbehavior score
+		peer.Score, peer.scoredAt = existing.Score, existing.scoredAt
+		existing.conn.Close()
+	}
 	n.peers[peerID] = peer
-	n.mutex.Unlock()
+	n.prunePeers(time.Now())
 
 	return peer, nil
 }
@@ -484,12 +732,3 @@ func (n *Network) removePeer(peerID string) {
 		delete(n.peers, peerID)
 	}
 }
-
-// generateNodeID creates a cryptographically random 16-byte hex node identifier
-func generateNodeID() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(b), nil
-}
@@ -0,0 +1,175 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake of a new connection
+const handshakeTimeout = 10 * time.Second
+
+var (
+	// ErrPeerIDMismatch is returned when a peer's key does not match the node ID it was expected or claimed to have
+	ErrPeerIDMismatch = errors.New("peer key does not match node ID")
+	// ErrSelfConnection is returned when a node dials itself
+	ErrSelfConnection = errors.New("connected to self")
+)
+
+// GenerateNodeKey creates a new node identity key
+func GenerateNodeKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// LoadOrCreateNodeKey reads the node key stored at path, creating and saving
+// a new one if the file does not exist. Reusing the key keeps the node ID
+// stable across restarts.
+func LoadOrCreateNodeKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid node key file %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := GenerateNodeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	// O_EXCL so two nodes started at once cannot overwrite each other's key
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(hex.EncodeToString(key.Seed()) + "\n"); err != nil {
+		return nil, err
+	}
+	return key, file.Sync()
+}
+
+// NodeIDFromPublicKey derives a node ID from its public key, so an ID can
+// only be used by the holder of the matching private key
+func NodeIDFromPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:16])
+}
+
+// newTLSConfig creates the TLS configuration used for both sides of a peer
+// connection. Certificates are self-signed: trust comes from the node ID
+// being derived from the certificate key, not from a CA.
+func newTLSConfig(key ed25519.PrivateKey) (*tls.Config, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: NodeIDFromPublicKey(key.Public().(ed25519.PublicKey))},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates:          []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:            tls.VersionTLS13,
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyNodeCertificate,
+	}, nil
+}
+
+// verifyNodeCertificate accepts any peer presenting an ed25519 certificate.
+// The TLS handshake has already proved the peer holds the private key.
+func verifyNodeCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	if _, ok := cert.PublicKey.(ed25519.PublicKey); !ok {
+		return errors.New("peer certificate is not an ed25519 key")
+	}
+	return nil
+}
+
+// secureConn runs the TLS handshake on conn and returns the authenticated
+// node ID and public key of the peer
+func secureConn(conn *tls.Conn) (string, ed25519.PublicKey, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return "", nil, fmt.Errorf("secure handshake failed: %w", err)
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil, errors.New("peer presented no certificate")
+	}
+
+	pub := certs[0].PublicKey.(ed25519.PublicKey)
+	return NodeIDFromPublicKey(pub), pub, nil
+}
+
+// splitPeerAddress splits an address of the form "nodeID@host:port". The
+// node ID is optional; when present the peer must prove it owns that ID.
+func splitPeerAddress(address string) (string, string) {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[:at], address[at+1:]
+	}
+	return "", address
+}
+
+// dialSecure connects to address and authenticates the peer. If expectedID
+// is set, a peer with any other key is rejected.
+func (n *Network) dialSecure(address, expectedID string) (*tls.Conn, string, ed25519.PublicKey, error) {
+	raw, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	conn := tls.Client(raw, n.tlsConfig)
+	peerID, pub, err := secureConn(conn)
+	if err == nil && expectedID != "" && peerID != expectedID {
+		err = fmt.Errorf("%w: expected %s, got %s", ErrPeerIDMismatch, expectedID, peerID)
+	}
+	if err == nil && peerID == n.nodeID {
+		err = ErrSelfConnection
+	}
+	if err != nil {
+		conn.Close()
+		return nil, "", nil, err
+	}
+
+	return conn, peerID, pub, nil
+}
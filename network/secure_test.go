@@ -0,0 +1,121 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T) (*Network, string) {
+	server, err := NewNetwork("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	return server, server.listener.Addr().String()
+}
+
+func TestNodeKeyPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+
+	key, err := LoadOrCreateNodeKey(path)
+	if err != nil {
+		t.Fatalf("Failed to create node key: %v", err)
+	}
+
+	reloaded, err := LoadOrCreateNodeKey(path)
+	if err != nil {
+		t.Fatalf("Failed to load node key: %v", err)
+	}
+
+	first, _ := NewNetworkWithKey("127.0.0.1:0", key)
+	second, _ := NewNetworkWithKey("127.0.0.1:0", reloaded)
+	if first.GetNodeID() != second.GetNodeID() {
+		t.Errorf("Expected the same node ID after reload, got %s and %s", first.GetNodeID(), second.GetNodeID())
+	}
+}
+
+func TestPeersAreAuthenticated(t *testing.T) {
+	server, serverAddr := startTestServer(t)
+	defer server.Stop()
+
+	client, _ := NewNetwork("127.0.0.1:0")
+	peer, err := client.Connect(server.GetNodeID() + "@" + serverAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect with expected node ID: %v", err)
+	}
+	defer client.Disconnect(peer.ID)
+
+	if peer.ID != server.GetNodeID() {
+		t.Errorf("Expected peer ID %s, got %s", server.GetNodeID(), peer.ID)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	peers := server.GetPeers()
+	if len(peers) != 1 || peers[0].ID != client.GetNodeID() {
+		t.Errorf("Expected server to see the client's node ID, got %v", peers)
+	}
+}
+
+func TestConnectRejectsWrongNodeID(t *testing.T) {
+	server, serverAddr := startTestServer(t)
+	defer server.Stop()
+
+	impostor, _ := NewNetwork("127.0.0.1:0")
+	client, _ := NewNetwork("127.0.0.1:0")
+
+	_, err := client.Connect(impostor.GetNodeID() + "@" + serverAddr)
+	if !errors.Is(err, ErrPeerIDMismatch) {
+		t.Errorf("Expected ErrPeerIDMismatch, got %v", err)
+	}
+
+	if client.GetPeerCount() != 0 {
+		t.Errorf("Expected no peer after a failed authentication, got %d", client.GetPeerCount())
+	}
+}
+
+func TestPeerClaimingAnotherIDIsDropped(t *testing.T) {
+	server, serverAddr := startTestServer(t)
+	defer server.Stop()
+
+	client, _ := NewNetwork("127.0.0.1:0")
+	client.nodeID = "0123456789abcdef0123456789abcdef"
+
+	peer, err := client.Connect(serverAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect(peer.ID)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if len(server.GetConnectedPeers()) != 0 {
+		t.Error("Expected server to drop a peer whose handshake claims another node ID")
+	}
+}
+
+func TestPlaintextPeerIsRejected(t *testing.T) {
+	server, serverAddr := startTestServer(t)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	frame, _ := encodeFrame(&Message{Type: MessagePing, From: "x"})
+	conn.Write(frame)
+	time.Sleep(50 * time.Millisecond)
+
+	if server.GetPeerCount() != 0 {
+		t.Errorf("Expected no peer for a plaintext connection, got %d", server.GetPeerCount())
+	}
+}
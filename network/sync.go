@@ -0,0 +1,674 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/core"
+)
+
+const (
+	// MaxHeadersPerMessage is the most headers sent in one headers message
+	MaxHeadersPerMessage = 2000
+	// MaxBlocksPerMessage is the most blocks requested or sent in one message
+	MaxBlocksPerMessage = 16
+
+	// DefaultSyncInterval is how often the sync manager checks for peers ahead of us
+	DefaultSyncInterval = time.Second
+
+	// maxBlocksInFlight bounds the block requests outstanding to one peer
+	maxBlocksInFlight = 2 * MaxBlocksPerMessage
+	// maxDownloadWindow bounds how far past the chain tip bodies are fetched,
+	// so blocks held out of order stay bounded
+	maxDownloadWindow = 1024
+	// maxPendingHeaders bounds the headers held ahead of the block download
+	maxPendingHeaders = 4 * MaxHeadersPerMessage
+	// locatorDenseEntries is how many of the newest blocks a locator lists
+	// one by one before stepping back exponentially
+	locatorDenseEntries = 10
+
+	headersRequestTimeout = 10 * time.Second
+	blockRequestTimeout   = 10 * time.Second
+)
+
+var (
+	// ErrInvalidHeaders is returned for headers that fail validation
+	ErrInvalidHeaders = errors.New("invalid headers")
+	// ErrHeadersNotConnected is returned for valid headers that do not link
+	// onto the known header chain, as sent by a peer on another fork
+	ErrHeadersNotConnected = errors.New("headers do not connect to the chain")
+)
+
+// BlockHeader is a block without its transactions. Hash is the canonical
+// hash of the other fields and TxRoot commits to the transactions, so a
+// header can be checked on its own and a body fetched from any peer can be
+// checked against it.
+type BlockHeader struct {
+	Index     int    `json:"index"`
+	Timestamp int64  `json:"timestamp"`
+	PrevHash  string `json:"prevHash"`
+	Hash      string `json:"hash"`
+	Nonce     int    `json:"nonce"`
+	Validator string `json:"validator"`
+	TxRoot    string `json:"txRoot"`
+}
+
+// GetHeadersPayload requests up to Limit headers. With a Locator they
+// start after the newest listed block the receiver has, otherwise at block
+// Start.
+type GetHeadersPayload struct {
+	Start   int            `json:"start"`
+	Limit   int            `json:"limit"`
+	Locator []LocatorEntry `json:"locator,omitempty"`
+}
+
+// LocatorEntry names a block of the requester's chain
+type LocatorEntry struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+// HeadersPayload answers a GetHeadersPayload
+type HeadersPayload struct {
+	Headers []*BlockHeader `json:"headers"`
+}
+
+// GetBlocksPayload requests the blocks at the given indexes
+type GetBlocksPayload struct {
+	Indexes []int `json:"indexes"`
+}
+
+// BlocksPayload answers a GetBlocksPayload
+type BlocksPayload struct {
+	Blocks []*core.Block `json:"blocks"`
+}
+
+// blockRequest is a block body requested from a peer
+type blockRequest struct {
+	peerID      string
+	requestedAt time.Time
+}
+
+// receivedBlock is a downloaded body waiting for its turn to be appended
+type receivedBlock struct {
+	block  *core.Block
+	peerID string
+}
+
+// outgoing is a message queued while the sync lock is held
+type outgoing struct {
+	peerID  string
+	msgType MessageType
+	payload interface{}
+}
+
+// SyncManager keeps a blockchain in step with the network. When a peer
+// reports a higher block height it downloads the headers from that peer,
+// then fetches the bodies in parallel from every peer that has them,
+// checks each body against its header and appends blocks in order. It also
+// serves headers and blocks to other nodes.
+type SyncManager struct {
+	network         *Network
+	chain           *core.Blockchain
+	interval        time.Duration
+	headers         []*BlockHeader // validated headers after the chain tip
+	headerPeer      string         // peer headers are being downloaded from
+	headerRequested time.Time
+	inFlight        map[int]*blockRequest
+	received        map[int]*receivedBlock
+	onBlock         func(block *core.Block)
+	stop            chan struct{}
+	mutex           sync.Mutex
+}
+
+// NewSyncManager creates a sync manager for chain and registers its message
+// handlers on n
+func NewSyncManager(n *Network, chain *core.Blockchain) *SyncManager {
+	sm := &SyncManager{
+		network:  n,
+		chain:    chain,
+		interval: DefaultSyncInterval,
+		inFlight: make(map[int]*blockRequest),
+		received: make(map[int]*receivedBlock),
+	}
+
+	n.SetBlockHeight(chain.GetLatestBlock().Index)
+	n.Handle(MessageHandshake, func(*Peer, *Message) { sm.tick() })
+	n.Handle(MessageGetHeaders, sm.handleGetHeaders)
+	n.Handle(MessageHeaders, sm.handleHeaders)
+	n.Handle(MessageGetBlocks, sm.handleGetBlocks)
+	n.Handle(MessageBlocks, sm.handleBlocks)
+	n.Handle(MessageBlock, sm.handleNewBlock)
+
+	return sm
+}
+
+// SetBlockHandler registers a callback for each block appended to the
+// chain. It is called in chain order with the sync lock held, so it must
+// not call back into the sync manager.
+func (sm *SyncManager) SetBlockHandler(handler func(block *core.Block)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.onBlock = handler
+}
+
+// Start advertises the chain height and begins checking for peers ahead of
+// us every sync interval
+func (sm *SyncManager) Start() error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.stop != nil {
+		return errors.New("sync manager already running")
+	}
+
+	// Blocks may have been added to the chain by others since it was handed to us
+	sm.network.SetBlockHeight(sm.chain.GetLatestBlock().Index)
+	sm.stop = make(chan struct{})
+	go sm.loop(sm.interval, sm.stop)
+	return nil
+}
+
+// Stop ends background syncing
+func (sm *SyncManager) Stop() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.stop != nil {
+		close(sm.stop)
+		sm.stop = nil
+	}
+}
+
+// IsSyncing reports whether blocks are being downloaded
+func (sm *SyncManager) IsSyncing() bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return sm.headerPeer != "" || len(sm.headers) > 0
+}
+
+// GetStatus returns a summary of the sync state
+func (sm *SyncManager) GetStatus() map[string]interface{} {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	headerHeight, _ := sm.headerTip()
+	return map[string]interface{}{
+		"chainHeight":    sm.chain.GetLatestBlock().Index,
+		"headerHeight":   headerHeight,
+		"syncing":        sm.headerPeer != "" || len(sm.headers) > 0,
+		"headerPeer":     sm.headerPeer,
+		"blocksInFlight": len(sm.inFlight),
+		"blocksQueued":   len(sm.received),
+	}
+}
+
+// HeaderOf returns the header of a block
+func HeaderOf(block *core.Block) *BlockHeader {
+	return &BlockHeader{
+		Index:     block.Index,
+		Timestamp: block.Timestamp,
+		PrevHash:  block.PrevHash,
+		Hash:      block.Hash,
+		Nonce:     block.Nonce,
+		Validator: block.Validator,
+		TxRoot:    core.TxRoot(block.Transactions),
+	}
+}
+
+// validHash reports whether the header's hash matches its fields
+func (h *BlockHeader) validHash() bool {
+	return h.Hash == core.HeaderHash(h.Index, h.Timestamp, h.PrevHash, h.Nonce, h.Validator, h.TxRoot)
+}
+
+// loop runs the scheduler every interval until stop is closed
+func (sm *SyncManager) loop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sm.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick expires stale requests and issues new ones
+func (sm *SyncManager) tick() {
+	sm.mutex.Lock()
+	sends := sm.schedule(time.Now())
+	sm.mutex.Unlock()
+
+	sm.send(sends)
+}
+
+// schedule decides which headers and blocks to request; callers must hold the lock
+func (sm *SyncManager) schedule(now time.Time) []*outgoing {
+	if sm.headerPeer != "" && now.Sub(sm.headerRequested) > headersRequestTimeout {
+		sm.headerPeer = ""
+	}
+	for index, req := range sm.inFlight {
+		if now.Sub(req.requestedAt) > blockRequestTimeout {
+			delete(sm.inFlight, index)
+		}
+	}
+
+	heights := sm.network.peerHeights()
+	peerIDs := make([]string, 0, len(heights))
+	for id := range heights {
+		peerIDs = append(peerIDs, id)
+	}
+	sort.Strings(peerIDs)
+
+	var sends []*outgoing
+
+	// Headers come from the single best peer, so they form one chain, and
+	// stay a bounded distance ahead of the block download
+	tipIndex, _ := sm.headerTip()
+	limit := min(MaxHeadersPerMessage, maxPendingHeaders-len(sm.headers))
+	if sm.headerPeer == "" && limit > 0 {
+		best := ""
+		for _, id := range peerIDs {
+			if heights[id] > tipIndex && (best == "" || heights[id] > heights[best]) {
+				best = id
+			}
+		}
+
+		if best != "" {
+			sm.headerPeer = best
+			sm.headerRequested = now
+			sends = append(sends, &outgoing{best, MessageGetHeaders, sm.headersRequest(limit)})
+		}
+	}
+
+	// Bodies are spread over every peer that has them
+	load := make(map[string]int)
+	for _, req := range sm.inFlight {
+		load[req.peerID]++
+	}
+
+	requests := make(map[string][]int)
+	for i, header := range sm.headers {
+		if i >= maxDownloadWindow {
+			break
+		}
+		if sm.inFlight[header.Index] != nil || sm.received[header.Index] != nil {
+			continue
+		}
+
+		peerID := ""
+		for _, id := range peerIDs {
+			if heights[id] >= header.Index && load[id] < maxBlocksInFlight && (peerID == "" || load[id] < load[peerID]) {
+				peerID = id
+			}
+		}
+		if peerID == "" {
+			continue
+		}
+
+		load[peerID]++
+		sm.inFlight[header.Index] = &blockRequest{peerID: peerID, requestedAt: now}
+		requests[peerID] = append(requests[peerID], header.Index)
+	}
+
+	for _, id := range peerIDs {
+		indexes := requests[id]
+		for len(indexes) > 0 {
+			n := len(indexes)
+			if n > MaxBlocksPerMessage {
+				n = MaxBlocksPerMessage
+			}
+			sends = append(sends, &outgoing{id, MessageGetBlocks, &GetBlocksPayload{Indexes: indexes[:n]}})
+			indexes = indexes[n:]
+		}
+	}
+
+	return sends
+}
+
+// send delivers queued messages
+func (sm *SyncManager) send(sends []*outgoing) {
+	for _, out := range sends {
+		sm.network.Send(out.peerID, out.msgType, out.payload) //nolint:errcheck
+	}
+}
+
+// headersRequest asks for up to limit headers after the header tip, with a
+// locator so a peer on another fork answers from where the chains split;
+// callers must hold the lock
+func (sm *SyncManager) headersRequest(limit int) *GetHeadersPayload {
+	tipIndex, _ := sm.headerTip()
+
+	locator := make([]LocatorEntry, 0)
+	step := 1
+	for index := tipIndex; index >= 0; index -= step {
+		locator = append(locator, LocatorEntry{Index: index, Hash: sm.hashAt(index)})
+		if len(locator) >= locatorDenseEntries {
+			step *= 2
+		}
+	}
+	if last := locator[len(locator)-1]; last.Index != 0 {
+		locator = append(locator, LocatorEntry{Index: 0, Hash: sm.hashAt(0)})
+	}
+
+	return &GetHeadersPayload{Start: tipIndex + 1, Limit: limit, Locator: locator}
+}
+
+// hashAt returns the hash of a block in the header chain; callers must hold the lock
+func (sm *SyncManager) hashAt(index int) string {
+	if header := sm.headerAt(index); header != nil {
+		return header.Hash
+	}
+
+	block, err := sm.chain.GetBlock(index)
+	if err != nil {
+		return ""
+	}
+	return block.Hash
+}
+
+// headerTip returns the index and hash of the last known header; callers must hold the lock
+func (sm *SyncManager) headerTip() (int, string) {
+	if len(sm.headers) > 0 {
+		last := sm.headers[len(sm.headers)-1]
+		return last.Index, last.Hash
+	}
+
+	latest := sm.chain.GetLatestBlock()
+	return latest.Index, latest.Hash
+}
+
+// handleGetHeaders serves headers from the local chain
+func (sm *SyncManager) handleGetHeaders(peer *Peer, msg *Message) {
+	var req GetHeadersPayload
+	if err := json.Unmarshal(msg.Payload, &req); err != nil || req.Start < 0 {
+		sm.misbehaving(peer, ScoreMalformedMessage, "malformed get_headers")
+		return
+	}
+
+	if req.Limit <= 0 || req.Limit > MaxHeadersPerMessage {
+		req.Limit = MaxHeadersPerMessage
+	}
+
+	if len(req.Locator) > 0 {
+		// Start after the newest block we share; genesis is always shared
+		req.Start = 1
+		for _, entry := range req.Locator {
+			if block, err := sm.chain.GetBlock(entry.Index); err == nil && block.Hash == entry.Hash {
+				req.Start = entry.Index + 1
+				break
+			}
+		}
+	}
+
+	headers := make([]*BlockHeader, 0)
+	for i := req.Start; i < req.Start+req.Limit; i++ {
+		block, err := sm.chain.GetBlock(i)
+		if err != nil {
+			break
+		}
+		headers = append(headers, HeaderOf(block))
+	}
+
+	sm.network.Send(peer.ID, MessageHeaders, &HeadersPayload{Headers: headers}) //nolint:errcheck
+}
+
+// handleGetBlocks serves blocks from the local chain, as many as fit in one message
+func (sm *SyncManager) handleGetBlocks(peer *Peer, msg *Message) {
+	var req GetBlocksPayload
+	if err := json.Unmarshal(msg.Payload, &req); err != nil || len(req.Indexes) > MaxBlocksPerMessage {
+		sm.misbehaving(peer, ScoreMalformedMessage, "malformed get_blocks")
+		return
+	}
+
+	// Leave room for the message envelope
+	budget := MaxMessageSize(MessageBlocks) - 1024
+	blocks := make([]*core.Block, 0, len(req.Indexes))
+	for _, index := range req.Indexes {
+		block, err := sm.chain.GetBlock(index)
+		if err != nil {
+			continue
+		}
+
+		data, err := json.Marshal(block)
+		if err != nil || len(data)+1 > budget {
+			break
+		}
+		budget -= len(data) + 1
+		blocks = append(blocks, block)
+	}
+
+	sm.network.Send(peer.ID, MessageBlocks, &BlocksPayload{Blocks: blocks}) //nolint:errcheck
+}
+
+// handleHeaders extends the header chain with headers we asked a peer for
+func (sm *SyncManager) handleHeaders(peer *Peer, msg *Message) {
+	var payload HeadersPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || len(payload.Headers) > MaxHeadersPerMessage {
+		sm.misbehaving(peer, ScoreMalformedMessage, "malformed headers")
+		return
+	}
+
+	sm.mutex.Lock()
+	if sm.headerPeer != peer.ID {
+		// Unsolicited or late: the request was already given up on
+		sm.mutex.Unlock()
+		return
+	}
+	sm.headerPeer = ""
+
+	err := sm.appendHeaders(payload.Headers)
+	if errors.Is(err, ErrHeadersNotConnected) {
+		// The peer is on another fork, which is not misbehaviour
+		tipIndex, _ := sm.headerTip()
+		if len(sm.headers) == 0 {
+			// It split below our chain tip, which cannot be followed
+			sm.mutex.Unlock()
+			sm.network.setPeerHeight(peer.ID, tipIndex)
+			return
+		}
+
+		// Our pending headers went stale, so start again from the chain
+		// with a fresh locator
+		sm.resetDownload()
+		sm.mutex.Unlock()
+		sm.tick()
+		return
+	}
+	if err != nil {
+		sm.mutex.Unlock()
+		sm.misbehaving(peer, ScoreInvalidBlock, "invalid headers")
+		return
+	}
+
+	if len(payload.Headers) == 0 {
+		// The peer has nothing past our tip, whatever height it claimed
+		tipIndex, _ := sm.headerTip()
+		sm.mutex.Unlock()
+		sm.network.setPeerHeight(peer.ID, tipIndex)
+		return
+	}
+	sm.mutex.Unlock()
+
+	sm.network.updatePeerHeight(peer.ID, payload.Headers[len(payload.Headers)-1].Index)
+	sm.tick()
+}
+
+// appendHeaders checks headers carry their canonical hash, form one chain
+// and link onto the header chain, then appends as many as the pending limit
+// allows; callers must hold the lock
+func (sm *SyncManager) appendHeaders(headers []*BlockHeader) error {
+	for i, header := range headers {
+		if header == nil || !header.validHash() {
+			return ErrInvalidHeaders
+		}
+		if i > 0 && (header.Index != headers[i-1].Index+1 || header.PrevHash != headers[i-1].Hash) {
+			return ErrInvalidHeaders
+		}
+	}
+
+	if len(headers) > 0 {
+		tipIndex, tipHash := sm.headerTip()
+		if headers[0].Index != tipIndex+1 || headers[0].PrevHash != tipHash {
+			return ErrHeadersNotConnected
+		}
+	}
+
+	room := max(maxPendingHeaders-len(sm.headers), 0)
+	sm.headers = append(sm.headers, headers[:min(len(headers), room)]...)
+	return nil
+}
+
+// handleBlocks accepts requested bodies that match their headers and
+// appends every block that is now next in line
+func (sm *SyncManager) handleBlocks(peer *Peer, msg *Message) {
+	var payload BlocksPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || len(payload.Blocks) > MaxBlocksPerMessage {
+		sm.misbehaving(peer, ScoreMalformedMessage, "malformed blocks")
+		return
+	}
+
+	sm.mutex.Lock()
+	for _, block := range payload.Blocks {
+		if block == nil {
+			continue
+		}
+
+		req := sm.inFlight[block.Index]
+		if req == nil || req.peerID != peer.ID {
+			continue
+		}
+
+		header := sm.headerAt(block.Index)
+		if header == nil || *HeaderOf(block) != *header {
+			sm.mutex.Unlock()
+			sm.misbehaving(peer, ScoreInvalidBlock, "block does not match its header")
+			return
+		}
+
+		delete(sm.inFlight, block.Index)
+		sm.received[block.Index] = &receivedBlock{block: block, peerID: peer.ID}
+	}
+
+	offender := sm.applyReceived()
+	height := sm.chain.GetLatestBlock().Index
+	sm.mutex.Unlock()
+
+	if offender != "" {
+		sm.network.Misbehaving(offender, ScoreInvalidBlock, "block rejected by the chain")
+	}
+
+	sm.network.SetBlockHeight(height)
+	sm.tick()
+}
+
+// headerAt returns the pending header for a block index; callers must hold the lock
+func (sm *SyncManager) headerAt(index int) *BlockHeader {
+	if len(sm.headers) == 0 {
+		return nil
+	}
+
+	i := index - sm.headers[0].Index
+	if i < 0 || i >= len(sm.headers) {
+		return nil
+	}
+	return sm.headers[i]
+}
+
+// applyReceived appends downloaded blocks to the chain in order. If the
+// chain rejects a block that extends its tip, it returns the peer that sent
+// it; callers must hold the lock.
+func (sm *SyncManager) applyReceived() string {
+	for len(sm.headers) > 0 {
+		next := sm.headers[0].Index
+		received := sm.received[next]
+		if received == nil {
+			return ""
+		}
+
+		latest := sm.chain.GetLatestBlock()
+		if received.block.Index != latest.Index+1 || received.block.PrevHash != latest.Hash {
+			// The chain moved under us; start again from its tip
+			sm.resetDownload()
+			return ""
+		}
+
+		if err := sm.chain.AddBlock(received.block); err != nil {
+			sm.resetDownload()
+			return received.peerID
+		}
+		sm.blockAdded(received.block)
+
+		delete(sm.received, next)
+		sm.headers = sm.headers[1:]
+	}
+	return ""
+}
+
+// blockAdded reports a block appended to the chain; callers must hold the lock
+func (sm *SyncManager) blockAdded(block *core.Block) {
+	if sm.onBlock != nil {
+		sm.onBlock(block)
+	}
+}
+
+// resetDownload forgets every pending header and body; callers must hold the lock
+func (sm *SyncManager) resetDownload() {
+	sm.headers = nil
+	sm.headerPeer = ""
+	sm.inFlight = make(map[int]*blockRequest)
+	sm.received = make(map[int]*receivedBlock)
+}
+
+// handleNewBlock appends an announced block that extends our tip, or notes
+// the peer is ahead so the next sync round fetches what we are missing
+func (sm *SyncManager) handleNewBlock(peer *Peer, msg *Message) {
+	var block core.Block
+	if err := json.Unmarshal(msg.Payload, &block); err != nil {
+		sm.misbehaving(peer, ScoreMalformedMessage, "malformed block")
+		return
+	}
+
+	sm.network.updatePeerHeight(peer.ID, block.Index)
+
+	sm.mutex.Lock()
+	var err error
+	if len(sm.headers) == 0 && block.Index == sm.chain.GetLatestBlock().Index+1 {
+		if err = sm.chain.AddBlock(&block); err == nil {
+			sm.blockAdded(&block)
+		}
+	}
+	height := sm.chain.GetLatestBlock().Index
+	sm.mutex.Unlock()
+
+	if errors.Is(err, core.ErrInvalidBlockHash) {
+		sm.misbehaving(peer, ScoreInvalidBlock, "block hash does not match its contents")
+		return
+	}
+
+	sm.network.SetBlockHeight(height)
+}
+
+// misbehaving scores a peer that sent invalid sync data and releases the
+// requests assigned to it
+func (sm *SyncManager) misbehaving(peer *Peer, points int, reason string) {
+	sm.mutex.Lock()
+	if sm.headerPeer == peer.ID {
+		sm.headerPeer = ""
+	}
+	for index, req := range sm.inFlight {
+		if req.peerID == peer.ID {
+			delete(sm.inFlight, index)
+		}
+	}
+	sm.mutex.Unlock()
+
+	sm.network.Misbehaving(peer.ID, points, reason)
+}
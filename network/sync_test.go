@@ -0,0 +1,338 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bituncoin/Bituncoin/core"
+)
+
+// newTestChain returns a chain with blocks 1..height on top of genesis
+func newTestChain(t *testing.T, height int) *core.Blockchain {
+	chain := core.NewBlockchain()
+	for i := 1; i <= height; i++ {
+		block := &core.Block{
+			Index:        i,
+			Timestamp:    int64(1700000000 + i),
+			Transactions: []string{fmt.Sprintf("tx-%d", i)},
+			PrevHash:     chain.GetLatestBlock().Hash,
+			Validator:    "validator1",
+		}
+		block.Hash = block.CalculateHash()
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to build test chain: %v", err)
+		}
+	}
+	return chain
+}
+
+// startSyncNode starts a network serving chain
+func startSyncNode(t *testing.T, chain *core.Blockchain) (*Network, *SyncManager) {
+	n, err := NewNetwork("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	sm := NewSyncManager(n, chain)
+	sm.interval = 20 * time.Millisecond
+	if err := n.Start(); err != nil {
+		t.Fatalf("Failed to start network: %v", err)
+	}
+	sm.Start()
+
+	return n, sm
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyncFromMultiplePeers(t *testing.T) {
+	source := newTestChain(t, 100)
+
+	first, firstSync := startSyncNode(t, source)
+	defer first.Stop()
+	defer firstSync.Stop()
+
+	second, secondSync := startSyncNode(t, source)
+	defer second.Stop()
+	defer secondSync.Stop()
+
+	local := core.NewBlockchain()
+	client, clientSync := startSyncNode(t, local)
+	defer client.Stop()
+	defer clientSync.Stop()
+
+	client.Connect(first.listener.Addr().String())
+	client.Connect(second.listener.Addr().String())
+
+	waitFor(t, "chain to sync", func() bool { return local.GetBlockCount() == 101 })
+
+	if err := local.ValidateChain(); err != nil {
+		t.Errorf("Expected a valid synced chain: %v", err)
+	}
+
+	if latest := local.GetLatestBlock(); latest.Hash != source.GetLatestBlock().Hash {
+		t.Errorf("Expected tip %s, got %s", source.GetLatestBlock().Hash, latest.Hash)
+	}
+
+	if status := client.GetStatus(); status["blockHeight"] != 100 {
+		t.Errorf("Expected advertised height 100, got %v", status["blockHeight"])
+	}
+
+	waitFor(t, "sync to finish", func() bool { return !clientSync.IsSyncing() })
+}
+
+func TestSyncRejectsBodyNotMatchingHeader(t *testing.T) {
+	local := core.NewBlockchain()
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, local)
+
+	header := HeaderOf(newTestChain(t, 1).Blocks[1])
+	sm.headers = []*BlockHeader{header}
+	sm.inFlight[1] = &blockRequest{peerID: "peer1", requestedAt: time.Now()}
+
+	forged := &core.Block{Index: 1, PrevHash: header.PrevHash, Hash: header.Hash, Transactions: []string{"forged"}, Validator: header.Validator, Timestamp: header.Timestamp}
+	payload, _ := json.Marshal(&BlocksPayload{Blocks: []*core.Block{forged}})
+	sm.handleBlocks(&Peer{ID: "peer1"}, &Message{Type: MessageBlocks, Payload: payload})
+
+	if local.GetBlockCount() != 1 {
+		t.Error("Expected a body that does not match its header to be rejected")
+	}
+
+	if sm.inFlight[1] != nil {
+		t.Error("Expected the request to be released for another peer")
+	}
+}
+
+// forkHeaders returns count valid headers built on block parent of chain
+// by another validator
+func forkHeaders(chain *core.Blockchain, parent, count int) []*BlockHeader {
+	prev, _ := chain.GetBlock(parent)
+	prevHash := prev.Hash
+
+	headers := make([]*BlockHeader, 0, count)
+	for i := parent + 1; i <= parent+count; i++ {
+		block := &core.Block{
+			Index:        i,
+			Timestamp:    int64(1700000000 + i),
+			Transactions: []string{fmt.Sprintf("fork-tx-%d", i)},
+			PrevHash:     prevHash,
+			Validator:    "validator2",
+		}
+		block.Hash = block.CalculateHash()
+		headers = append(headers, HeaderOf(block))
+		prevHash = block.Hash
+	}
+	return headers
+}
+
+func TestSyncDoesNotScoreHeadersFromAnotherFork(t *testing.T) {
+	local := newTestChain(t, 2)
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, local)
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected, BlockHeight: 5}
+	n.peers[peer.ID] = peer
+	sm.headerPeer = peer.ID
+
+	// The peer split from us after block 1
+	payload, _ := json.Marshal(&HeadersPayload{Headers: forkHeaders(local, 1, 4)})
+	sm.handleHeaders(peer, &Message{Type: MessageHeaders, Payload: payload})
+
+	if len(sm.headers) != 0 {
+		t.Errorf("Expected headers from another fork not to be appended, got %d", len(sm.headers))
+	}
+
+	if peer.Score != 0 {
+		t.Errorf("Expected a peer on another fork not to be scored, got %d", peer.Score)
+	}
+
+	if peer.BlockHeight != 2 {
+		t.Errorf("Expected the peer to stop being asked for headers, got height %d", peer.BlockHeight)
+	}
+}
+
+func TestSyncRestartsHeadersWhenTheyStopConnecting(t *testing.T) {
+	local := core.NewBlockchain()
+	source := newTestChain(t, 3)
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, local)
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected, BlockHeight: 10}
+	n.peers[peer.ID] = peer
+	for i := 1; i <= 3; i++ {
+		block, _ := source.GetBlock(i)
+		sm.headers = append(sm.headers, HeaderOf(block))
+	}
+	sm.headerPeer = peer.ID
+
+	// The peer reorganised below our pending headers
+	payload, _ := json.Marshal(&HeadersPayload{Headers: forkHeaders(source, 2, 3)})
+	sm.handleHeaders(peer, &Message{Type: MessageHeaders, Payload: payload})
+
+	if peer.Score != 0 {
+		t.Errorf("Expected a peer on another fork not to be scored, got %d", peer.Score)
+	}
+
+	if len(sm.headers) != 0 {
+		t.Errorf("Expected pending headers to be dropped, got %d", len(sm.headers))
+	}
+
+	if sm.headerPeer != peer.ID {
+		t.Errorf("Expected headers to be requested again from %s, got %q", peer.ID, sm.headerPeer)
+	}
+}
+
+func TestSyncRejectsHeadersWithWrongHash(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, core.NewBlockchain())
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected}
+	n.peers[peer.ID] = peer
+	sm.headerPeer = peer.ID
+
+	header := HeaderOf(newTestChain(t, 1).Blocks[1])
+	header.Hash = "forged"
+	payload, _ := json.Marshal(&HeadersPayload{Headers: []*BlockHeader{header}})
+	sm.handleHeaders(peer, &Message{Type: MessageHeaders, Payload: payload})
+
+	if len(sm.headers) != 0 {
+		t.Errorf("Expected a header with a wrong hash to be rejected, got %d", len(sm.headers))
+	}
+
+	if peer.Score != ScoreInvalidBlock {
+		t.Errorf("Expected score %d for an invalid header, got %d", ScoreInvalidBlock, peer.Score)
+	}
+}
+
+func TestSyncBoundsPendingHeaders(t *testing.T) {
+	source := newTestChain(t, maxPendingHeaders+1)
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, core.NewBlockchain())
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected, BlockHeight: maxPendingHeaders + 1}
+	n.peers[peer.ID] = peer
+	for i := 1; i < maxPendingHeaders; i++ {
+		block, _ := source.GetBlock(i)
+		sm.headers = append(sm.headers, HeaderOf(block))
+	}
+
+	last, _ := source.GetBlock(maxPendingHeaders)
+	next, _ := source.GetBlock(maxPendingHeaders + 1)
+	sm.headerPeer = peer.ID
+	payload, _ := json.Marshal(&HeadersPayload{Headers: []*BlockHeader{HeaderOf(last), HeaderOf(next)}})
+	sm.handleHeaders(peer, &Message{Type: MessageHeaders, Payload: payload})
+
+	if len(sm.headers) != maxPendingHeaders {
+		t.Errorf("Expected %d pending headers, got %d", maxPendingHeaders, len(sm.headers))
+	}
+
+	sm.mutex.Lock()
+	sm.schedule(time.Now())
+	requested := sm.headerPeer
+	sm.mutex.Unlock()
+	if requested != "" {
+		t.Errorf("Expected no headers to be requested while the limit is reached, got %q", requested)
+	}
+}
+
+func TestSyncServesHeadersFromForkPoint(t *testing.T) {
+	source := newTestChain(t, 5)
+	server, serverSync := startSyncNode(t, source)
+	defer server.Stop()
+	defer serverSync.Stop()
+
+	// The client split from the source after block 1
+	local := newTestChain(t, 1)
+	fork := &core.Block{Index: 2, Timestamp: 1700000002, Transactions: []string{"fork-tx-2"}, PrevHash: local.GetLatestBlock().Hash, Validator: "validator2"}
+	fork.Hash = fork.CalculateHash()
+	if err := local.AddBlock(fork); err != nil {
+		t.Fatalf("Failed to build fork: %v", err)
+	}
+
+	client, clientSync := startSyncNode(t, local)
+	defer client.Stop()
+	defer clientSync.Stop()
+
+	if _, err := client.Connect(server.listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	// The source answers from block 2, which cannot be followed, so the
+	// client stops asking without blaming it
+	waitFor(t, "headers from the fork point", func() bool {
+		peers := client.GetConnectedPeers()
+		return len(peers) == 1 && peers[0].BlockHeight == 2
+	})
+
+	if peers := client.GetConnectedPeers(); peers[0].Score != 0 {
+		t.Errorf("Expected a peer on another fork not to be scored, got %d", peers[0].Score)
+	}
+
+	if local.GetLatestBlock().Hash != fork.Hash {
+		t.Error("Expected the local chain to be left alone")
+	}
+}
+
+func TestSyncScoresPeerWhoseBlockIsRejected(t *testing.T) {
+	local := core.NewBlockchain()
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, local)
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnected}
+	n.peers[peer.ID] = peer
+
+	// A header that skipped validation, matching a body the chain refuses
+	block := &core.Block{Index: 1, PrevHash: local.GetLatestBlock().Hash, Hash: "forged", Transactions: []string{"tx"}, Validator: "validator1"}
+	sm.headers = []*BlockHeader{HeaderOf(block)}
+	sm.inFlight[1] = &blockRequest{peerID: peer.ID, requestedAt: time.Now()}
+
+	payload, _ := json.Marshal(&BlocksPayload{Blocks: []*core.Block{block}})
+	sm.handleBlocks(peer, &Message{Type: MessageBlocks, Payload: payload})
+
+	if local.GetBlockCount() != 1 {
+		t.Error("Expected the chain to reject the block")
+	}
+
+	if peer.Score != ScoreInvalidBlock {
+		t.Errorf("Expected score %d for a rejected block, got %d", ScoreInvalidBlock, peer.Score)
+	}
+
+	if sm.IsSyncing() {
+		t.Error("Expected the download to restart from the chain tip")
+	}
+}
+
+func TestSyncReportsAddedBlocks(t *testing.T) {
+	local := core.NewBlockchain()
+	n, _ := NewNetwork("127.0.0.1:0")
+	sm := NewSyncManager(n, local)
+
+	var added []int
+	sm.SetBlockHandler(func(block *core.Block) { added = append(added, block.Index) })
+
+	source := newTestChain(t, 2)
+	header := HeaderOf(source.Blocks[2])
+	sm.headers = []*BlockHeader{HeaderOf(source.Blocks[1]), header}
+	sm.inFlight[1] = &blockRequest{peerID: "peer1", requestedAt: time.Now()}
+	sm.inFlight[2] = &blockRequest{peerID: "peer1", requestedAt: time.Now()}
+
+	// Bodies out of order are reported in chain order
+	for _, index := range []int{2, 1} {
+		payload, _ := json.Marshal(&BlocksPayload{Blocks: []*core.Block{source.Blocks[index]}})
+		sm.handleBlocks(&Peer{ID: "peer1"}, &Message{Type: MessageBlocks, Payload: payload})
+	}
+
+	if len(added) != 2 || added[0] != 1 || added[1] != 2 {
+		t.Errorf("Expected blocks 1 and 2 reported in order, got %v", added)
+	}
+}
@@ -0,0 +1,192 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// ProtocolVersion is the wire protocol version this node speaks
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest wire protocol version this node accepts
+	MinProtocolVersion = 1
+
+	// frameHeaderSize is magic (4) + type (1) + length (4) + checksum (4)
+	frameHeaderSize = 13
+
+	// maxFromSize bounds the sender ID carried in a frame
+	maxFromSize = 256
+)
+
+// frameMagic starts every frame, so a peer speaking another protocol or a
+// stream that lost sync is detected before anything is allocated
+var frameMagic = [4]byte{'B', 'T', 'N', 'G'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrBadMagic is returned when a frame does not start with the protocol magic
+	ErrBadMagic = errors.New("bad frame magic")
+	// ErrUnknownMessageType is returned for a frame with an unregistered type code
+	ErrUnknownMessageType = errors.New("unknown message type")
+	// ErrMessageTooLarge is returned when a frame exceeds the limit for its type
+	ErrMessageTooLarge = errors.New("message exceeds maximum size for its type")
+	// ErrChecksumMismatch is returned when a frame body fails its checksum
+	ErrChecksumMismatch = errors.New("frame checksum mismatch")
+	// ErrMalformedMessage is returned when a frame body cannot be decoded
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// messageSpec is the wire code and maximum body size of a message type
+type messageSpec struct {
+	code    byte
+	maxSize int
+}
+
+// messageSpecs lists every message type that may be sent on the wire
+var messageSpecs = map[MessageType]messageSpec{
+	MessageHandshake:   {code: 1, maxSize: 4 << 10},
+	MessageBlock:       {code: 2, maxSize: 4 << 20},
+	MessageTransaction: {code: 3, maxSize: 256 << 10},
+	MessagePeerList:    {code: 4, maxSize: 64 << 10},
+	MessagePing:        {code: 5, maxSize: 512},
+	MessagePong:        {code: 6, maxSize: 512},
+	MessageGetHeaders:  {code: 7, maxSize: 512},
+	MessageHeaders:     {code: 8, maxSize: 1 << 20},
+	MessageGetBlocks:   {code: 9, maxSize: 4 << 10},
+	MessageBlocks:      {code: 10, maxSize: 4 << 20},
+	MessageInventory:   {code: 11, maxSize: 128 << 10},
+	MessageGetData:     {code: 12, maxSize: 128 << 10},
+}
+
+// messageTypesByCode maps wire codes back to message types
+var messageTypesByCode = indexMessageSpecs(messageSpecs)
+
+// indexMessageSpecs builds the code to type lookup
+func indexMessageSpecs(specs map[MessageType]messageSpec) map[byte]MessageType {
+	types := make(map[byte]MessageType, len(specs))
+	for msgType, spec := range specs {
+		types[spec.code] = msgType
+	}
+	return types
+}
+
+// MaxMessageSize returns the largest body accepted for a message type, or 0
+// if the type cannot be sent on the wire
+func MaxMessageSize(msgType MessageType) int {
+	return messageSpecs[msgType].maxSize
+}
+
+// encodeFrame encodes a message as a frame:
+//
+//	magic | type | body length (big endian) | crc32c of body | body
+//
+// where the body is the sender ID (uvarint length prefixed), the timestamp
+// (8 bytes, big endian) and the JSON payload.
+func encodeFrame(msg *Message) ([]byte, error) {
+	spec, exists := messageSpecs[msg.Type]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMessageType, msg.Type)
+	}
+
+	if len(msg.From) > maxFromSize {
+		return nil, ErrMalformedMessage
+	}
+
+	bodySize := binary.MaxVarintLen64 + len(msg.From) + 8 + len(msg.Payload)
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+bodySize)
+	frame = binary.AppendUvarint(frame, uint64(len(msg.From)))
+	frame = append(frame, msg.From...)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(msg.Timestamp))
+	frame = append(frame, msg.Payload...)
+
+	body := frame[frameHeaderSize:]
+	if len(body) > spec.maxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit %d", ErrMessageTooLarge, msg.Type, len(body), spec.maxSize)
+	}
+
+	copy(frame[0:4], frameMagic[:])
+	frame[4] = spec.code
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[9:13], crc32.Checksum(body, crcTable))
+
+	return frame, nil
+}
+
+// readFrame reads one frame from r. The length is checked against the limit
+// of the message type before the body is read, so a peer cannot make the
+// node allocate more than the largest message it accepts.
+func readFrame(r io.Reader) (*Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if [4]byte(header[0:4]) != frameMagic {
+		return nil, ErrBadMagic
+	}
+
+	msgType, exists := messageTypesByCode[header[4]]
+	if !exists {
+		return nil, fmt.Errorf("%w: code %d", ErrUnknownMessageType, header[4])
+	}
+
+	length := binary.BigEndian.Uint32(header[5:9])
+	if maxSize := messageSpecs[msgType].maxSize; length > uint32(maxSize) {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit %d", ErrMessageTooLarge, msgType, length, maxSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[9:13]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	return decodeBody(msgType, body)
+}
+
+// decodeBody decodes the body of a frame
+func decodeBody(msgType MessageType, body []byte) (*Message, error) {
+	fromLen, n := binary.Uvarint(body)
+	if n <= 0 || fromLen > maxFromSize || uint64(len(body)-n) < fromLen+8 {
+		return nil, ErrMalformedMessage
+	}
+
+	pos := n + int(fromLen)
+	msg := &Message{
+		Type:      msgType,
+		From:      string(body[n:pos]),
+		Timestamp: int64(binary.BigEndian.Uint64(body[pos : pos+8])),
+	}
+	if payload := body[pos+8:]; len(payload) > 0 {
+		msg.Payload = payload
+	}
+
+	return msg, nil
+}
+
+// negotiateVersion picks the protocol version to speak with a peer: the
+// highest both support. It fails if the ranges do not overlap.
+func negotiateVersion(peerVersion, peerMin int) (int, error) {
+	if peerVersion == 0 {
+		return 0, errors.New("peer did not advertise a protocol version")
+	}
+
+	version := ProtocolVersion
+	if peerVersion < version {
+		version = peerVersion
+	}
+
+	if version < MinProtocolVersion || version < peerMin {
+		return 0, fmt.Errorf("incompatible protocol versions: ours %d-%d, peer %d-%d",
+			MinProtocolVersion, ProtocolVersion, peerMin, peerVersion)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	msg := &Message{Type: MessageTransaction, From: "node1", Timestamp: 1700000000, Payload: json.RawMessage(`{"txId":"tx1"}`)}
+
+	frame, err := encodeFrame(msg)
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	decoded, err := readFrame(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+
+	if decoded.Type != msg.Type || decoded.From != msg.From || decoded.Timestamp != msg.Timestamp || string(decoded.Payload) != string(msg.Payload) {
+		t.Errorf("Expected %+v, got %+v", msg, decoded)
+	}
+}
+
+func TestFrameRejectsOversizedLength(t *testing.T) {
+	// A header claiming a huge ping must fail without waiting for the body
+	header := make([]byte, frameHeaderSize)
+	copy(header, frameMagic[:])
+	header[4] = messageSpecs[MessagePing].code
+	binary.BigEndian.PutUint32(header[5:9], 1<<31)
+
+	if _, err := readFrame(bytes.NewReader(header)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+
+	big := &Message{Type: MessagePing, Payload: json.RawMessage(bytes.Repeat([]byte("1"), MaxMessageSize(MessagePing)))}
+	if _, err := encodeFrame(big); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge when sending, got %v", err)
+	}
+}
+
+func TestFrameRejectsDamage(t *testing.T) {
+	frame, _ := encodeFrame(&Message{Type: MessagePing, From: "node1", Payload: json.RawMessage(`{}`)})
+
+	corrupt := append([]byte(nil), frame...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := readFrame(bytes.NewReader(corrupt)); err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	badMagic := append([]byte(nil), frame...)
+	badMagic[0] = '{'
+	if _, err := readFrame(bytes.NewReader(badMagic)); err != ErrBadMagic {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+
+	unknown := append([]byte(nil), frame...)
+	unknown[4] = 0xff
+	if _, err := readFrame(bytes.NewReader(unknown)); !errors.Is(err, ErrUnknownMessageType) {
+		t.Errorf("Expected ErrUnknownMessageType, got %v", err)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	if version, err := negotiateVersion(ProtocolVersion+5, MinProtocolVersion); err != nil || version != ProtocolVersion {
+		t.Errorf("Expected to settle on our version %d, got %d (%v)", ProtocolVersion, version, err)
+	}
+
+	if _, err := negotiateVersion(ProtocolVersion+5, ProtocolVersion+1); err == nil {
+		t.Error("Expected error when the peer requires a newer protocol")
+	}
+
+	if _, err := negotiateVersion(0, 0); err == nil {
+		t.Error("Expected error when the peer advertises no protocol version")
+	}
+}
+
+func TestNetworkDropsNonProtocolPeer(t *testing.T) {
+	server, _ := NewNetwork("127.0.0.1:0")
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Old newline-delimited JSON is not a valid frame
+	conn.Write([]byte(`{"type":"ping","from":"x","timestamp":0,"payload":{}}` + "\n"))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4096)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				t.Fatal("Expected server to close the connection")
+			}
+			break
+		}
+	}
+}
+
+func TestMessagesBeforeHandshakeAreDropped(t *testing.T) {
+	n, _ := NewNetwork("127.0.0.1:0")
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	handled := false
+	n.Handle(MessageTransaction, func(*Peer, *Message) { handled = true })
+
+	peer := &Peer{ID: "peer1", Status: PeerStatusConnecting, conn: local}
+	n.peers[peer.ID] = peer
+
+	go func() {
+		frame, _ := encodeFrame(&Message{Type: MessageTransaction, From: "peer1", Payload: json.RawMessage(`{}`)})
+		remote.Write(frame) //nolint:errcheck
+		remote.Close()
+	}()
+	n.readLoop(peer)
+
+	if handled {
+		t.Error("Expected a message before the handshake not to reach handlers")
+	}
+
+	if peer.Score != ScoreMalformedMessage {
+		t.Errorf("Expected score %d for a message before the handshake, got %d", ScoreMalformedMessage, peer.Score)
+	}
+}
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// importBatchSize is the number of records Import writes per batch
+const importBatchSize = 1000
+
+// ErrDirNotEmpty is returned when a backup or restore target already holds files
+var ErrDirNotEmpty = errors.New("target directory is not empty")
+
+// exportRecord is one line of a JSON-lines export. Keys that are not valid
+// UTF-8 are written to KeyBytes instead of Key.
+type exportRecord struct {
+	Key      string `json:"key,omitempty"`
+	KeyBytes []byte `json:"keyBytes,omitempty"`
+	Value    []byte `json:"value"`
+}
+
+// Backup writes a consistent copy of the database as it is now to dir,
+// which must be empty or not exist. Reads and writes continue while the
+// backup runs. The backup is itself a data directory: open it directly or
+// copy it into place with Restore. Encrypted databases are backed up
+// encrypted and need the same passphrase.
+func (db *LevelDB) Backup(dir string) error {
+	if err := prepareEmptyDir(dir); err != nil {
+		return err
+	}
+
+	snapshot, keys, keyringData, err := db.backupSource()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+
+	if keyringData != nil {
+		if err := writeFileAtomic(filepath.Join(dir, keyringFile), keyringData); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, ".backup.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = writeBackupSegment(snapshot, keys, tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, segmentName(1))); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// BackupTo writes a consistent copy of the database as a tar stream to w.
+// The stream holds the files of a data directory; restore it with RestoreFrom.
+func (db *LevelDB) BackupTo(w io.Writer) error {
+	snapshot, keys, keyringData, err := db.backupSource()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+
+	// The tar header needs the segment size up front, so stage it in a temporary file
+	tmp, err := os.CreateTemp("", "leveldb-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := writeBackupSegment(snapshot, keys, tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+
+	if keyringData != nil {
+		header := &tar.Header{Name: keyringFile, Mode: 0600, Size: int64(len(keyringData)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(keyringData); err != nil {
+			return err
+		}
+	}
+
+	header := &tar.Header{Name: segmentName(1), Mode: 0600, Size: size, ModTime: now}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, tmp, size); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Restore copies a backup made by Backup into dataDir, which must be empty
+// or not exist
+func Restore(backupDir, dataDir string) error {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return err
+	}
+
+	if err := prepareEmptyDir(dataDir); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isBackupFile(entry.Name()) {
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(backupDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		err = restoreFile(dataDir, entry.Name(), file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncDir(dataDir)
+}
+
+// RestoreFrom extracts a tar stream written by BackupTo into dataDir, which
+// must be empty or not exist
+func RestoreFrom(r io.Reader, dataDir string) error {
+	if err := prepareEmptyDir(dataDir); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Only accept plain database files, so a crafted stream cannot write outside dataDir
+		if header.Typeflag != tar.TypeReg || !isBackupFile(header.Name) {
+			return errors.New("unexpected file in backup: " + header.Name)
+		}
+
+		if err := restoreFile(dataDir, header.Name, tr); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(dataDir)
+}
+
+// Export writes every key-value pair of kv as of now to w, one JSON object
+// per line in key order
+func Export(kv KV, w io.Writer) error {
+	snapshot, err := kv.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+
+	it := snapshot.NewIterator(nil)
+	defer it.Release()
+
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	for it.Next() {
+		value := it.Value()
+		if err := it.Error(); err != nil {
+			return err
+		}
+
+		rec := exportRecord{Key: it.Key(), Value: value}
+		if !utf8.ValidString(rec.Key) {
+			rec.Key, rec.KeyBytes = "", []byte(it.Key())
+		}
+
+		if err := encoder.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
+
+// Import stores the records of a JSON-lines export in kv, overwriting
+// existing keys, and returns the number imported. Records are written in
+// batches, so a failed import may leave earlier batches applied.
+func Import(kv KV, r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	batch := NewWriteBatch()
+	count := 0
+
+	for {
+		var rec exportRecord
+		err := decoder.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		key := rec.Key
+		if rec.KeyBytes != nil {
+			key = string(rec.KeyBytes)
+		}
+		if key == "" {
+			return count, errors.New("import record without key")
+		}
+
+		batch.Put(key, rec.Value)
+		if batch.Len() >= importBatchSize {
+			if err := kv.Write(batch); err != nil {
+				return count, err
+			}
+			count += batch.Len()
+			batch.Reset()
+		}
+	}
+
+	if err := kv.Write(batch); err != nil {
+		return count, err
+	}
+	return count + batch.Len(), nil
+}
+
+// backupSource takes a snapshot together with the data key and keyring
+// file it is sealed with, so a concurrent Rekey cannot split them
+func (db *LevelDB) backupSource() (*dbSnapshot, *keyring, []byte, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return nil, nil, nil, ErrClosed
+	}
+
+	var keyringData []byte
+	if db.keys.sealing() {
+		data, err := os.ReadFile(filepath.Join(db.dataDir, keyringFile))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		keyringData = data
+	}
+
+	snapshot := &dbSnapshot{db: db, root: db.root, segments: db.pin(db.segments)}
+	return snapshot, db.keys.view(), keyringData, nil
+}
+
+// writeBackupSegment writes the live records of a snapshot to w as a single
+// compacted segment and returns its size
+func writeBackupSegment(snapshot *dbSnapshot, keys *keyring, w io.Writer) (int64, error) {
+	buf := bufio.NewWriter(w)
+	size := int64(0)
+
+	err := indexWalk(snapshot.root, func(n *indexNode) error {
+		value, err := readNode(snapshot.segments, n)
+		if err != nil {
+			return err
+		}
+
+		op, err := keys.sealOp(batchOp{kind: recordPut, key: n.key, value: value})
+		if err != nil {
+			return err
+		}
+		record := encodeRecord(op.kind, op.key, op.value)
+		if _, err := buf.Write(record); err != nil {
+			return err
+		}
+		size += int64(len(record))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, buf.Flush()
+}
+
+// prepareEmptyDir creates dir with owner-only permissions, failing if it
+// already holds files
+func prepareEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrDirNotEmpty
+	}
+
+	return os.MkdirAll(dir, 0700)
+}
+
+// isBackupFile reports whether name is a file a backup may contain
+func isBackupFile(name string) bool {
+	if name == keyringFile {
+		return true
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return err == nil && segmentName(id) == name
+}
+
+// restoreFile writes a backup file into dataDir and syncs it
+func restoreFile(dataDir, name string, r io.Reader) error {
+	file, err := os.OpenFile(filepath.Join(dataDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("a", []byte("1"))
+	db.Put("b", []byte("2"))
+	db.Delete("a")
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	// Writes after the backup must not appear in it
+	db.Put("c", []byte("3"))
+
+	if err := db.Backup(backupDir); err != ErrDirNotEmpty {
+		t.Errorf("Expected ErrDirNotEmpty for a used backup directory, got %v", err)
+	}
+
+	dataDir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(backupDir, dataDir); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	restored := openTestDB(t, dataDir)
+	defer restored.Close()
+
+	if keys := restored.Keys(); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("Expected only b in the restored database, got %v", keys)
+	}
+
+	if err := Restore(backupDir, dataDir); err != ErrDirNotEmpty {
+		t.Errorf("Expected ErrDirNotEmpty when restoring over data, got %v", err)
+	}
+}
+
+func TestBackupStreamEncrypted(t *testing.T) {
+	db := openEncryptedDB(t, t.TempDir(), &Options{Passphrase: "hunter2"})
+	defer db.Close()
+
+	db.Put("secret", []byte("plaintext-value"))
+
+	var stream bytes.Buffer
+	if err := db.BackupTo(&stream); err != nil {
+		t.Fatalf("Failed to stream backup: %v", err)
+	}
+
+	if bytes.Contains(stream.Bytes(), []byte("plaintext-value")) {
+		t.Error("Expected backup of an encrypted database to stay encrypted")
+	}
+
+	dataDir := t.TempDir()
+	if err := RestoreFrom(&stream, dataDir); err != nil {
+		t.Fatalf("Failed to restore stream: %v", err)
+	}
+
+	restored := openEncryptedDB(t, dataDir, &Options{Passphrase: "hunter2"})
+	defer restored.Close()
+
+	if value, _ := restored.Get("secret"); string(value) != "plaintext-value" {
+		t.Errorf("Expected restored value, got %q", value)
+	}
+}
+
+func TestRestoreRejectsUnexpectedFiles(t *testing.T) {
+	var stream bytes.Buffer
+	tw := tar.NewWriter(&stream)
+	tw.WriteHeader(&tar.Header{Name: "../escape.log", Mode: 0600, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	if err := RestoreFrom(&stream, t.TempDir()); err == nil {
+		t.Error("Expected a path outside the data directory to be rejected")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("block:1", []byte(`{"height":1}`))
+	db.Put("raw\xff", []byte{0, 1, 2})
+
+	var out bytes.Buffer
+	if err := Export(db, &out); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	if lines := strings.Count(out.String(), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines, got %d", lines)
+	}
+
+	mem := NewMemDB()
+	count, err := Import(mem, &out)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 records imported, got %d", count)
+	}
+
+	if value, _ := mem.Get("raw\xff"); !bytes.Equal(value, []byte{0, 1, 2}) {
+		t.Errorf("Expected binary key and value to round-trip, got %v", value)
+	}
+}
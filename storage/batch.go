@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrConflict is returned when a transaction writes a key that another
+	// commit changed after the transaction began
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxnDone is returned when a committed or discarded transaction is used
+	ErrTxnDone = errors.New("transaction already committed or discarded")
+)
+
+// batchOp is a single put or delete of a batch
+type batchOp struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// WriteBatch collects puts and deletes that are written atomically: after a
+// crash either every operation of the batch is present or none is
+type WriteBatch struct {
+	ops []batchOp
+}
+
+// NewWriteBatch creates an empty write batch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put adds a put to the batch
+func (b *WriteBatch) Put(key string, value []byte) {
+	b.ops = append(b.ops, batchOp{kind: recordPut, key: key, value: value})
+}
+
+// PutJSON adds a put of a JSON-encoded value to the batch
+func (b *WriteBatch) PutJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	b.Put(key, data)
+	return nil
+}
+
+// Delete adds a delete to the batch. Deleting a missing key is not an error.
+func (b *WriteBatch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{kind: recordDelete, key: key})
+}
+
+// Len returns the number of operations in the batch
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so it can be reused
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Write applies a batch atomically. Operations apply in order, so a later
+// operation on a key wins.
+func (db *LevelDB) Write(batch *WriteBatch) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	for _, op := range batch.ops {
+		if op.key == "" {
+			return errors.New("key cannot be empty")
+		}
+	}
+
+	if len(batch.ops) == 0 {
+		return nil
+	}
+
+	ops, err := db.keys.sealOps(batch.ops)
+	if err != nil {
+		return err
+	}
+	return db.appendRecord(encodeBatch(ops))
+}
+
+// Txn is a read-write transaction with snapshot isolation. Reads see the
+// database as it was when the transaction began plus the transaction's own
+// writes. Commit fails with ErrConflict if another commit changed a key the
+// transaction writes. Keys that were only read are not checked, so two
+// transactions that each read what the other writes can both commit (write
+// skew); write a key read to guard an invariant to make them conflict.
+type Txn struct {
+	db       *LevelDB
+	snapshot *dbSnapshot
+	writes   map[string]batchOp
+	done     bool
+}
+
+// Begin starts a read-write transaction
+func (db *LevelDB) Begin() (*Txn, error) {
+	snapshot, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Txn{
+		db:       db,
+		snapshot: snapshot,
+		writes:   make(map[string]batchOp),
+	}, nil
+}
+
+// Update runs fn in a transaction and commits it if fn returns nil
+func (db *LevelDB) Update(fn func(txn *Txn) error) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// Get retrieves a value by key
+func (txn *Txn) Get(key string) ([]byte, error) {
+	if txn.done {
+		return nil, ErrTxnDone
+	}
+
+	if op, written := txn.writes[key]; written {
+		if op.kind == recordDelete {
+			return nil, ErrNotFound
+		}
+		return op.value, nil
+	}
+
+	return txn.snapshot.Get(key)
+}
+
+// GetJSON retrieves and decodes a JSON value
+func (txn *Txn) GetJSON(key string, dest interface{}) error {
+	data, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// Has checks if a key exists. It returns false once the transaction is done.
+func (txn *Txn) Has(key string) bool {
+	if txn.done {
+		return false
+	}
+
+	if op, written := txn.writes[key]; written {
+		return op.kind == recordPut
+	}
+
+	return txn.snapshot.Has(key)
+}
+
+// Put stores a key-value pair when the transaction commits
+func (txn *Txn) Put(key string, value []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+
+	txn.writes[key] = batchOp{kind: recordPut, key: key, value: value}
+	return nil
+}
+
+// PutJSON stores a JSON-encoded value when the transaction commits
+func (txn *Txn) PutJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return txn.Put(key, data)
+}
+
+// Delete removes a key-value pair when the transaction commits
+func (txn *Txn) Delete(key string) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+
+	if !txn.Has(key) {
+		return ErrNotFound
+	}
+
+	txn.writes[key] = batchOp{kind: recordDelete, key: key}
+	return nil
+}
+
+// Commit writes the transaction atomically
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	defer txn.Discard()
+
+	db := txn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if len(txn.writes) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(txn.writes))
+	for key := range txn.writes {
+		if txn.changedSince(key) {
+			return ErrConflict
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ops := make([]batchOp, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, txn.writes[key])
+	}
+
+	sealed, err := db.keys.sealOps(ops)
+	if err != nil {
+		return err
+	}
+	return db.appendRecord(encodeBatch(sealed))
+}
+
+// changedSince reports whether a key was written after the transaction
+// began; callers must hold the database lock
+func (txn *Txn) changedSince(key string) bool {
+	before, existed := indexGet(txn.snapshot.root, key)
+	now, exists := indexGet(txn.db.root, key)
+
+	if existed != exists {
+		return true
+	}
+	return exists && before.seq != now.seq
+}
+
+// Discard ends the transaction without writing. It is safe to call after Commit.
+func (txn *Txn) Discard() {
+	if txn.done {
+		return
+	}
+
+	txn.done = true
+	txn.snapshot.Release()
+}
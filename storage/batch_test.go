@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	db.Put("balance:alice", []byte("100"))
+	db.Put("stale", []byte("x"))
+
+	batch := NewWriteBatch()
+	batch.Put("balance:alice", []byte("60"))
+	batch.Put("balance:bob", []byte("40"))
+	batch.Delete("stale")
+	batch.Put("tip", []byte("block-2"))
+
+	if err := db.Write(batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	db.Close()
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if value, _ := db.Get("balance:alice"); string(value) != "60" {
+		t.Errorf("Expected alice balance 60, got %q", value)
+	}
+
+	if db.Has("stale") || !db.Has("balance:bob") || !db.Has("tip") {
+		t.Errorf("Expected batch to be fully applied, got %v", db.Keys())
+	}
+}
+
+func TestTornBatchIsDiscardedWhole(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	db.Put("tip", []byte("block-1"))
+
+	batch := NewWriteBatch()
+	batch.Put("balance:alice", []byte("60"))
+	batch.Put("tip", []byte("block-2"))
+	db.Write(batch)
+	db.Close()
+
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if value, _ := db.Get("tip"); string(value) != "block-1" {
+		t.Errorf("Expected tip from before the torn batch, got %q", value)
+	}
+
+	if db.Has("balance:alice") {
+		t.Error("Expected no operation of the torn batch to survive")
+	}
+}
+
+func TestTxnSnapshotIsolation(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("a", []byte("1"))
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	defer txn.Discard()
+
+	db.Put("a", []byte("2"))
+	db.Put("b", []byte("new"))
+
+	if value, _ := txn.Get("a"); string(value) != "1" {
+		t.Errorf("Expected snapshot value 1, got %q", value)
+	}
+
+	if txn.Has("b") {
+		t.Error("Expected key written after Begin to be invisible")
+	}
+
+	txn.Put("c", []byte("3"))
+	if value, _ := txn.Get("c"); string(value) != "3" {
+		t.Errorf("Expected transaction to read its own write, got %q", value)
+	}
+
+	if db.Has("c") {
+		t.Error("Expected uncommitted write to be invisible outside the transaction")
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	if !db.Has("c") {
+		t.Error("Expected committed write to be visible")
+	}
+
+	if err := txn.Put("d", nil); err != ErrTxnDone {
+		t.Errorf("Expected ErrTxnDone after commit, got %v", err)
+	}
+
+	if txn.Has("c") {
+		t.Error("Expected Has to report false after commit")
+	}
+}
+
+func TestTxnWriteConflict(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("balance", []byte("100"))
+
+	first, _ := db.Begin()
+	second, _ := db.Begin()
+
+	first.Put("balance", []byte("50"))
+	second.Put("balance", []byte("70"))
+
+	if err := first.Commit(); err != nil {
+		t.Fatalf("Failed to commit first transaction: %v", err)
+	}
+
+	if err := second.Commit(); err != ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	if value, _ := db.Get("balance"); string(value) != "50" {
+		t.Errorf("Expected first commit to win, got %q", value)
+	}
+}
+
+func TestTxnSurvivesCompaction(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("a", []byte("1"))
+
+	err := db.Update(func(txn *Txn) error {
+		if err := db.Compact(); err != nil {
+			return err
+		}
+
+		value, err := txn.Get("a")
+		if err != nil || string(value) != "1" {
+			t.Errorf("Expected to read a after compaction, got %q (%v)", value, err)
+		}
+
+		return txn.Put("a", []byte("2"))
+	})
+	if err != nil {
+		t.Fatalf("Expected compaction not to conflict with the transaction: %v", err)
+	}
+
+	if value, _ := db.Get("a"); string(value) != "2" {
+		t.Errorf("Expected committed value 2, got %q", value)
+	}
+}
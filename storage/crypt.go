@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// keyringFile holds the data keys of an encrypted database, wrapped with
+	// a key derived from the passphrase
+	keyringFile    = "KEYRING"
+	keyringVersion = 1
+
+	keyringScryptN = 1 << 15
+	keyringScryptR = 8
+	keyringScryptP = 1
+
+	dataKeySize = 32
+	keyIDSize   = 4
+)
+
+// Record kind flags marking sealed parts of an operation
+const (
+	kindMask        byte = 0x0f
+	flagSealedValue byte = 0x40
+	flagSealedKey   byte = 0x80
+)
+
+var (
+	// ErrPassphraseRequired is returned when an encrypted database is opened without a passphrase
+	ErrPassphraseRequired = errors.New("database is encrypted: passphrase required")
+	// ErrWrongPassphrase is returned when the passphrase does not open the keyring
+	ErrWrongPassphrase = errors.New("wrong passphrase for encrypted database")
+)
+
+// keyringJSON is the on-disk form of a keyring
+type keyringJSON struct {
+	Version     int              `json:"version"`
+	EncryptKeys bool             `json:"encryptKeys"`
+	Current     uint32           `json:"current"`
+	KDF         keyringKDFJSON   `json:"kdf"`
+	Keys        []wrappedKeyJSON `json:"keys"`
+}
+
+// keyringKDFJSON holds the scrypt parameters of the passphrase key
+type keyringKDFJSON struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+// wrappedKeyJSON is a data key sealed with the passphrase key
+type wrappedKeyJSON struct {
+	ID         uint32 `json:"id"`
+	Nonce      string `json:"nonce"`
+	CipherText string `json:"ciphertext"`
+}
+
+// keyring holds the data keys used to seal records. Sealed data is prefixed
+// with the id of its key, so records written before a rotation stay
+// readable until they are rewritten.
+type keyring struct {
+	current     uint32 // 0 = write plaintext
+	encryptKeys bool
+	keys        map[uint32][]byte
+	aeads       map[uint32]cipher.AEAD
+	mutex       sync.RWMutex
+}
+
+// newKeyring creates an empty keyring
+func newKeyring(encryptKeys bool) *keyring {
+	return &keyring{
+		encryptKeys: encryptKeys,
+		keys:        make(map[uint32][]byte),
+		aeads:       make(map[uint32]cipher.AEAD),
+	}
+}
+
+// sealing reports whether new records are encrypted
+func (k *keyring) sealing() bool {
+	if k == nil {
+		return false
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current != 0
+}
+
+// addKey adds a data key
+func (k *keyring) addKey(id uint32, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys[id] = key
+	k.aeads[id] = aead
+	return nil
+}
+
+// generateKey adds a fresh random data key and makes it current
+func (k *keyring) generateKey() error {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	k.mutex.RLock()
+	id := uint32(1)
+	for existing := range k.keys {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	k.mutex.RUnlock()
+
+	if err := k.addKey(id, key); err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	k.current = id
+	k.mutex.Unlock()
+	return nil
+}
+
+// seal encrypts data with the current key, binding aad
+func (k *keyring) seal(data, aad []byte) ([]byte, error) {
+	k.mutex.RLock()
+	id := k.current
+	aead := k.aeads[id]
+	k.mutex.RUnlock()
+
+	out := make([]byte, keyIDSize+aead.NonceSize(), keyIDSize+aead.NonceSize()+len(data)+aead.Overhead())
+	binary.LittleEndian.PutUint32(out, id)
+	if _, err := rand.Read(out[keyIDSize:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, out[keyIDSize:], data, aad), nil
+}
+
+// open decrypts data sealed by seal
+func (k *keyring) open(sealed, aad []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrPassphraseRequired
+	}
+
+	if len(sealed) < keyIDSize {
+		return nil, errCorruptRecord
+	}
+
+	k.mutex.RLock()
+	aead, exists := k.aeads[binary.LittleEndian.Uint32(sealed)]
+	empty := len(k.aeads) == 0
+	k.mutex.RUnlock()
+	if empty {
+		return nil, ErrPassphraseRequired
+	}
+	if !exists {
+		return nil, errors.New("record sealed with unknown data key")
+	}
+
+	nonceEnd := keyIDSize + aead.NonceSize()
+	if len(sealed) < nonceEnd {
+		return nil, errCorruptRecord
+	}
+
+	data, err := aead.Open(nil, sealed[keyIDSize:nonceEnd], sealed[nonceEnd:], aad)
+	if err != nil {
+		return nil, errCorruptRecord
+	}
+	return data, nil
+}
+
+// sealOp encrypts the value, and the key if configured, of an operation
+func (k *keyring) sealOp(op batchOp) (batchOp, error) {
+	if !k.sealing() {
+		return op, nil
+	}
+
+	k.mutex.RLock()
+	encryptKeys := k.encryptKeys
+	k.mutex.RUnlock()
+
+	if op.kind == recordPut {
+		value, err := k.seal(op.value, []byte(op.key))
+		if err != nil {
+			return op, err
+		}
+		op.value = value
+		op.kind |= flagSealedValue
+	}
+
+	if encryptKeys {
+		key, err := k.seal([]byte(op.key), nil)
+		if err != nil {
+			return op, err
+		}
+		op.key = string(key)
+		op.kind |= flagSealedKey
+	}
+
+	return op, nil
+}
+
+// save wraps every data key with a key derived from passphrase and writes
+// the keyring atomically
+func (k *keyring) save(dir, passphrase string) error {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	kek, err := scrypt.Key([]byte(passphrase), salt, keyringScryptN, keyringScryptR, keyringScryptP, dataKeySize)
+	if err != nil {
+		return err
+	}
+
+	wrapper, err := newAEAD(kek)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.RLock()
+	file := &keyringJSON{
+		Version:     keyringVersion,
+		EncryptKeys: k.encryptKeys,
+		Current:     k.current,
+		KDF:         keyringKDFJSON{N: keyringScryptN, R: keyringScryptR, P: keyringScryptP, Salt: hex.EncodeToString(salt)},
+	}
+	for id, key := range k.keys {
+		nonce := make([]byte, wrapper.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+
+		idBytes := binary.LittleEndian.AppendUint32(nil, id)
+		file.Keys = append(file.Keys, wrappedKeyJSON{
+			ID:         id,
+			Nonce:      hex.EncodeToString(nonce),
+			CipherText: hex.EncodeToString(wrapper.Seal(nil, nonce, key, idBytes)),
+		})
+	}
+	k.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, keyringFile), data)
+}
+
+// sealOps seals every operation of a batch
+func (k *keyring) sealOps(ops []batchOp) ([]batchOp, error) {
+	if !k.sealing() {
+		return ops, nil
+	}
+
+	sealed := make([]batchOp, len(ops))
+	for i, op := range ops {
+		op, err := k.sealOp(op)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = op
+	}
+	return sealed, nil
+}
+
+// view returns a keyring that seals with the current key only. It is not
+// affected by later rotations.
+func (k *keyring) view() *keyring {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	v := newKeyring(k.encryptKeys)
+	if k.current != 0 {
+		v.current = k.current
+		v.keys[k.current] = k.keys[k.current]
+		v.aeads[k.current] = k.aeads[k.current]
+	}
+	return v
+}
+
+// retain drops every data key except the current one from the saved
+// keyring. Their ciphers stay in memory for readers of retired segments.
+func (k *keyring) retain() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for id := range k.keys {
+		if id != k.current {
+			delete(k.keys, id)
+		}
+	}
+}
+
+// loadKeyring reads the keyring of dir. It returns nil if the database is not encrypted.
+func loadKeyring(dir, passphrase string) (*keyring, error) {
+	data, err := os.ReadFile(filepath.Join(dir, keyringFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	file := &keyringJSON{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	if file.Version != keyringVersion {
+		return nil, errors.New("unsupported keyring version")
+	}
+
+	salt, err := hex.DecodeString(file.KDF.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := scrypt.Key([]byte(passphrase), salt, file.KDF.N, file.KDF.R, file.KDF.P, dataKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	k := newKeyring(file.EncryptKeys)
+	for _, wrapped := range file.Keys {
+		nonce, err := hex.DecodeString(wrapped.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		cipherText, err := hex.DecodeString(wrapped.CipherText)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != wrapper.NonceSize() {
+			return nil, errors.New("invalid keyring nonce")
+		}
+
+		key, err := wrapper.Open(nil, nonce, cipherText, binary.LittleEndian.AppendUint32(nil, wrapped.ID))
+		if err != nil {
+			return nil, ErrWrongPassphrase
+		}
+
+		if err := k.addKey(wrapped.ID, key); err != nil {
+			return nil, err
+		}
+	}
+	k.current = file.Current
+
+	return k, nil
+}
+
+// newAEAD creates an AES-256-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes a file through a synced temporary file and rename,
+// so readers see either the old or the new contents
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// CreateTemp files are already owner-only (0600)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(dir)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openEncryptedDB(t *testing.T, dir string, opts *Options) *LevelDB {
+	db, err := NewLevelDBWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted database: %v", err)
+	}
+	return db
+}
+
+// segmentBytes returns the contents of every segment in dir
+func segmentBytes(t *testing.T, dir string) []byte {
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("Failed to list segments: %v", err)
+	}
+
+	var all []byte
+	for _, id := range ids {
+		data, _ := os.ReadFile(filepath.Join(dir, segmentName(id)))
+		all = append(all, data...)
+	}
+	return all
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	dir := t.TempDir()
+	db := openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	db.Put("account:alice", []byte("secret-balance"))
+
+	batch := &WriteBatch{}
+	batch.Put("account:bob", []byte("batched-secret"))
+	db.Write(batch)
+	db.Close()
+
+	data := segmentBytes(t, dir)
+	if bytes.Contains(data, []byte("secret-balance")) || bytes.Contains(data, []byte("batched-secret")) {
+		t.Error("Expected values not to be stored in plaintext")
+	}
+	if !bytes.Contains(data, []byte("account:alice")) {
+		t.Error("Expected keys to stay in plaintext without EncryptKeys")
+	}
+
+	if _, err := NewLevelDB(dir); err != ErrPassphraseRequired {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+
+	if _, err := NewLevelDBWithOptions(dir, &Options{Passphrase: "wrong"}); err != ErrWrongPassphrase {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	db = openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	defer db.Close()
+
+	value, err := db.Get("account:bob")
+	if err != nil || string(value) != "batched-secret" {
+		t.Errorf("Expected batched-secret, got %q (%v)", value, err)
+	}
+}
+
+func TestEncryptKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := openEncryptedDB(t, dir, &Options{Passphrase: "hunter2", EncryptKeys: true})
+	db.Put("account:alice", []byte("1"))
+	db.Delete("account:alice")
+	db.Put("account:bob", []byte("2"))
+	db.Close()
+
+	if bytes.Contains(segmentBytes(t, dir), []byte("account:")) {
+		t.Error("Expected keys not to be stored in plaintext")
+	}
+
+	db = openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	defer db.Close()
+
+	if keys := db.Keys(); len(keys) != 1 || keys[0] != "account:bob" {
+		t.Errorf("Expected only account:bob after replay, got %v", keys)
+	}
+}
+
+func TestEncryptExistingDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	db.Put("a", []byte("plaintext-value"))
+	db.Close()
+
+	db = openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	db.Close()
+
+	if bytes.Contains(segmentBytes(t, dir), []byte("plaintext-value")) {
+		t.Error("Expected existing values to be encrypted on open")
+	}
+
+	db = openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	defer db.Close()
+
+	if value, _ := db.Get("a"); string(value) != "plaintext-value" {
+		t.Errorf("Expected plaintext-value, got %q", value)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	dir := t.TempDir()
+	db := openEncryptedDB(t, dir, &Options{Passphrase: "old"})
+	db.Put("a", []byte("1"))
+
+	it := db.NewIterator(nil)
+	if err := db.Rekey("new"); err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+
+	// Iterators opened before the rotation still read the old segments
+	if !it.Next() || string(it.Value()) != "1" {
+		t.Errorf("Expected iterator to read across rekey, got %q (%v)", it.Value(), it.Error())
+	}
+	it.Release()
+	db.Close()
+
+	if _, err := NewLevelDBWithOptions(dir, &Options{Passphrase: "old"}); err != ErrWrongPassphrase {
+		t.Errorf("Expected old passphrase to be rejected, got %v", err)
+	}
+
+	db = openEncryptedDB(t, dir, &Options{Passphrase: "new"})
+	if value, _ := db.Get("a"); string(value) != "1" {
+		t.Errorf("Expected value after rekey, got %q", value)
+	}
+
+	if err := db.Rekey(""); err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	db.Close()
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if value, _ := db.Get("a"); string(value) != "1" {
+		t.Errorf("Expected value after decrypting, got %q", value)
+	}
+}
+
+func TestFilePermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	db := openEncryptedDB(t, dir, &Options{Passphrase: "hunter2"})
+	db.Put("a", []byte("1"))
+	db.Compact()
+	defer db.Close()
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("Expected %s to be owner-only, got %v", entry.Name(), info.Mode().Perm())
+		}
+	}
+
+	info, _ := os.Stat(dir)
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected data directory mode 0700, got %v", info.Mode().Perm())
+	}
+}
+
+// savedKeyCount returns the number of data keys in the keyring file of dir
+func savedKeyCount(t *testing.T, dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, keyringFile))
+	if err != nil {
+		return 0
+	}
+
+	file := &keyringJSON{}
+	if err := json.Unmarshal(data, file); err != nil {
+		t.Fatalf("Failed to decode keyring: %v", err)
+	}
+	return len(file.Keys)
+}
+
+func TestRekeyKeepsKeysOfPinnedSegments(t *testing.T) {
+	dir := t.TempDir()
+	db := openEncryptedDB(t, dir, &Options{Passphrase: "old"})
+	defer db.Close()
+	db.Put("a", []byte("1"))
+
+	it := db.NewIterator(nil)
+	if err := db.Rekey("new"); err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+
+	if n := savedKeyCount(t, dir); n != 2 {
+		t.Errorf("Expected the old key saved while its segment is pinned, got %d keys", n)
+	}
+
+	it.Release()
+	if n := savedKeyCount(t, dir); n != 1 {
+		t.Errorf("Expected the old key retired after release, got %d keys", n)
+	}
+
+	it = db.NewIterator(nil)
+	if err := db.Rekey(""); err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, keyringFile)); err != nil {
+		t.Error("Expected the keyring to stay while a sealed segment is pinned")
+	}
+
+	it.Release()
+	if _, err := os.Stat(filepath.Join(dir, keyringFile)); !os.IsNotExist(err) {
+		t.Error("Expected the keyring removed once no sealed segment is left")
+	}
+}
@@ -0,0 +1,220 @@
+package storage
+
+import "hash/fnv"
+
+// indexNode is a node of the key index, a persistent treap ordered by key.
+// Updates copy the path to the changed node, so an old root is an immutable
+// view of the index and can be iterated while the database keeps changing.
+type indexNode struct {
+	key      string
+	loc      location
+	value    []byte // held in memory by MemDB only
+	priority uint32
+	left     *indexNode
+	right    *indexNode
+}
+
+// keyPriority derives a treap priority from a key
+func keyPriority(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// indexFind returns the node of a key, or nil
+func indexFind(n *indexNode, key string) *indexNode {
+	for n != nil {
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// indexGet returns the location of a key
+func indexGet(n *indexNode, key string) (location, bool) {
+	if n = indexFind(n, key); n == nil {
+		return location{}, false
+	}
+	return n.loc, true
+}
+
+// indexPut returns a new root with key set to loc and value, the replaced
+// location and whether the key already existed
+func indexPut(n *indexNode, key string, loc location, value []byte) (*indexNode, location, bool) {
+	if n == nil {
+		return &indexNode{key: key, loc: loc, value: value, priority: keyPriority(key)}, location{}, false
+	}
+
+	c := *n
+	var old location
+	var existed bool
+
+	switch {
+	case key < n.key:
+		c.left, old, existed = indexPut(n.left, key, loc, value)
+		if c.left.priority > c.priority {
+			return rotateRight(&c), old, existed
+		}
+	case key > n.key:
+		c.right, old, existed = indexPut(n.right, key, loc, value)
+		if c.right.priority > c.priority {
+			return rotateLeft(&c), old, existed
+		}
+	default:
+		old, existed = c.loc, true
+		c.loc = loc
+		c.value = value
+	}
+
+	return &c, old, existed
+}
+
+// indexDelete returns a new root without key, the removed location and
+// whether the key existed
+func indexDelete(n *indexNode, key string) (*indexNode, location, bool) {
+	if n == nil {
+		return nil, location{}, false
+	}
+
+	switch {
+	case key < n.key:
+		left, old, existed := indexDelete(n.left, key)
+		if !existed {
+			return n, old, false
+		}
+		c := *n
+		c.left = left
+		return &c, old, true
+	case key > n.key:
+		right, old, existed := indexDelete(n.right, key)
+		if !existed {
+			return n, old, false
+		}
+		c := *n
+		c.right = right
+		return &c, old, true
+	default:
+		return indexMerge(n.left, n.right), n.loc, true
+	}
+}
+
+// indexMerge joins two treaps where every key in a is below every key in b
+func indexMerge(a, b *indexNode) *indexNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		c := *a
+		c.right = indexMerge(a.right, b)
+		return &c
+	}
+
+	c := *b
+	c.left = indexMerge(a, b.left)
+	return &c
+}
+
+// rotateRight lifts the left child of a freshly copied node
+func rotateRight(n *indexNode) *indexNode {
+	l := n.left
+	n.left = l.right
+	l.right = n
+	return l
+}
+
+// rotateLeft lifts the right child of a freshly copied node
+func rotateLeft(n *indexNode) *indexNode {
+	r := n.right
+	n.right = r.left
+	r.left = n
+	return r
+}
+
+// indexFirst returns the node with the smallest key
+func indexFirst(n *indexNode) *indexNode {
+	if n == nil {
+		return nil
+	}
+	for n.left != nil {
+		n = n.left
+	}
+	return n
+}
+
+// indexLast returns the node with the largest key
+func indexLast(n *indexNode) *indexNode {
+	if n == nil {
+		return nil
+	}
+	for n.right != nil {
+		n = n.right
+	}
+	return n
+}
+
+// indexCeil returns the node with the smallest key >= key
+func indexCeil(n *indexNode, key string) *indexNode {
+	var best *indexNode
+	for n != nil {
+		if n.key >= key {
+			best = n
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return best
+}
+
+// indexHigher returns the node with the smallest key > key
+func indexHigher(n *indexNode, key string) *indexNode {
+	var best *indexNode
+	for n != nil {
+		if n.key > key {
+			best = n
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return best
+}
+
+// indexLower returns the node with the largest key < key
+func indexLower(n *indexNode, key string) *indexNode {
+	var best *indexNode
+	for n != nil {
+		if n.key < key {
+			best = n
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return best
+}
+
+// indexWalk calls fn for every node in ascending key order
+func indexWalk(n *indexNode, fn func(n *indexNode) error) error {
+	if n == nil {
+		return nil
+	}
+
+	if err := indexWalk(n.left, fn); err != nil {
+		return err
+	}
+	if err := fn(n); err != nil {
+		return err
+	}
+	return indexWalk(n.right, fn)
+}
@@ -0,0 +1,218 @@
+package storage
+
+// Range bounds an iteration to keys in [Start, Limit). An empty Start or
+// Limit leaves that side unbounded.
+type Range struct {
+	Start string
+	Limit string
+}
+
+// PrefixRange returns the range of keys that begin with prefix
+func PrefixRange(prefix string) *Range {
+	limit := []byte(prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return &Range{Start: prefix, Limit: string(limit[:i+1])}
+		}
+	}
+
+	// Every byte is 0xff, so no key above the prefix shares it
+	return &Range{Start: prefix}
+}
+
+// Iterator walks the keys of a store in order. It reads from the state of
+// the store when it was created and must be released when done.
+//
+//	it := db.NewIterator(PrefixRange("block:"))
+//	defer it.Release()
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	if err := it.Error(); err != nil { ... }
+//
+// Next on a fresh iterator moves to the first key and Prev to the last, so
+// the same loop with Prev walks the range in reverse.
+type Iterator interface {
+	// First moves to the first key in range
+	First() bool
+	// Last moves to the last key in range
+	Last() bool
+	// Seek moves to the first key in range that is >= key
+	Seek(key string) bool
+	// Next moves to the next key and reports whether there is one
+	Next() bool
+	// Prev moves to the previous key and reports whether there is one
+	Prev() bool
+	// Valid reports whether the iterator is at a key
+	Valid() bool
+	// Key returns the current key
+	Key() string
+	// Value returns the current value
+	Value() []byte
+	// Error returns the first error met by the iterator
+	Error() error
+	// Release frees the iterator. It is safe to call more than once.
+	Release()
+}
+
+// indexIterator iterates over an immutable index root. Values are read from
+// segments, or from the nodes themselves when segments is nil.
+type indexIterator struct {
+	root       *indexNode
+	segments   map[uint64]*segment
+	release    func()
+	start      string
+	limit      string
+	current    *indexNode
+	positioned bool
+	released   bool
+	err        error
+}
+
+// NewIterator returns an iterator over the keys in r, or every key if r is nil
+func (db *LevelDB) NewIterator(r *Range) Iterator {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return errIterator(ErrClosed)
+	}
+
+	segments := db.pin(db.segments)
+	return newIterator(db.root, segments, func() { db.unpin(segments) }, r)
+}
+
+// newIterator creates an iterator over root; release is called once on Release
+func newIterator(root *indexNode, segments map[uint64]*segment, release func(), r *Range) *indexIterator {
+	it := &indexIterator{root: root, segments: segments, release: release}
+	if r != nil {
+		it.start = r.Start
+		it.limit = r.Limit
+	}
+	return it
+}
+
+// errIterator returns an iterator that is empty and reports err
+func errIterator(err error) *indexIterator {
+	return &indexIterator{err: err, released: true}
+}
+
+// inRange reports whether a node is within the iterator bounds
+func (it *indexIterator) inRange(n *indexNode) bool {
+	if n == nil {
+		return false
+	}
+	if it.start != "" && n.key < it.start {
+		return false
+	}
+	return it.limit == "" || n.key < it.limit
+}
+
+// moveTo makes n the current node if it is in range
+func (it *indexIterator) moveTo(n *indexNode) bool {
+	if it.released || it.err != nil {
+		it.current = nil
+		return false
+	}
+
+	it.positioned = true
+	if !it.inRange(n) {
+		it.current = nil
+		return false
+	}
+
+	it.current = n
+	return true
+}
+
+// First moves to the first key in range
+func (it *indexIterator) First() bool {
+	if it.start == "" {
+		return it.moveTo(indexFirst(it.root))
+	}
+	return it.moveTo(indexCeil(it.root, it.start))
+}
+
+// Last moves to the last key in range
+func (it *indexIterator) Last() bool {
+	if it.limit == "" {
+		return it.moveTo(indexLast(it.root))
+	}
+	return it.moveTo(indexLower(it.root, it.limit))
+}
+
+// Seek moves to the first key in range that is >= key
+func (it *indexIterator) Seek(key string) bool {
+	if key < it.start {
+		key = it.start
+	}
+	return it.moveTo(indexCeil(it.root, key))
+}
+
+// Next moves to the next key and reports whether there is one
+func (it *indexIterator) Next() bool {
+	if !it.positioned {
+		return it.First()
+	}
+	if it.current == nil {
+		return false
+	}
+	return it.moveTo(indexHigher(it.root, it.current.key))
+}
+
+// Prev moves to the previous key and reports whether there is one
+func (it *indexIterator) Prev() bool {
+	if !it.positioned {
+		return it.Last()
+	}
+	if it.current == nil {
+		return false
+	}
+	return it.moveTo(indexLower(it.root, it.current.key))
+}
+
+// Valid reports whether the iterator is at a key
+func (it *indexIterator) Valid() bool {
+	return it.current != nil
+}
+
+// Key returns the current key
+func (it *indexIterator) Key() string {
+	if it.current == nil {
+		return ""
+	}
+	return it.current.key
+}
+
+// Value returns the current value
+func (it *indexIterator) Value() []byte {
+	if it.current == nil {
+		return nil
+	}
+
+	value, err := readNode(it.segments, it.current)
+	if err != nil {
+		it.err = err
+		return nil
+	}
+	return value
+}
+
+// Error returns the first error met by the iterator
+func (it *indexIterator) Error() error {
+	return it.err
+}
+
+// Release frees the iterator. It is safe to call more than once.
+func (it *indexIterator) Release() {
+	if it.released {
+		return
+	}
+
+	it.released = true
+	it.current = nil
+	if it.release != nil {
+		it.release()
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newIteratorTestDB(t *testing.T) *LevelDB {
+	db := openTestDB(t, t.TempDir())
+	for _, key := range []string{"block:001", "block:002", "block:003", "tx:a", "tx:b", "validator:x"} {
+		db.Put(key, []byte("value-"+key))
+	}
+	return db
+}
+
+func collect(it Iterator, reverse bool) []string {
+	keys := []string{}
+	step := it.Next
+	if reverse {
+		step = it.Prev
+	}
+	for step() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+func TestPrefixIteration(t *testing.T) {
+	db := newIteratorTestDB(t)
+	defer db.Close()
+
+	it := db.NewIterator(PrefixRange("tx:"))
+	defer it.Release()
+
+	if keys := collect(it, false); fmt.Sprint(keys) != "[tx:a tx:b]" {
+		t.Errorf("Expected tx keys, got %v", keys)
+	}
+
+	if r := PrefixRange("\xff\xff"); r.Limit != "" {
+		t.Errorf("Expected unbounded limit for an all-0xff prefix, got %q", r.Limit)
+	}
+}
+
+func TestRangeAndReverseIteration(t *testing.T) {
+	db := newIteratorTestDB(t)
+	defer db.Close()
+
+	it := db.NewIterator(&Range{Start: "block:002", Limit: "tx:b"})
+	defer it.Release()
+
+	if keys := collect(it, true); fmt.Sprint(keys) != "[tx:a block:003 block:002]" {
+		t.Errorf("Expected range in reverse, got %v", keys)
+	}
+
+	if !it.First() || it.Key() != "block:002" {
+		t.Errorf("Expected First to return block:002, got %q", it.Key())
+	}
+
+	if !it.Last() || it.Key() != "tx:a" {
+		t.Errorf("Expected Last to return tx:a, got %q", it.Key())
+	}
+
+	if string(it.Value()) != "value-tx:a" {
+		t.Errorf("Expected value of tx:a, got %q", it.Value())
+	}
+}
+
+func TestSeekPagination(t *testing.T) {
+	db := newIteratorTestDB(t)
+	defer db.Close()
+
+	// Page through block keys two at a time, resuming after the last key seen
+	pages := [][]string{}
+	after := ""
+	for {
+		it := db.NewIterator(PrefixRange("block:"))
+		page := []string{}
+		ok := it.First()
+		if after != "" {
+			ok = it.Seek(after + "\x00")
+		}
+		for ; ok && len(page) < 2; ok = it.Next() {
+			page = append(page, it.Key())
+		}
+		it.Release()
+
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		after = page[len(page)-1]
+	}
+
+	if fmt.Sprint(pages) != "[[block:001 block:002] [block:003]]" {
+		t.Errorf("Expected two pages of blocks, got %v", pages)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	db := newIteratorTestDB(t)
+	defer db.Close()
+
+	snapshot, err := db.GetSnapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	db.Put("block:004", []byte("new"))
+	db.Delete("tx:a")
+	db.Compact()
+
+	if snapshot.Has("block:004") || !snapshot.Has("tx:a") {
+		t.Error("Expected snapshot to show the state when it was taken")
+	}
+
+	it := snapshot.NewIterator(PrefixRange("block:"))
+	snapshot.Release()
+	defer it.Release()
+
+	if keys := collect(it, false); len(keys) != 3 {
+		t.Errorf("Expected 3 blocks in snapshot iterator, got %v", keys)
+	}
+
+	if it.Error() != nil {
+		t.Errorf("Expected iterator to outlive its snapshot, got %v", it.Error())
+	}
+
+	if _, err := snapshot.Get("tx:a"); err != ErrSnapshotReleased {
+		t.Errorf("Expected ErrSnapshotReleased, got %v", err)
+	}
+}
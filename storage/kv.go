@@ -0,0 +1,77 @@
+package storage
+
+// KV is a key-value store. LevelDB keeps data on disk for nodes; MemDB keeps
+// it in memory for tests and tools. Code that persists state should depend
+// on KV so either can be used.
+type KV interface {
+	// Get retrieves a value by key
+	Get(key string) ([]byte, error)
+	// Put stores a key-value pair
+	Put(key string, value []byte) error
+	// Delete removes a key-value pair
+	Delete(key string) error
+	// Has checks if a key exists
+	Has(key string) bool
+	// GetJSON retrieves and decodes a JSON value
+	GetJSON(key string, dest interface{}) error
+	// PutJSON stores a JSON-encoded value
+	PutJSON(key string, value interface{}) error
+	// NewIterator returns an iterator over the keys in r, or every key if r is nil
+	NewIterator(r *Range) Iterator
+	// Write applies a batch atomically
+	Write(batch *WriteBatch) error
+	// GetSnapshot takes a read-only view of the current state
+	GetSnapshot() (Snapshot, error)
+	// Close releases the store
+	Close() error
+}
+
+var (
+	_ KV = (*LevelDB)(nil)
+	_ KV = (*MemDB)(nil)
+)
+
+// Iterate calls fn for every key in r in ascending order, stopping at the
+// first error
+func Iterate(kv KV, r *Range, fn func(key string, value []byte) error) error {
+	it := kv.NewIterator(r)
+	defer it.Release()
+
+	for it.Next() {
+		value := it.Value()
+		if err := it.Error(); err != nil {
+			return err
+		}
+
+		if err := fn(it.Key(), value); err != nil {
+			return err
+		}
+	}
+
+	return it.Error()
+}
+
+// getNode looks up a key in an index and reads its value
+func getNode(root *indexNode, segments map[uint64]*segment, key string) ([]byte, error) {
+	n := indexFind(root, key)
+	if n == nil {
+		return nil, ErrNotFound
+	}
+
+	return readNode(segments, n)
+}
+
+// readNode reads the value of a node from its segment, or from the node
+// itself for in-memory indexes (segments is nil)
+func readNode(segments map[uint64]*segment, n *indexNode) ([]byte, error) {
+	if segments == nil {
+		return append([]byte(nil), n.value...), nil
+	}
+
+	seg, exists := segments[n.loc.segment]
+	if !exists {
+		return nil, errCorruptRecord
+	}
+
+	return seg.readValue(n.loc, n.key)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+// forEachKV runs a test against every KV implementation
+func forEachKV(t *testing.T, test func(t *testing.T, kv KV)) {
+	t.Run("LevelDB", func(t *testing.T) {
+		db := openTestDB(t, t.TempDir())
+		defer db.Close()
+		test(t, db)
+	})
+
+	t.Run("MemDB", func(t *testing.T) {
+		db := NewMemDB()
+		defer db.Close()
+		test(t, db)
+	})
+}
+
+func TestKVBasics(t *testing.T) {
+	forEachKV(t, func(t *testing.T, kv KV) {
+		value := []byte("1")
+		kv.Put("a", value)
+		value[0] = 'x'
+
+		if got, _ := kv.Get("a"); string(got) != "1" {
+			t.Errorf("Expected stored value to be independent of the caller's slice, got %q", got)
+		}
+
+		if err := kv.Put("", nil); err == nil {
+			t.Error("Expected error for empty key")
+		}
+
+		if err := kv.Delete("missing"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+
+		kv.PutJSON("json", map[string]int{"n": 7})
+		var decoded map[string]int
+		if err := kv.GetJSON("json", &decoded); err != nil || decoded["n"] != 7 {
+			t.Errorf("Expected JSON round trip, got %v (%v)", decoded, err)
+		}
+	})
+}
+
+func TestKVBatchAndIterate(t *testing.T) {
+	forEachKV(t, func(t *testing.T, kv KV) {
+		kv.Put("tx:old", []byte("x"))
+
+		batch := NewWriteBatch()
+		batch.Put("tx:1", []byte("a"))
+		batch.Put("tx:2", []byte("b"))
+		batch.Delete("tx:old")
+		batch.Put("block:1", []byte("c"))
+		if err := kv.Write(batch); err != nil {
+			t.Fatalf("Failed to write batch: %v", err)
+		}
+
+		seen := []string{}
+		err := Iterate(kv, PrefixRange("tx:"), func(key string, value []byte) error {
+			seen = append(seen, key+"="+string(value))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to iterate: %v", err)
+		}
+
+		if fmt.Sprint(seen) != "[tx:1=a tx:2=b]" {
+			t.Errorf("Expected tx keys from the batch, got %v", seen)
+		}
+	})
+}
+
+func TestKVSnapshot(t *testing.T) {
+	forEachKV(t, func(t *testing.T, kv KV) {
+		kv.Put("a", []byte("1"))
+
+		snapshot, err := kv.GetSnapshot()
+		if err != nil {
+			t.Fatalf("Failed to take snapshot: %v", err)
+		}
+		defer snapshot.Release()
+
+		kv.Put("a", []byte("2"))
+		kv.Put("b", []byte("3"))
+
+		if value, _ := snapshot.Get("a"); string(value) != "1" {
+			t.Errorf("Expected snapshot value 1, got %q", value)
+		}
+
+		it := snapshot.NewIterator(nil)
+		defer it.Release()
+		if keys := collect(it, false); fmt.Sprint(keys) != "[a]" {
+			t.Errorf("Expected snapshot iterator to see only a, got %v", keys)
+		}
+	})
+}
@@ -3,36 +3,125 @@ package storage
 import (
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
-// LevelDB represents a simple key-value storage
+const (
+	// DefaultMaxSegmentSize is the size at which the active segment is rotated
+	DefaultMaxSegmentSize = 64 << 20
+
+	// compactMinGarbage is the garbage size below which automatic compaction is skipped
+	compactMinGarbage = 4 << 20
+
+	// legacyExt is the extension of values written by the one-file-per-key layout
+	legacyExt = ".dat"
+
+	// compactedFile holds the id of the segment written by the last
+	// compaction. Segments below it were replaced and are never replayed.
+	compactedFile = "COMPACTED"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = errors.New("key not found")
+	// ErrClosed is returned when the database has been closed
+	ErrClosed = errors.New("database is closed")
+)
+
+// LevelDB is an embedded key-value store. Writes are appended to log
+// segments and an in-memory index maps each key to its value on disk, so
+// only keys are held in memory. Keys may contain any bytes. Overwritten and
+// deleted values are reclaimed by compaction.
 type LevelDB struct {
-	dataDir string
-	cache   map[string][]byte
-	mutex   sync.RWMutex
+	dataDir      string
+	segments     map[uint64]*segment
+	retired      map[uint64]*segment // replaced by compaction but still pinned
+	active       *segment
+	root         *indexNode
+	count        int
+	totalBytes   int64
+	garbageBytes int64
+	seq          uint64
+	opts         Options
+	keys         *keyring
+	retireKeys   bool   // keys replaced by a rekey wait for retired segments
+	passphrase   string // wraps the saved keyring
+	report       RecoveryReport
+	dirty        bool
+	syncStop     chan struct{}
+	closed       bool
+	mutex        sync.RWMutex
 }
 
-// NewLevelDB creates a new LevelDB instance
+// NewLevelDB opens the database in dataDir with the default options,
+// creating it if needed
 func NewLevelDB(dataDir string) (*LevelDB, error) {
-	// Create data directory if it doesn't exist
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	return NewLevelDBWithOptions(dataDir, nil)
+}
+
+// NewLevelDBWithOptions opens the database in dataDir, creating it if needed.
+// Opening replays the log; see RecoveryReport for what was recovered.
+func NewLevelDBWithOptions(dataDir string, opts *Options) (*LevelDB, error) {
+	// Create data directory if it doesn't exist, readable by the owner only
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, err
 	}
 
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
 	db := &LevelDB{
-		dataDir: dataDir,
-		cache:   make(map[string][]byte),
+		dataDir:  dataDir,
+		segments: make(map[uint64]*segment),
+		retired:  make(map[uint64]*segment),
+		opts:     *opts,
+	}
+	if db.opts.SyncInterval <= 0 {
+		db.opts.SyncInterval = DefaultSyncInterval
+	}
+	if db.opts.MaxSegmentSize <= 0 {
+		db.opts.MaxSegmentSize = DefaultMaxSegmentSize
+	}
+
+	keys, err := loadKeyring(dataDir, db.opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	encrypt := keys == nil && db.opts.Passphrase != ""
+	if keys == nil {
+		keys = newKeyring(db.opts.EncryptKeys)
+	}
+	db.keys = keys
+	db.passphrase = db.opts.Passphrase
+
+	if err := db.recover(); err != nil {
+		db.closeSegments()
+		return nil, err
 	}
 
-	// Load existing data
-	if err := db.loadFromDisk(); err != nil {
+	if err := db.importLegacy(); err != nil {
+		db.closeSegments()
 		return nil, err
 	}
 
+	if encrypt {
+		if err := db.rekey(db.opts.Passphrase); err != nil {
+			db.closeSegments()
+			return nil, err
+		}
+	}
+
+	if db.opts.SyncPolicy == SyncPeriodic {
+		db.syncStop = make(chan struct{})
+		go db.syncLoop(db.opts.SyncInterval, db.syncStop)
+	}
+
 	return db, nil
 }
 
@@ -45,8 +134,7 @@ func (db *LevelDB) Put(key string, value []byte) error {
 		return errors.New("key cannot be empty")
 	}
 
-	db.cache[key] = value
-	return db.saveToDisk(key, value)
+	return db.write(recordPut, key, value)
 }
 
 // Get retrieves a value by key
@@ -54,12 +142,11 @@ func (db *LevelDB) Get(key string) ([]byte, error) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 
-	value, exists := db.cache[key]
-	if !exists {
-		return nil, errors.New("key not found")
+	if db.closed {
+		return nil, ErrClosed
 	}
 
-	return value, nil
+	return getNode(db.root, db.segments, key)
 }
 
 // Delete removes a key-value pair
@@ -67,15 +154,15 @@ func (db *LevelDB) Delete(key string) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	if _, exists := db.cache[key]; !exists {
-		return errors.New("key not found")
+	if db.closed {
+		return ErrClosed
+	}
+
+	if _, exists := indexGet(db.root, key); !exists {
+		return ErrNotFound
 	}
 
-	delete(db.cache, key)
-	
-	// Remove from disk
-	filePath := filepath.Join(db.dataDir, key+".dat")
-	return os.Remove(filePath)
+	return db.write(recordDelete, key, nil)
 }
 
 // Has checks if a key exists
@@ -83,100 +170,450 @@ func (db *LevelDB) Has(key string) bool {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 
-	_, exists := db.cache[key]
+	_, exists := indexGet(db.root, key)
 	return exists
 }
 
-// Keys returns all keys
+// Keys returns all keys in ascending order
 func (db *LevelDB) Keys() []string {
+	it := db.NewIterator(nil)
+	defer it.Release()
+
+	keys := make([]string, 0, db.Size())
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+
+	return keys
+}
+
+// PutJSON stores a JSON-encoded value
+func (db *LevelDB) PutJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return db.Put(key, data)
+}
+
+// GetJSON retrieves and decodes a JSON value
+func (db *LevelDB) GetJSON(key string, dest interface{}) error {
+	data, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// Compact rewrites the live values into a fresh segment and removes the
+// segments they came from
+func (db *LevelDB) Compact() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	return db.compact()
+}
+
+// Rekey rotates the data key and re-encrypts every record with it, wrapping
+// the key with a new passphrase. An empty passphrase decrypts the database.
+func (db *LevelDB) Rekey(passphrase string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	return db.rekey(passphrase)
+}
+
+// Close syncs and closes the database
+func (db *LevelDB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	if db.syncStop != nil {
+		close(db.syncStop)
+	}
+
+	err := db.active.file.Sync()
+	db.closeSegments()
+	db.root = nil
+	db.count = 0
+
+	return err
+}
+
+// Size returns the number of key-value pairs
+func (db *LevelDB) Size() int {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 
-	keys := make([]string, 0, len(db.cache))
-	for key := range db.cache {
-		keys = append(keys, key)
+	return db.count
+}
+
+// write appends a put or delete to the active segment; callers must hold the write lock
+func (db *LevelDB) write(kind byte, key string, value []byte) error {
+	op, err := db.keys.sealOp(batchOp{kind: kind, key: key, value: value})
+	if err != nil {
+		return err
 	}
+	return db.appendRecord(encodeRecord(op.kind, op.key, op.value))
+}
 
-	return keys
+// appendRecord appends an encoded record to the active segment and applies
+// its operations to the index; callers must hold the write lock
+func (db *LevelDB) appendRecord(record []byte) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	if db.active.size >= db.opts.MaxSegmentSize {
+		if err := db.rotate(); err != nil {
+			return err
+		}
+	}
+
+	offset := db.active.size
+	if _, err := db.active.file.Write(record); err != nil {
+		// Drop any partial record so later appends do not follow garbage
+		db.active.file.Truncate(offset)
+		return err
+	}
+	db.active.size += int64(len(record))
+
+	switch db.opts.SyncPolicy {
+	case SyncAlways:
+		if err := db.active.file.Sync(); err != nil {
+			return err
+		}
+	case SyncPeriodic:
+		db.dirty = true
+	}
+
+	recs, err := decodeRecord(db.active, offset, record[8], record[recordHeaderSize:])
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		db.apply(rec)
+	}
+
+	if db.garbageBytes > compactMinGarbage && db.garbageBytes*2 > db.totalBytes {
+		return db.compact()
+	}
+
+	return nil
 }
 
-// saveToDisk saves a key-value pair to disk
-func (db *LevelDB) saveToDisk(key string, value []byte) error {
-	filePath := filepath.Join(db.dataDir, key+".dat")
-	return ioutil.WriteFile(filePath, value, 0644)
+// apply updates the index and space accounting for a record
+func (db *LevelDB) apply(rec *scannedRecord) {
+	db.totalBytes += rec.loc.recordBytes
+	db.seq++
+	rec.loc.seq = db.seq
+
+	var old location
+	var existed bool
+	if rec.kind == recordPut {
+		db.root, old, existed = indexPut(db.root, rec.key, rec.loc, nil)
+		if !existed {
+			db.count++
+		}
+	} else {
+		db.root, old, existed = indexDelete(db.root, rec.key)
+		if existed {
+			db.count--
+		}
+		// A tombstone is garbage as soon as it is written
+		db.garbageBytes += rec.loc.recordBytes
+	}
+
+	if existed {
+		db.garbageBytes += old.recordBytes
+	}
 }
 
-// loadFromDisk loads all data from disk
-func (db *LevelDB) loadFromDisk() error {
-	files, err := ioutil.ReadDir(db.dataDir)
+// rotate starts a new active segment; callers must hold the write lock
+func (db *LevelDB) rotate() error {
+	if err := db.active.file.Sync(); err != nil {
+		return err
+	}
+
+	seg, err := createSegment(db.dataDir, db.active.id+1, db.keys)
 	if err != nil {
 		return err
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	db.segments[seg.id] = seg
+	db.active = seg
+	return nil
+}
+
+// compact copies every live value into a new segment, then retires the old
+// segments. Segments still read by iterators are removed on release.
+func (db *LevelDB) compact() error {
+	tmpPath := filepath.Join(db.dataDir, "compact.tmp")
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	id := db.active.id + 1
+	root := (*indexNode)(nil)
+	offset := int64(0)
+	target := &segment{id: id, keys: db.keys}
+
+	err = indexWalk(db.root, func(n *indexNode) error {
+		value, err := readNode(db.segments, n)
+		if err != nil {
+			return err
 		}
 
-		fileName := file.Name()
-		if filepath.Ext(fileName) != ".dat" {
-			continue
+		// Values are sealed again with the current key
+		op, err := db.keys.sealOp(batchOp{kind: recordPut, key: n.key, value: value})
+		if err != nil {
+			return err
+		}
+		record := encodeRecord(op.kind, op.key, op.value)
+		if _, err := tmp.Write(record); err != nil {
+			return err
 		}
 
-		key := fileName[:len(fileName)-4]
-		filePath := filepath.Join(db.dataDir, fileName)
-		
-		value, err := ioutil.ReadFile(filePath)
+		rec, err := decodeOp(target, offset, op.kind, record[recordHeaderSize:])
 		if err != nil {
-			continue
+			return err
 		}
+		rec.loc.seq = n.loc.seq
+		root, _, _ = indexPut(root, rec.key, rec.loc, nil)
+		offset += int64(len(record))
+		return nil
+	})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	tmp.Close()
 
-		db.cache[key] = value
+	// The compacted segment sorts after every segment it replaces, so a crash
+	// before it is recorded replays to the same state
+	if err := os.Rename(tmpPath, filepath.Join(db.dataDir, segmentName(id))); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(db.dataDir); err != nil {
+		return err
+	}
+
+	// Once recorded, the old segments are never replayed again, so deleted
+	// keys they still hold cannot come back even if they outlive a crash
+	if err := writeFileAtomic(filepath.Join(db.dataDir, compactedFile), []byte(strconv.FormatUint(id, 10))); err != nil {
+		return err
+	}
+
+	compacted, err := openSegment(db.dataDir, id, db.keys)
+	if err != nil {
+		return err
+	}
+	active, err := createSegment(db.dataDir, id+1, db.keys)
+	if err != nil {
+		compacted.file.Close()
+		return err
 	}
 
+	oldIDs := make([]uint64, 0, len(db.segments))
+	for oldID := range db.segments {
+		oldIDs = append(oldIDs, oldID)
+	}
+	sort.Slice(oldIDs, func(i, j int) bool { return oldIDs[i] < oldIDs[j] })
+
+	for _, oldID := range oldIDs {
+		seg := db.segments[oldID]
+		delete(db.segments, oldID)
+		seg.obsolete = true
+		if seg.refs == 0 {
+			seg.remove()
+		} else {
+			db.retired[oldID] = seg
+		}
+	}
+	if err := syncDir(db.dataDir); err != nil {
+		return err
+	}
+
+	db.segments[compacted.id] = compacted
+	db.segments[active.id] = active
+	db.active = active
+	db.root = root
+	db.totalBytes = offset
+	db.garbageBytes = 0
+
 	return nil
 }
 
-// PutJSON stores a JSON-encoded value
-func (db *LevelDB) PutJSON(key string, value interface{}) error {
-	data, err := json.Marshal(value)
-	if err != nil {
+// rekey switches to a fresh data key wrapped with passphrase, or to
+// plaintext if passphrase is empty, and rewrites every record with it.
+// Callers must hold the write lock.
+func (db *LevelDB) rekey(passphrase string) error {
+	if passphrase == "" {
+		if !db.keys.sealing() {
+			return nil
+		}
+
+		db.keys.mutex.Lock()
+		db.keys.current = 0
+		db.keys.mutex.Unlock()
+	} else {
+		if err := db.keys.generateKey(); err != nil {
+			return err
+		}
+		db.keys.mutex.Lock()
+		db.keys.encryptKeys = db.opts.EncryptKeys || db.keys.encryptKeys
+		db.keys.mutex.Unlock()
+
+		// Save the old keys alongside the new one until every segment sealed
+		// with them has been rewritten, so a crash midway stays readable
+		if err := db.keys.save(db.dataDir, passphrase); err != nil {
+			return err
+		}
+		db.passphrase = passphrase
+	}
+
+	if err := db.compact(); err != nil {
 		return err
 	}
 
-	return db.Put(key, data)
+	db.retireKeys = true
+	return db.retireOldKeys()
 }
 
-// GetJSON retrieves and decodes a JSON value
-func (db *LevelDB) GetJSON(key string, dest interface{}) error {
-	data, err := db.Get(key)
+// retireOldKeys drops the keys replaced by a rekey from the saved keyring,
+// or removes the keyring once the database is plaintext. It waits until no
+// segment sealed with them is left on disk; callers must hold the write lock.
+func (db *LevelDB) retireOldKeys() error {
+	if !db.retireKeys || len(db.retired) > 0 {
+		return nil
+	}
+
+	if !db.keys.sealing() {
+		if err := os.Remove(filepath.Join(db.dataDir, keyringFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := syncDir(db.dataDir); err != nil {
+			return err
+		}
+	} else {
+		db.keys.retain()
+		if err := db.keys.save(db.dataDir, db.passphrase); err != nil {
+			return err
+		}
+	}
+
+	db.retireKeys = false
+	return nil
+}
+
+// importLegacy moves values written by the one-file-per-key layout into the log
+func (db *LevelDB) importLegacy() error {
+	entries, err := os.ReadDir(db.dataDir)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(data, dest)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != legacyExt {
+			continue
+		}
+
+		path := filepath.Join(db.dataDir, name)
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		key := name[:len(name)-len(legacyExt)]
+		if _, exists := indexGet(db.root, key); !exists {
+			if err := db.write(recordPut, key, value); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return db.active.file.Sync()
 }
 
-// Close closes the database
-func (db *LevelDB) Close() error {
+// syncLoop flushes pending writes every interval until stop is closed
+func (db *LevelDB) syncLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mutex.Lock()
+			if !db.closed && db.dirty {
+				db.active.file.Sync()
+				db.dirty = false
+			}
+			db.mutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// pin marks segments as in use so compaction keeps them until unpin and
+// returns a copy of the set; callers must hold the write lock
+func (db *LevelDB) pin(pinned map[uint64]*segment) map[uint64]*segment {
+	segments := make(map[uint64]*segment, len(pinned))
+	for id, seg := range pinned {
+		seg.refs++
+		segments[id] = seg
+	}
+	return segments
+}
+
+// unpin releases segments returned by pin, removes the ones compaction
+// retired and then retires the keys only they were sealed with
+func (db *LevelDB) unpin(segments map[uint64]*segment) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	// Flush any pending writes
-	for key, value := range db.cache {
-		if err := db.saveToDisk(key, value); err != nil {
-			return err
+	for _, seg := range segments {
+		seg.refs--
+		if seg.obsolete && seg.refs == 0 {
+			seg.remove()
+			delete(db.retired, seg.id)
 		}
 	}
 
-	db.cache = make(map[string][]byte)
-	return nil
+	if !db.closed {
+		db.retireOldKeys() //nolint:errcheck // retried on the next release or rekey
+	}
 }
 
-// Size returns the number of key-value pairs
-func (db *LevelDB) Size() int {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
-
-	return len(db.cache)
+// closeSegments closes every segment file
+func (db *LevelDB) closeSegments() {
+	for _, seg := range db.segments {
+		seg.file.Close()
+	}
 }
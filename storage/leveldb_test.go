@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T, dir string) *LevelDB {
+	db, err := NewLevelDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	if err := db.Put("block/1", []byte("genesis")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	value, err := db.Get("block/1")
+	if err != nil || string(value) != "genesis" {
+		t.Errorf("Expected genesis, got %q (%v)", value, err)
+	}
+
+	if err := db.Delete("block/1"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if db.Has("block/1") {
+		t.Error("Expected key to be deleted")
+	}
+
+	if _, err := db.Get("block/1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := db.Delete("block/1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting a missing key, got %v", err)
+	}
+}
+
+func TestBinaryKeysSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	keys := []string{"a/b/../c", "\x00\xff\x10", "tx:" + string([]byte{0, 1, 2})}
+	for i, key := range keys {
+		db.Put(key, []byte(fmt.Sprintf("v%d", i)))
+	}
+	db.Put(keys[0], []byte("overwritten"))
+	db.Close()
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if db.Size() != len(keys) {
+		t.Errorf("Expected %d keys after reopen, got %d", len(keys), db.Size())
+	}
+
+	if value, _ := db.Get(keys[0]); string(value) != "overwritten" {
+		t.Errorf("Expected latest value after reopen, got %q", value)
+	}
+
+	if value, _ := db.Get(keys[1]); string(value) != "v1" {
+		t.Errorf("Expected v1 for binary key, got %q", value)
+	}
+}
+
+func TestKeysAreOrdered(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	for _, key := range []string{"tx:2", "block:2", "tx:1", "block:10", "block:1"} {
+		db.Put(key, []byte(key))
+	}
+
+	expected := []string{"block:1", "block:10", "block:2", "tx:1", "tx:2"}
+	keys := db.Keys()
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
+
+func TestIteratorReadsStateAtCreation(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	db.Put("a", []byte("1"))
+	db.Put("b", []byte("2"))
+
+	it := db.NewIterator(nil)
+	defer it.Release()
+
+	db.Put("a", []byte("changed"))
+	db.Delete("b")
+	db.Put("c", []byte("3"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	seen := map[string]string{}
+	for it.Next() {
+		seen[it.Key()] = string(it.Value())
+	}
+
+	if err := it.Error(); err != nil {
+		t.Fatalf("Iterator error: %v", err)
+	}
+
+	if len(seen) != 2 || seen["a"] != "1" || seen["b"] != "2" {
+		t.Errorf("Expected iterator to see the original a and b, got %v", seen)
+	}
+}
+
+func TestCompactReclaimsSpace(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	value := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		db.Put("key", value)
+	}
+	db.Put("other", []byte("kept"))
+	db.Delete("other")
+	db.Put("last", []byte("kept"))
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	ids, _ := listSegments(dir)
+	total := int64(0)
+	for _, id := range ids {
+		info, _ := os.Stat(filepath.Join(dir, segmentName(id)))
+		total += info.Size()
+	}
+
+	if total > 2*1024 {
+		t.Errorf("Expected compaction to drop overwritten values, %d bytes remain", total)
+	}
+	db.Close()
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if db.Size() != 2 || db.Has("other") {
+		t.Errorf("Expected key and last after reopen, got %v", db.Keys())
+	}
+}
+
+func TestCompactedSegmentsAreNotReplayed(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.MaxSegmentSize = 1
+
+	db, err := NewLevelDBWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.Put("k", []byte("1"))
+	db.Put("other", []byte("2"))
+	db.Delete("k")
+
+	// Keep the segment holding k, as a crash before its removal would
+	first, _ := os.ReadFile(filepath.Join(dir, segmentName(1)))
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	db.Close()
+
+	os.WriteFile(filepath.Join(dir, segmentName(1)), first, 0600)
+
+	db, err = NewLevelDBWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if db.Has("k") || !db.Has("other") {
+		t.Errorf("Expected only other after reopen, got %v", db.Keys())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, segmentName(1))); !os.IsNotExist(err) {
+		t.Error("Expected the replaced segment to be deleted on open")
+	}
+}
+
+func TestTornWriteIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	db.Put("a", []byte("1"))
+	db.Put("b", []byte("2"))
+	db.Close()
+
+	// Cut the last record short, as a crash mid-append would
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if !db.Has("a") || db.Has("b") {
+		t.Errorf("Expected only a to survive, got %v", db.Keys())
+	}
+
+	if err := db.Put("c", []byte("3")); err != nil {
+		t.Fatalf("Failed to write after recovery: %v", err)
+	}
+}
+
+func TestImportsLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "goldcoin_staking_pool.dat"), []byte(`{"nextId":3}`), 0644)
+
+	db := openTestDB(t, dir)
+	defer db.Close()
+
+	var snapshot map[string]int
+	if err := db.GetJSON("goldcoin_staking_pool", &snapshot); err != nil || snapshot["nextId"] != 3 {
+		t.Errorf("Expected legacy value to be imported, got %v (%v)", snapshot, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "goldcoin_staking_pool.dat")); !os.IsNotExist(err) {
+		t.Error("Expected legacy file to be removed after import")
+	}
+}
+
+func TestClosedDatabase(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	db.Close()
+
+	if err := db.Put("a", nil); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	if _, err := db.Get("a"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// MemDB is an in-memory key-value store with the same behaviour as LevelDB,
+// for tests and tools that do not need data to outlive the process
+type MemDB struct {
+	root   *indexNode
+	count  int
+	closed bool
+	mutex  sync.RWMutex
+}
+
+// NewMemDB creates an empty in-memory store
+func NewMemDB() *MemDB {
+	return &MemDB{}
+}
+
+// Put stores a key-value pair
+func (db *MemDB) Put(key string, value []byte) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	db.put(key, value)
+	return nil
+}
+
+// Get retrieves a value by key
+func (db *MemDB) Get(key string) ([]byte, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	return getNode(db.root, nil, key)
+}
+
+// Delete removes a key-value pair
+func (db *MemDB) Delete(key string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if !db.delete(key) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Has checks if a key exists
+func (db *MemDB) Has(key string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	_, exists := indexGet(db.root, key)
+	return exists
+}
+
+// Keys returns all keys in ascending order
+func (db *MemDB) Keys() []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	keys := make([]string, 0, db.count)
+	indexWalk(db.root, func(n *indexNode) error {
+		keys = append(keys, n.key)
+		return nil
+	})
+	return keys
+}
+
+// PutJSON stores a JSON-encoded value
+func (db *MemDB) PutJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return db.Put(key, data)
+}
+
+// GetJSON retrieves and decodes a JSON value
+func (db *MemDB) GetJSON(key string, dest interface{}) error {
+	data, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// NewIterator returns an iterator over the keys in r, or every key if r is nil
+func (db *MemDB) NewIterator(r *Range) Iterator {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return errIterator(ErrClosed)
+	}
+
+	return newIterator(db.root, nil, nil, r)
+}
+
+// Write applies a batch atomically
+func (db *MemDB) Write(batch *WriteBatch) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	for _, op := range batch.ops {
+		if op.key == "" {
+			return errors.New("key cannot be empty")
+		}
+	}
+
+	for _, op := range batch.ops {
+		if op.kind == recordPut {
+			db.put(op.key, op.value)
+		} else {
+			db.delete(op.key)
+		}
+	}
+
+	return nil
+}
+
+// GetSnapshot takes a snapshot of the current state of the store
+func (db *MemDB) GetSnapshot() (Snapshot, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	return &memSnapshot{root: db.root}, nil
+}
+
+// Close drops the contents of the store
+func (db *MemDB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.closed = true
+	db.root = nil
+	db.count = 0
+	return nil
+}
+
+// Size returns the number of key-value pairs
+func (db *MemDB) Size() int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return db.count
+}
+
+// put stores a copy of value; callers must hold the write lock
+func (db *MemDB) put(key string, value []byte) {
+	var existed bool
+	db.root, _, existed = indexPut(db.root, key, location{}, append([]byte(nil), value...))
+	if !existed {
+		db.count++
+	}
+}
+
+// delete removes a key; callers must hold the write lock
+func (db *MemDB) delete(key string) bool {
+	var existed bool
+	db.root, _, existed = indexDelete(db.root, key)
+	if existed {
+		db.count--
+	}
+	return existed
+}
+
+// memSnapshot is a snapshot of a MemDB. The index is immutable, so holding
+// the root is enough.
+type memSnapshot struct {
+	root     *indexNode
+	released bool
+}
+
+// Get retrieves a value by key
+func (s *memSnapshot) Get(key string) ([]byte, error) {
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	return getNode(s.root, nil, key)
+}
+
+// GetJSON retrieves and decodes a JSON value
+func (s *memSnapshot) GetJSON(key string, dest interface{}) error {
+	data, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// Has checks if a key exists
+func (s *memSnapshot) Has(key string) bool {
+	_, exists := indexGet(s.root, key)
+	return !s.released && exists
+}
+
+// NewIterator returns an iterator over the keys of the snapshot in r
+func (s *memSnapshot) NewIterator(r *Range) Iterator {
+	if s.released {
+		return errIterator(ErrSnapshotReleased)
+	}
+
+	return newIterator(s.root, nil, nil, r)
+}
+
+// Release frees the snapshot
+func (s *memSnapshot) Release() {
+	s.released = true
+}
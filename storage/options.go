@@ -0,0 +1,50 @@
+package storage
+
+import "time"
+
+// SyncPolicy decides when writes are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write, so an acknowledged write survives
+	// a crash or power loss
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic fsyncs in the background every SyncInterval; a crash
+	// loses at most the writes of the last interval
+	SyncPeriodic
+	// SyncNever leaves flushing to the operating system and Close; writes
+	// survive a process crash but not a power loss
+	SyncNever
+)
+
+// DefaultSyncInterval is the flush interval of SyncPeriodic when none is set
+const DefaultSyncInterval = time.Second
+
+// Options configures a database
+type Options struct {
+	// SyncPolicy decides when writes are fsynced
+	SyncPolicy SyncPolicy
+	// SyncInterval is the flush interval of SyncPeriodic
+	SyncInterval time.Duration
+	// MaxSegmentSize is the size at which the log rotates to a new segment
+	MaxSegmentSize int64
+	// RepairCorruption opens a database with damaged records by discarding
+	// the rest of each damaged segment instead of failing
+	RepairCorruption bool
+	// Passphrase encrypts values at rest. A plaintext database opened with a
+	// passphrase is encrypted in place; an encrypted one cannot be opened
+	// without it.
+	Passphrase string
+	// EncryptKeys also encrypts keys when encryption is first enabled or the
+	// database is rekeyed. Keys stay in plaintext in memory.
+	EncryptKeys bool
+}
+
+// DefaultOptions returns the options used by NewLevelDB
+func DefaultOptions() *Options {
+	return &Options{
+		SyncPolicy:     SyncAlways,
+		SyncInterval:   DefaultSyncInterval,
+		MaxSegmentSize: DefaultMaxSegmentSize,
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CorruptionError reports a damaged record found while opening a database
+type CorruptionError struct {
+	Segment string
+	Offset  int64
+}
+
+// Error implements the error interface
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupt record in segment %s at offset %d", e.Segment, e.Offset)
+}
+
+// RecoveryReport describes what was replayed when a database was opened
+type RecoveryReport struct {
+	Segments int
+	Records  int
+	// TornBytes is the size of a partly written record discarded from the end of the log
+	TornBytes int64
+	// Corruptions lists damaged records skipped with Options.RepairCorruption
+	Corruptions []*CorruptionError
+	// DiscardedBytes is the data dropped from damaged segments in repair mode
+	DiscardedBytes int64
+}
+
+// RecoveryReport returns what was recovered when the database was opened
+func (db *LevelDB) RecoveryReport() RecoveryReport {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	report := db.report
+	report.Corruptions = append([]*CorruptionError(nil), db.report.Corruptions...)
+	return report
+}
+
+// recover rebuilds the index by replaying every live segment in order.
+// Segments replaced by the last compaction are deleted unread. A partly
+// written record at the end of the log is a write cut short by a crash and is
+// discarded. Any other damage fails the open with a *CorruptionError unless
+// RepairCorruption is set, in which case the rest of the segment is dropped.
+func (db *LevelDB) recover() error {
+	ids, err := listSegments(db.dataDir)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(db.dataDir, "compact.tmp"))
+
+	ids, err = db.dropCompacted(ids)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		seg, err := openSegment(db.dataDir, id, db.keys)
+		if err != nil {
+			return err
+		}
+		db.segments[id] = seg
+		db.report.Segments++
+
+		end, err := scanSegment(seg, func(rec *scannedRecord) error {
+			db.apply(rec)
+			db.report.Records++
+			return nil
+		})
+
+		switch {
+		case err == nil:
+
+		case err == errTornRecord && i == len(ids)-1:
+			db.report.TornBytes = seg.size - end
+			if err := seg.truncate(end); err != nil {
+				return err
+			}
+
+		case err == errTornRecord || err == errCorruptRecord:
+			corruption := &CorruptionError{Segment: segmentName(id), Offset: end}
+			if !db.opts.RepairCorruption {
+				return corruption
+			}
+
+			db.report.Corruptions = append(db.report.Corruptions, corruption)
+			db.report.DiscardedBytes += seg.size - end
+			if err := seg.truncate(end); err != nil {
+				return err
+			}
+
+		default:
+			return err
+		}
+
+		db.active = seg
+	}
+
+	if db.active == nil {
+		seg, err := createSegment(db.dataDir, 1, db.keys)
+		if err != nil {
+			return err
+		}
+		db.segments[seg.id] = seg
+		db.active = seg
+	}
+
+	return nil
+}
+
+// dropCompacted deletes the segments below the one recorded by the last
+// compaction and returns the ids that remain
+func (db *LevelDB) dropCompacted(ids []uint64) ([]uint64, error) {
+	data, err := os.ReadFile(filepath.Join(db.dataDir, compactedFile))
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s file: %v", compactedFile, err)
+	}
+
+	live := ids[:0]
+	for _, id := range ids {
+		if id >= base {
+			live = append(live, id)
+			continue
+		}
+		if err := os.Remove(filepath.Join(db.dataDir, segmentName(id))); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if len(live) < len(ids) {
+		if err := syncDir(db.dataDir); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoveryReportsTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	db.Put("a", []byte("1"))
+	db.Put("b", []byte("2"))
+	db.Close()
+
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-2)
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	report := db.RecoveryReport()
+	if report.Records != 1 || report.TornBytes == 0 {
+		t.Errorf("Expected 1 record replayed and a torn tail, got %+v", report)
+	}
+
+	if len(report.Corruptions) != 0 {
+		t.Errorf("Expected a torn tail not to count as corruption, got %v", report.Corruptions)
+	}
+}
+
+func TestRecoveryRefusesCorruption(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	db.Put("a", []byte("first"))
+	db.Put("b", []byte("second"))
+	db.Close()
+
+	// Flip a byte inside the first record's value
+	path := filepath.Join(dir, segmentName(1))
+	data, _ := os.ReadFile(path)
+	data[recordHeaderSize+3] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	_, err := NewLevelDB(dir)
+	if _, ok := err.(*CorruptionError); !ok {
+		t.Fatalf("Expected *CorruptionError, got %v", err)
+	}
+
+	db, err = NewLevelDBWithOptions(dir, &Options{RepairCorruption: true})
+	if err != nil {
+		t.Fatalf("Failed to open in repair mode: %v", err)
+	}
+	defer db.Close()
+
+	report := db.RecoveryReport()
+	if len(report.Corruptions) != 1 || report.Corruptions[0].Offset != 0 || report.DiscardedBytes == 0 {
+		t.Errorf("Expected the damaged segment tail to be reported, got %+v", report)
+	}
+
+	if db.Size() != 0 {
+		t.Errorf("Expected records after the damage to be discarded, got %v", db.Keys())
+	}
+}
+
+func TestSyncPeriodic(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewLevelDBWithOptions(dir, &Options{SyncPolicy: SyncPeriodic, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	db.Put("a", []byte("1"))
+	time.Sleep(30 * time.Millisecond)
+
+	db.mutex.RLock()
+	dirty := db.dirty
+	db.mutex.RUnlock()
+	if dirty {
+		t.Error("Expected background sync to flush the write")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	if !db.Has("a") {
+		t.Error("Expected write to survive reopen")
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// schemaVersionPrefix prefixes the key holding the version of a schema
+const schemaVersionPrefix = "schema_version:"
+
+// ErrSchemaTooNew is returned when stored data was written by a newer
+// schema version than the running code knows
+var ErrSchemaTooNew = errors.New("data was written by a newer schema version")
+
+// Migration upgrades stored data by one schema version
+type Migration struct {
+	// Version is the schema version the migration produces
+	Version int
+	// Description says what the migration changes
+	Description string
+	// Migrate reads the data as it was before the migration from r and
+	// stages the changes in batch. It may be nil for a version that only
+	// marks the existing layout.
+	Migrate func(r Snapshot, batch *WriteBatch) error
+}
+
+// MigrationReport describes the migrations run, or that would be run, on a store
+type MigrationReport struct {
+	Schema  string
+	From    int
+	To      int
+	Applied []*Migration
+	// Changes is the number of puts and deletes staged by the migrations
+	Changes int
+	DryRun  bool
+}
+
+// Schema is the versioned layout of the data one component persists. Each
+// store records the version its data was written with, and migrations
+// registered in order bring older data up to date.
+type Schema struct {
+	name       string
+	migrations []*Migration
+	mutex      sync.RWMutex
+}
+
+// NewSchema creates a schema with no migrations. The name keeps the stored
+// version separate from other schemas sharing the store.
+func NewSchema(name string) *Schema {
+	return &Schema{name: name}
+}
+
+// Name returns the name of the schema
+func (s *Schema) Name() string {
+	return s.name
+}
+
+// Register adds the migration to the next version. Migrations must be
+// registered in version order starting at 1.
+func (s *Schema) Register(m *Migration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m.Version != len(s.migrations)+1 {
+		return fmt.Errorf("schema %s: expected migration to version %d, got %d", s.name, len(s.migrations)+1, m.Version)
+	}
+
+	s.migrations = append(s.migrations, m)
+	return nil
+}
+
+// Version returns the latest schema version the registered migrations produce
+func (s *Schema) Version() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.migrations)
+}
+
+// StoredVersion returns the version of the data in kv, or 0 if none was recorded
+func (s *Schema) StoredVersion(kv KV) (int, error) {
+	version := 0
+	err := kv.GetJSON(s.versionKey(), &version)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Migrate runs every pending migration on kv. Each migration is written
+// together with its version in one batch, so an interrupted upgrade resumes
+// from the last completed version. Data written by a newer version fails
+// with ErrSchemaTooNew and is left untouched.
+func (s *Schema) Migrate(kv KV) (*MigrationReport, error) {
+	return s.migrate(kv, false)
+}
+
+// DryRun runs the pending migrations on an in-memory copy of kv and reports
+// what they would change, leaving kv untouched
+func (s *Schema) DryRun(kv KV) (*MigrationReport, error) {
+	return s.migrate(kv, true)
+}
+
+// migrate runs the pending migrations on kv, or on a copy of it for a dry run
+func (s *Schema) migrate(kv KV, dryRun bool) (*MigrationReport, error) {
+	s.mutex.RLock()
+	migrations := s.migrations
+	s.mutex.RUnlock()
+
+	from, err := s.StoredVersion(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{Schema: s.name, From: from, To: from, DryRun: dryRun}
+	if from > len(migrations) {
+		return nil, fmt.Errorf("%w: schema %s is at version %d, this build supports up to %d", ErrSchemaTooNew, s.name, from, len(migrations))
+	}
+	if from == len(migrations) {
+		return report, nil
+	}
+
+	target := kv
+	if dryRun {
+		target, err = copyToMemDB(kv)
+		if err != nil {
+			return nil, err
+		}
+		defer target.Close()
+	}
+
+	for _, m := range migrations[from:] {
+		batch := NewWriteBatch()
+		if m.Migrate != nil {
+			snapshot, err := target.GetSnapshot()
+			if err != nil {
+				return report, err
+			}
+			err = m.Migrate(snapshot, batch)
+			snapshot.Release()
+			if err != nil {
+				return report, fmt.Errorf("schema %s: migration to version %d failed: %w", s.name, m.Version, err)
+			}
+		}
+		report.Changes += batch.Len()
+
+		if err := batch.PutJSON(s.versionKey(), m.Version); err != nil {
+			return report, err
+		}
+		if err := target.Write(batch); err != nil {
+			return report, err
+		}
+
+		report.To = m.Version
+		report.Applied = append(report.Applied, m)
+	}
+
+	return report, nil
+}
+
+// versionKey returns the key holding the stored version
+func (s *Schema) versionKey() string {
+	return schemaVersionPrefix + s.name
+}
+
+// copyToMemDB copies the current contents of kv into a new MemDB
+func copyToMemDB(kv KV) (*MemDB, error) {
+	snapshot, err := kv.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	mem := NewMemDB()
+	it := snapshot.NewIterator(nil)
+	defer it.Release()
+
+	for it.Next() {
+		mem.put(it.Key(), it.Value())
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	return mem, nil
+}
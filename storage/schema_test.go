@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newTestSchema renames "name:" keys to "user:" at version 2
+func newTestSchema(t *testing.T) *Schema {
+	schema := NewSchema("test")
+	migrations := []*Migration{
+		{Version: 1, Description: "initial layout"},
+		{Version: 2, Description: "rename name: keys to user:", Migrate: func(r Snapshot, batch *WriteBatch) error {
+			it := r.NewIterator(PrefixRange("name:"))
+			defer it.Release()
+			for it.Next() {
+				batch.Put("user:"+strings.TrimPrefix(it.Key(), "name:"), it.Value())
+				batch.Delete(it.Key())
+			}
+			return it.Error()
+		}},
+	}
+
+	for _, m := range migrations {
+		if err := schema.Register(m); err != nil {
+			t.Fatalf("Failed to register migration: %v", err)
+		}
+	}
+	return schema
+}
+
+func TestSchemaMigrate(t *testing.T) {
+	forEachKV(t, func(t *testing.T, kv KV) {
+		schema := newTestSchema(t)
+		kv.Put("name:alice", []byte("1"))
+
+		report, err := schema.Migrate(kv)
+		if err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+
+		if report.From != 0 || report.To != 2 || len(report.Applied) != 2 || report.Changes != 2 {
+			t.Errorf("Expected migration 0 -> 2 with 2 changes, got %+v", report)
+		}
+
+		if !kv.Has("user:alice") || kv.Has("name:alice") {
+			t.Error("Expected name:alice to be renamed to user:alice")
+		}
+
+		if version, _ := schema.StoredVersion(kv); version != 2 {
+			t.Errorf("Expected stored version 2, got %d", version)
+		}
+
+		// Running again is a no-op
+		report, _ = schema.Migrate(kv)
+		if len(report.Applied) != 0 {
+			t.Errorf("Expected no pending migrations, got %d", len(report.Applied))
+		}
+	})
+}
+
+func TestSchemaDryRun(t *testing.T) {
+	kv := NewMemDB()
+	kv.Put("name:alice", []byte("1"))
+
+	report, err := newTestSchema(t).DryRun(kv)
+	if err != nil {
+		t.Fatalf("Failed dry run: %v", err)
+	}
+
+	if !report.DryRun || report.To != 2 || report.Changes != 2 {
+		t.Errorf("Expected dry run report to version 2, got %+v", report)
+	}
+
+	if !kv.Has("name:alice") || kv.Has("user:alice") || kv.Has(schemaVersionPrefix+"test") {
+		t.Error("Expected dry run to leave the store untouched")
+	}
+}
+
+func TestSchemaRefusesNewerData(t *testing.T) {
+	kv := NewMemDB()
+	kv.PutJSON(schemaVersionPrefix+"test", 3)
+
+	if _, err := newTestSchema(t).Migrate(kv); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestSchemaRegisterOrder(t *testing.T) {
+	schema := NewSchema("test")
+	if err := schema.Register(&Migration{Version: 2}); err == nil {
+		t.Error("Expected error registering version 2 before version 1")
+	}
+}
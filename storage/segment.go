@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record kinds stored in a segment
+const (
+	recordPut    byte = 1
+	recordDelete byte = 2
+	recordBatch  byte = 3
+)
+
+const (
+	// recordHeaderSize is crc32 (4) + body length (4) + kind (1)
+	recordHeaderSize = 9
+
+	segmentExt = ".log"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// errCorruptRecord is returned when a record fails its checksum or cannot be decoded
+	errCorruptRecord = errors.New("corrupt record")
+	// errTornRecord is returned when the last record of a segment was only partly written
+	errTornRecord = errors.New("incomplete record")
+)
+
+// location is the position of a value in a segment
+type location struct {
+	segment     uint64
+	offset      int64 // offset of the value bytes
+	size        uint32
+	recordBytes int64  // bytes of the record, for garbage accounting
+	seq         uint64 // order of the write since open, for conflict detection
+	sealed      bool   // value is encrypted
+}
+
+// segment is one append-only log file
+type segment struct {
+	id       uint64
+	path     string
+	file     *os.File
+	size     int64
+	keys     *keyring // opens sealed records
+	refs     int      // open iterators reading this segment
+	obsolete bool     // replaced by compaction; removed once refs drops to 0
+}
+
+// segmentName returns the file name of a segment id
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%06d%s", id, segmentExt)
+}
+
+// listSegments returns the ids of the segment files in dir in ascending order
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != segmentExt {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// openSegment opens or creates a segment file for reading and appending
+func openSegment(dir string, id uint64, keys *keyring) (*segment, error) {
+	path := filepath.Join(dir, segmentName(id))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &segment{id: id, path: path, file: file, size: info.Size(), keys: keys}, nil
+}
+
+// createSegment creates a new segment file and makes its directory entry durable
+func createSegment(dir string, id uint64, keys *keyring) (*segment, error) {
+	seg, err := openSegment(dir, id, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncDir(dir); err != nil {
+		seg.file.Close()
+		return nil, err
+	}
+
+	return seg, nil
+}
+
+// syncDir fsyncs a directory so created, renamed and removed entries survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// truncate cuts the segment back to size bytes
+func (s *segment) truncate(size int64) error {
+	if err := s.file.Truncate(size); err != nil {
+		return err
+	}
+	s.size = size
+	return s.file.Sync()
+}
+
+// remove closes and deletes the segment file
+func (s *segment) remove() error {
+	s.file.Close()
+	return os.Remove(s.path)
+}
+
+// readValue reads the value of key at a location
+func (s *segment) readValue(loc location, key string) ([]byte, error) {
+	value := make([]byte, loc.size)
+	if _, err := s.file.ReadAt(value, loc.offset); err != nil {
+		return nil, err
+	}
+
+	if loc.sealed {
+		return s.keys.open(value, []byte(key))
+	}
+	return value, nil
+}
+
+// encodeRecord encodes a put or delete record
+func encodeRecord(kind byte, key string, value []byte) []byte {
+	body := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	body = binary.AppendUvarint(body, uint64(len(key)))
+	body = append(body, key...)
+	body = append(body, value...)
+
+	record := make([]byte, recordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(body)))
+	record[8] = kind
+	copy(record[recordHeaderSize:], body)
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[8:], crcTable))
+
+	return record
+}
+
+// encodeBatch encodes the operations of a batch as a single record, so the
+// checksum covers the whole batch and a torn write discards all of it
+func encodeBatch(ops []batchOp) []byte {
+	size := binary.MaxVarintLen64
+	for _, op := range ops {
+		size += 1 + 2*binary.MaxVarintLen64 + len(op.key) + len(op.value)
+	}
+
+	body := make([]byte, 0, size)
+	body = binary.AppendUvarint(body, uint64(len(ops)))
+	for _, op := range ops {
+		body = append(body, op.kind)
+		body = binary.AppendUvarint(body, uint64(len(op.key)))
+		body = append(body, op.key...)
+		body = binary.AppendUvarint(body, uint64(len(op.value)))
+		body = append(body, op.value...)
+	}
+
+	record := make([]byte, recordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(body)))
+	record[8] = recordBatch
+	copy(record[recordHeaderSize:], body)
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[8:], crcTable))
+
+	return record
+}
+
+// scannedRecord is one put or delete read back from a segment
+type scannedRecord struct {
+	kind  byte
+	key   string
+	loc   location
+	value []byte
+}
+
+// scanSegment calls fn for every put and delete in a segment in order. It
+// returns the offset just past the last valid record, errTornRecord if the
+// segment ends in a partly written record and errCorruptRecord if a damaged
+// record is found.
+func scanSegment(s *segment, fn func(rec *scannedRecord) error) (int64, error) {
+	header := make([]byte, recordHeaderSize)
+	offset := int64(0)
+
+	for offset < s.size {
+		if _, err := s.file.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				return offset, errTornRecord
+			}
+			return offset, err
+		}
+
+		bodyLen := int64(binary.LittleEndian.Uint32(header[4:8]))
+		end := offset + recordHeaderSize + bodyLen
+		if end > s.size {
+			return offset, errTornRecord
+		}
+
+		data := make([]byte, 1+bodyLen)
+		data[0] = header[8]
+		if _, err := s.file.ReadAt(data[1:], offset+recordHeaderSize); err != nil {
+			return offset, err
+		}
+
+		if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(header[0:4]) {
+			// A bad final record is a write cut short; anything after it means damage
+			if end == s.size {
+				return offset, errTornRecord
+			}
+			return offset, errCorruptRecord
+		}
+
+		recs, err := decodeRecord(s, offset, data[0], data[1:])
+		if err != nil {
+			return offset, err
+		}
+
+		for _, rec := range recs {
+			if err := fn(rec); err != nil {
+				return offset, err
+			}
+		}
+
+		offset = end
+	}
+
+	return offset, nil
+}
+
+// decodeRecord decodes the operations of a record body found at offset in a segment
+func decodeRecord(s *segment, offset int64, kind byte, body []byte) ([]*scannedRecord, error) {
+	if kind == recordBatch {
+		return decodeBatch(s, offset, body)
+	}
+
+	rec, err := decodeOp(s, offset, kind, body)
+	if err != nil {
+		return nil, err
+	}
+	return []*scannedRecord{rec}, nil
+}
+
+// decodeOp decodes a single put or delete record
+func decodeOp(s *segment, offset int64, kind byte, body []byte) (*scannedRecord, error) {
+	keyLen, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < keyLen {
+		return nil, errCorruptRecord
+	}
+
+	valueStart := n + int(keyLen)
+	rec, err := newScannedRecord(s, kind, body[n:valueStart])
+	if err != nil {
+		return nil, err
+	}
+
+	switch rec.kind {
+	case recordPut:
+		rec.value = body[valueStart:]
+		rec.loc.offset = offset + recordHeaderSize + int64(valueStart)
+		rec.loc.size = uint32(len(body) - valueStart)
+	case recordDelete:
+	default:
+		return nil, errCorruptRecord
+	}
+	rec.loc.recordBytes = recordHeaderSize + int64(len(body))
+
+	return rec, nil
+}
+
+// newScannedRecord starts decoding an operation, opening its key if sealed
+func newScannedRecord(s *segment, kind byte, rawKey []byte) (*scannedRecord, error) {
+	key := string(rawKey)
+	if kind&flagSealedKey != 0 {
+		opened, err := s.keys.open(rawKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		key = string(opened)
+	}
+
+	return &scannedRecord{
+		kind: kind & kindMask,
+		key:  key,
+		loc:  location{segment: s.id, sealed: kind&flagSealedValue != 0},
+	}, nil
+}
+
+// decodeBatch decodes the operations of a batch record
+func decodeBatch(s *segment, offset int64, body []byte) ([]*scannedRecord, error) {
+	count, countLen := binary.Uvarint(body)
+	if countLen <= 0 || count > uint64(len(body)) {
+		return nil, errCorruptRecord
+	}
+
+	recs := make([]*scannedRecord, 0, count)
+	pos := countLen
+	for i := uint64(0); i < count; i++ {
+		start := pos
+		if pos >= len(body) {
+			return nil, errCorruptRecord
+		}
+		kind := body[pos]
+		pos++
+
+		keyLen, n := binary.Uvarint(body[pos:])
+		if n <= 0 || uint64(len(body)-pos-n) < keyLen {
+			return nil, errCorruptRecord
+		}
+		rec, err := newScannedRecord(s, kind, body[pos+n:pos+n+int(keyLen)])
+		if err != nil {
+			return nil, err
+		}
+		pos += n + int(keyLen)
+
+		valueLen, n := binary.Uvarint(body[pos:])
+		if n <= 0 || uint64(len(body)-pos-n) < valueLen {
+			return nil, errCorruptRecord
+		}
+		pos += n
+
+		switch rec.kind {
+		case recordPut:
+			rec.value = body[pos : pos+int(valueLen)]
+			rec.loc.offset = offset + recordHeaderSize + int64(pos)
+			rec.loc.size = uint32(valueLen)
+		case recordDelete:
+		default:
+			return nil, errCorruptRecord
+		}
+		pos += int(valueLen)
+		rec.loc.recordBytes = int64(pos - start)
+
+		recs = append(recs, rec)
+	}
+
+	if pos != len(body) {
+		return nil, errCorruptRecord
+	}
+
+	// Charge the batch header to the first operation
+	if len(recs) > 0 {
+		recs[0].loc.recordBytes += recordHeaderSize + int64(countLen)
+	}
+
+	return recs, nil
+}
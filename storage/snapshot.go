@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrSnapshotReleased is returned when a released snapshot is used
+var ErrSnapshotReleased = errors.New("snapshot released")
+
+// Snapshot is a read-only view of a store at a point in time. Writes made
+// after it was taken are not visible through it. It must be released when
+// done so the store can reclaim the space it holds.
+type Snapshot interface {
+	// Get retrieves a value by key
+	Get(key string) ([]byte, error)
+	// GetJSON retrieves and decodes a JSON value
+	GetJSON(key string, dest interface{}) error
+	// Has checks if a key exists
+	Has(key string) bool
+	// NewIterator returns an iterator over the keys in r, or every key if r
+	// is nil. The iterator stays usable after the snapshot is released.
+	NewIterator(r *Range) Iterator
+	// Release frees the snapshot. It is safe to call more than once.
+	Release()
+}
+
+// dbSnapshot is a snapshot of a LevelDB
+type dbSnapshot struct {
+	db       *LevelDB
+	root     *indexNode
+	segments map[uint64]*segment
+	released bool
+}
+
+// GetSnapshot takes a snapshot of the current state of the database
+func (db *LevelDB) GetSnapshot() (Snapshot, error) {
+	return db.snapshot()
+}
+
+// snapshot takes a snapshot of the current state of the database
+func (db *LevelDB) snapshot() (*dbSnapshot, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	return &dbSnapshot{db: db, root: db.root, segments: db.pin(db.segments)}, nil
+}
+
+// Get retrieves a value by key
+func (s *dbSnapshot) Get(key string) ([]byte, error) {
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	return getNode(s.root, s.segments, key)
+}
+
+// GetJSON retrieves and decodes a JSON value
+func (s *dbSnapshot) GetJSON(key string, dest interface{}) error {
+	data, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// Has checks if a key exists
+func (s *dbSnapshot) Has(key string) bool {
+	_, exists := indexGet(s.root, key)
+	return !s.released && exists
+}
+
+// NewIterator returns an iterator over the keys of the snapshot in r
+func (s *dbSnapshot) NewIterator(r *Range) Iterator {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if s.released {
+		return errIterator(ErrSnapshotReleased)
+	}
+
+	segments := s.db.pin(s.segments)
+	return newIterator(s.root, segments, func() { s.db.unpin(segments) }, r)
+}
+
+// Release frees the snapshot
+func (s *dbSnapshot) Release() {
+	s.db.mutex.Lock()
+	if s.released {
+		s.db.mutex.Unlock()
+		return
+	}
+	s.released = true
+	s.db.mutex.Unlock()
+
+	s.db.unpin(s.segments)
+}